@@ -13,10 +13,13 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"net/http"
 	"os"
+	"time"
 
 	restful "github.com/emicklei/go-restful"
+	"github.com/tektoncd/dashboard/pkg/archive"
 	endpoints "github.com/tektoncd/dashboard/pkg/endpoints"
 	logging "github.com/tektoncd/dashboard/pkg/logging"
 	clientset "github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
@@ -26,6 +29,9 @@ import (
 	"k8s.io/sample-controller/pkg/signals"
 )
 
+// How long requests and websocket clients are given to finish when shutting down
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	var cfg *rest.Config
 	var err error
@@ -68,16 +74,48 @@ func main() {
 		K8sClient:      k8sClient,
 	}
 
+	logArchiveDir := os.Getenv("LOG_ARCHIVE_DIR")
+	if logArchiveDir != "" {
+		logArchive, err := archive.NewFilesystemStore(logArchiveDir)
+		if err != nil {
+			logging.Log.Errorf("Error creating the log archive in %s: %s", logArchiveDir, err.Error())
+		} else {
+			resource.LogArchive = logArchive
+			logging.Log.Infof("Archiving TaskRun logs in %s", logArchiveDir)
+		}
+	}
+	resource.TestResultsDir = os.Getenv("TEST_RESULTS_DIR")
+
+	// The controllers are started first, the websocket uses the PipelineRun controller's cache
+	stopCh := signals.SetupSignalHandler()
+	resource.StartPipelineRunController(stopCh)
+	resource.StartTaskRunController(stopCh)
+
 	logging.Log.Info("Registering REST endpoints")
 	resource.RegisterEndpoints(wsContainer)
 	resource.RegisterWebsocket(wsContainer)
 	resource.RegisterHealthProbes(wsContainer)
 	resource.RegisterReadinessProbes(wsContainer)
 
-	stopCh := signals.SetupSignalHandler()
-	resource.StartPipelineRunController(stopCh)
-
 	logging.Log.Infof("Creating server and entering wait loop")
 	server := &http.Server{Addr: port, Handler: wsContainer}
-	logging.Log.Fatal(server.ListenAndServe())
+	shutdown := make(chan struct{})
+	go func() {
+		<-stopCh
+		logging.Log.Info("Shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		// Websocket connections are hijacked so the server doesn't close them
+		if err := endpoints.ShutdownWebsockets(ctx); err != nil {
+			logging.Log.Errorf("Error closing websocket connections: %s", err.Error())
+		}
+		if err := server.Shutdown(ctx); err != nil {
+			logging.Log.Errorf("Error shutting down the server: %s", err.Error())
+		}
+		close(shutdown)
+	}()
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		logging.Log.Fatal(err)
+	}
+	<-shutdown
 }
@@ -0,0 +1,95 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package archive
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound - returned when nothing is stored under a key
+var ErrNotFound = errors.New("not found in the log archive")
+
+// Store - where archived logs are kept. Keys are slash separated paths, such as namespace/taskrun/container,
+// so a store can be a directory or an object store such as S3
+type Store interface {
+	// Stores everything read from content under the key, replacing anything already stored
+	Write(key string, content io.Reader) error
+	// Returns what is stored under the key or ErrNotFound
+	Read(key string) (io.ReadCloser, error)
+}
+
+// FilesystemStore - stores each key as a file under a directory
+type FilesystemStore struct {
+	dir string
+}
+
+// NewFilesystemStore - creates the directory if it doesn't exist
+func NewFilesystemStore(dir string) (*FilesystemStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FilesystemStore{dir: dir}, nil
+}
+
+// Content is written to a temporary file first so a partly written key is never read
+func (s *FilesystemStore) Write(key string, content io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(path), ".archive-")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+func (s *FilesystemStore) Read(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+// Keys must stay within the directory
+func (s *FilesystemStore) path(key string) (string, error) {
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", errors.New("invalid log archive key: " + key)
+		}
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
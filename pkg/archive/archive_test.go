@@ -0,0 +1,60 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package archive
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+/* Filesystem store test: what is written can be read back, and keys can't leave the directory */
+
+func TestFilesystemStore(t *testing.T) {
+	t.Log("Testing the filesystem log archive")
+
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatalf("Error creating a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewFilesystemStore(dir + "/logs")
+	if err != nil {
+		t.Fatalf("FAIL: error creating the store: %s", err)
+	}
+
+	for _, content := range []string{"first log\n", "second log\n"} {
+		if err := store.Write("ns1/taskrun1/containers/build-step-one.log", strings.NewReader(content)); err != nil {
+			t.Fatalf("FAIL: error writing %q: %s", content, err)
+		}
+	}
+	reader, err := store.Read("ns1/taskrun1/containers/build-step-one.log")
+	if err != nil {
+		t.Fatalf("FAIL: error reading what was written: %s", err)
+	}
+	read, _ := ioutil.ReadAll(reader)
+	reader.Close()
+	if string(read) != "second log\n" {
+		t.Errorf("FAIL: the last write should have been read, got %q", read)
+	}
+
+	if _, err := store.Read("ns1/taskrun2/manifest.json"); err != ErrNotFound {
+		t.Errorf("FAIL: reading a missing key should have given ErrNotFound, got %v", err)
+	}
+	for _, key := range []string{"../outside", "ns1/../../outside", "/outside", "ns1//taskrun1"} {
+		if err := store.Write(key, strings.NewReader("")); err == nil {
+			t.Errorf("FAIL: %q should have been an invalid key", key)
+		}
+	}
+}
@@ -13,8 +13,12 @@ limitations under the License.
 package broadcaster
 
 import (
+	"context"
 	"errors"
 	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 type messageType string
@@ -25,36 +29,154 @@ const (
 	PipelineRunCreated messageType = "PipelineRunCreated"
 	PipelineRunDeleted messageType = "PipelineRunDeleted"
 	PipelineRunUpdated messageType = "PipelineRunUpdated"
+	// Sent before a subscriber is disconnected for falling behind, it has missed messages
+	ResyncRequired messageType = "ResyncRequired"
+	// Sent first to a resumed subscriber whose missed messages can't be replayed, it must list again.
+	// Its sequence number is the one to resume from later
+	Reset messageType = "Reset"
+	// Sent first to a new subscriber, the PipelineRuns it is subscribed to
+	PipelineRunSnapshot messageType = "PipelineRunSnapshot"
+	// Sent after a snapshot, with the sequence number the messages that follow start after
+	Bookmark messageType = "Bookmark"
 )
 
 type SocketData struct {
 	MessageType messageType
 	Payload     interface{}
+	// Set when broadcast, increasing by one for each message
+	Sequence uint64 `json:",omitempty"`
+	// Only used to filter messages, not sent to subscribers
+	Subject Subject `json:"-"`
+}
+
+// The object a message is about
+type Subject struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Labels    map[string]string
+}
+
+// Messages a subscriber receives, empty fields match any message
+type Filter struct {
+	MessageTypes  []string
+	Kind          string
+	Namespace     string
+	Name          string
+	LabelSelector labels.Selector
+}
+
+func (f Filter) Matches(data SocketData) bool {
+	if len(f.MessageTypes) > 0 {
+		found := false
+		for _, messageType := range f.MessageTypes {
+			if messageType == string(data.MessageType) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	subject := data.Subject
+	if (f.Kind != "" && f.Kind != subject.Kind) ||
+		(f.Namespace != "" && f.Namespace != subject.Namespace) ||
+		(f.Name != "" && f.Name != subject.Name) {
+		return false
+	}
+	return f.LabelSelector == nil || f.LabelSelector.Matches(labels.Set(subject.Labels))
+}
+
+// Messages of the same type about the same object, where only the latest matters. Log lines never coalesce
+func (d SocketData) coalesces(other SocketData) bool {
+	return d.MessageType != Log && d.MessageType == other.MessageType && d.Subject.Name != "" &&
+		d.Subject.Kind == other.Subject.Kind && d.Subject.Namespace == other.Subject.Namespace && d.Subject.Name == other.Subject.Name
 }
 
-// Only a pointer to the struct should be used
+// What happens when a message is broadcast to a subscriber whose queue is full
+type OverflowPolicy int
+
+const (
+	// Drop the oldest queued message
+	DropOldest OverflowPolicy = iota
+	// Drop the queued message about the same object, or the oldest if there's none
+	Coalesce
+	// Drop the queued messages, send ResyncRequired then unsubscribe
+	Disconnect
+)
+
+const DefaultQueueSize = 256
+
+type Options struct {
+	// Messages queued for each subscriber, DefaultQueueSize if not set
+	QueueSize int
+	Overflow  OverflowPolicy
+	// Recent messages kept to replay to resumed subscribers, none if not set
+	ReplaySize int
+}
+
+// Subscription - the messages a subscriber receives
+type Subscription struct {
+	Filter Filter
+	// Resume after the message with this sequence number, replaying the messages since. 0 for only new messages
+	Since uint64
+}
+
+// Only a pointer to the struct should be used. Safe to use from any goroutine
 type Broadcaster struct {
+	c       chan SocketData
+	options Options
+	// Guards the fields below
+	mutex       sync.RWMutex
 	expired     bool
-	subscribers *sync.Map //map[&Subscriber]struct{}
-	//c chan interface{}
-	c chan SocketData
+	subscribers map[*Subscriber]struct{}
+	// Of the last message broadcast
+	sequence uint64
+	replay   messageQueue
 }
 
 type Subscriber struct {
 	subChan   chan SocketData
 	unsubChan chan struct{}
+	filter    Filter
+	unsubOnce sync.Once
+	// Signalled when a message is queued or the subscriber starts draining
+	queued chan struct{}
+	// Guards the fields below, which the broadcaster and the subscriber's delivery both use
+	mutex   sync.Mutex
+	queue   messageQueue
+	dropped int
+	// Nothing more is queued once ResyncRequired is
+	overflowed bool
+	// The broadcaster has stopped, unsubscribe once the queue is empty
+	draining bool
+	// Of the last message broadcast before subscribing
+	sequence uint64
 }
 
-// Open or nil, never closed
+// Open, never closed
 func (s *Subscriber) SubChan() <-chan SocketData {
 	return s.subChan
 }
 
-// Closed on unsubscribe or when broadcast parent channel closes
+// Closed on unsubscribe, when the context subscribed with is done or when the broadcaster stops
 func (s *Subscriber) UnsubChan() <-chan struct{} {
 	return s.unsubChan
 }
 
+// Sequence number of the last message broadcast before subscribing, the subscriber is sent the messages after it
+func (s *Subscriber) Sequence() uint64 {
+	return s.sequence
+}
+
+// Messages dropped or replaced because the subscriber fell behind
+func (s *Subscriber) Dropped() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.dropped
+}
+
 type expiredError struct{}
 
 func (e expiredError) Error() string {
@@ -64,75 +186,284 @@ func (e expiredError) Error() string {
 // Creates broadcaster from channel parameter and immediately starts broadcasting
 // Broadcaster should be the only channel reader
 func NewBroadcaster(c chan SocketData) *Broadcaster {
+	return NewBroadcasterWithOptions(c, Options{})
+}
+
+/* Creates a broadcaster that queues up to options.QueueSize messages for each subscriber.
+ * Broadcasting never waits for subscribers, a subscriber that falls behind is handled by the overflow policy.
+ * Broadcasting stops on Close or Shutdown, or when the channel is closed which drains the subscribers as Shutdown does.
+ * The channel is read until it is closed, so sending to it never blocks even once broadcasting has stopped
+ */
+func NewBroadcasterWithOptions(c chan SocketData, options Options) *Broadcaster {
 	if c == nil {
 		panic("Channel passed cannot be nil")
 	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = DefaultQueueSize
+	}
 
-	b := &Broadcaster{subscribers: new(sync.Map)}
-	b.c = c
+	b := &Broadcaster{
+		c:           c,
+		options:     options,
+		subscribers: make(map[*Subscriber]struct{}),
+		// Starting from the time in microseconds, sequence numbers keep increasing when the server restarts,
+		// so a client resuming from before the restart is reset. They are still exact as JavaScript numbers
+		sequence: uint64(time.Now().UnixNano() / int64(time.Microsecond)),
+		replay:   newMessageQueue(options.ReplaySize),
+	}
 	go func() {
-		for {
-			msg, channelOpen := <-b.c
-			if channelOpen {
-				b.subscribers.Range(func(key, value interface{}) bool {
-					subscriber := key.(*Subscriber)
-					select {
-					case subscriber.subChan <- msg:
-					case <-subscriber.unsubChan:
-					}
-					return true
-				})
-			} else {
-				b.expired = true
-				b.subscribers.Range(func(key, value interface{}) bool {
-					subscriber := key.(*Subscriber)
-					subscriber.subChan = nil
-					return true
-				})
-				// Remove references
-				b.subscribers = nil
-			}
+		// Still read once expired and the messages dropped, so senders never block on a stopped broadcaster
+		for msg := range b.c {
+			b.broadcast(msg)
+		}
+		for _, subscriber := range b.expire() {
+			subscriber.drain()
 		}
 	}()
 	return b
 }
 
-// Subscriber expected to constantly consume or unsubscribe
-func (b *Broadcaster) Subscribe() (*Subscriber, error) {
+func (b *Broadcaster) broadcast(msg SocketData) {
+	// Not a read lock, so a resumed subscriber is replayed exactly the messages before it is added
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	// Nothing is queued once expired, so subscribers being drained get no more messages
+	if b.expired {
+		return
+	}
+	b.sequence++
+	msg.Sequence = b.sequence
+	if b.options.ReplaySize > 0 {
+		if b.replay.full() {
+			b.replay.pop()
+		}
+		b.replay.push(msg)
+	}
+	for subscriber := range b.subscribers {
+		if subscriber.filter.Matches(msg) {
+			subscriber.enqueue(msg, b.options.Overflow)
+		}
+	}
+}
 
+// Stops broadcasting, returning the subscribers. Nil if already expired
+func (b *Broadcaster) expire() []*Subscriber {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
 	if b.expired {
-		return &Subscriber{}, expiredError{}
+		return nil
+	}
+	b.expired = true
+	subscribers := make([]*Subscriber, 0, len(b.subscribers))
+	for subscriber := range b.subscribers {
+		subscribers = append(subscribers, subscriber)
 	}
+	return subscribers
+}
+
+// Subscriber expected to constantly consume or unsubscribe
+func (b *Broadcaster) Subscribe() (*Subscriber, error) {
+	return b.SubscribeContext(context.Background(), Subscription{})
+}
+
+// Subscribe to only the messages matching the filter
+func (b *Broadcaster) SubscribeWithFilter(filter Filter) (*Subscriber, error) {
+	return b.SubscribeContext(context.Background(), Subscription{Filter: filter})
+}
+
+/* Subscribe until the context is done.
+ * A resumed subscriber is first sent the matching messages it missed, more than the queue size are handled by the overflow policy.
+ * If some of them are no longer kept it is sent Reset instead
+ */
+func (b *Broadcaster) SubscribeContext(ctx context.Context, subscription Subscription) (*Subscriber, error) {
 	newSub := &Subscriber{
 		subChan:   make(chan SocketData),
 		unsubChan: make(chan struct{}),
+		filter:    subscription.Filter,
+		queued:    make(chan struct{}, 1),
+		queue:     newMessageQueue(b.options.QueueSize),
+	}
+	b.mutex.Lock()
+	if b.expired {
+		b.mutex.Unlock()
+		return &Subscriber{}, expiredError{}
+	}
+	if subscription.Since != 0 {
+		b.replaySince(newSub, subscription.Since)
+	}
+	newSub.sequence = b.sequence
+	b.subscribers[newSub] = struct{}{}
+	b.mutex.Unlock()
+
+	go b.deliver(newSub)
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				b.Unsubscribe(newSub)
+			case <-newSub.unsubChan:
+			}
+		}()
 	}
-	// Generate unique key
-	b.subscribers.Store(newSub, struct{}{})
 	return newSub, nil
 }
 
-// Should not be called from parallel goroutines, may panic
+// Must hold the lock
+func (b *Broadcaster) replaySince(s *Subscriber, since uint64) {
+	if since == b.sequence {
+		return
+	}
+	oldest, kept := b.replay.peek()
+	if since > b.sequence || !kept || oldest.Sequence > since+1 {
+		s.enqueue(SocketData{MessageType: Reset, Sequence: b.sequence}, b.options.Overflow)
+		return
+	}
+	b.replay.each(func(msg SocketData) {
+		if msg.Sequence > since && s.filter.Matches(msg) {
+			s.enqueue(msg, b.options.Overflow)
+		}
+	})
+}
+
+// May be called more than once, and from any goroutine. A subscriber is still unsubscribed once the broadcaster has expired
 func (b *Broadcaster) Unsubscribe(sub *Subscriber) error {
-	if b.expired {
+	b.mutex.Lock()
+	_, ok := b.subscribers[sub]
+	delete(b.subscribers, sub)
+	expired := b.expired
+	b.mutex.Unlock()
+
+	if ok {
+		sub.close()
+	}
+	if expired {
 		return expiredError{}
 	}
-	if _, ok := b.subscribers.Load(sub); ok {
-		b.subscribers.Delete(sub)
-		sub.subChan = nil
-		close(sub.unsubChan)
-		return nil
+	if !ok {
+		return errors.New("Subscription not found")
+	}
+	return nil
+}
+
+// Stops broadcasting and unsubscribes everyone straight away, dropping the messages queued for them
+func (b *Broadcaster) Close() error {
+	subscribers := b.expire()
+	if subscribers == nil {
+		return expiredError{}
+	}
+	for _, subscriber := range subscribers {
+		b.Unsubscribe(subscriber)
+	}
+	return nil
+}
+
+/* Stops broadcasting and unsubscribes each subscriber once it has been sent the messages queued for it.
+ * If the context is done first, the subscribers still being drained are unsubscribed straight away and its error is returned
+ */
+func (b *Broadcaster) Shutdown(ctx context.Context) error {
+	subscribers := b.expire()
+	if subscribers == nil {
+		return expiredError{}
+	}
+	for _, subscriber := range subscribers {
+		subscriber.drain()
 	}
-	return errors.New("Subscription not found")
+	for _, subscriber := range subscribers {
+		select {
+		case <-subscriber.unsubChan:
+		case <-ctx.Done():
+			for _, subscriber := range subscribers {
+				b.Unsubscribe(subscriber)
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
 }
 
-func (b *Broadcaster) PoolSize() (size int) {
+func (b *Broadcaster) PoolSize() int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
 	if b.expired {
 		return 0
 	}
-	b.subscribers.Range(func(key, value interface{}) bool {
-		size++
-		return true
+	return len(b.subscribers)
+}
+
+func (s *Subscriber) close() {
+	s.unsubOnce.Do(func() {
+		close(s.unsubChan)
 	})
-	return
+}
+
+func (s *Subscriber) drain() {
+	s.mutex.Lock()
+	s.draining = true
+	s.mutex.Unlock()
+	s.signal()
+}
+
+func (s *Subscriber) signal() {
+	select {
+	case s.queued <- struct{}{}:
+	default:
+	}
+}
+
+// Never blocks, if the queue is full the overflow policy makes room
+func (s *Subscriber) enqueue(msg SocketData, policy OverflowPolicy) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.overflowed {
+		return
+	}
+	if s.queue.full() {
+		s.dropped++
+		switch policy {
+		case Coalesce:
+			// Queued after the others rather than in place, so sequence numbers are always received in order
+			if !s.queue.removeCoalesced(msg) {
+				s.queue.pop()
+			}
+		case Disconnect:
+			s.overflowed = true
+			s.queue.clear()
+			msg = SocketData{MessageType: ResyncRequired}
+		default:
+			s.queue.pop()
+		}
+	}
+	s.queue.push(msg)
+	s.signal()
+}
+
+// Sends the subscriber its queued messages in order until it unsubscribes, or has been drained
+func (b *Broadcaster) deliver(s *Subscriber) {
+	for {
+		s.mutex.Lock()
+		msg, ok := s.queue.pop()
+		draining := s.draining
+		s.mutex.Unlock()
+		if !ok {
+			if draining {
+				b.Unsubscribe(s)
+				return
+			}
+			select {
+			case <-s.queued:
+				continue
+			case <-s.unsubChan:
+				return
+			}
+		}
+		select {
+		case s.subChan <- msg:
+		case <-s.unsubChan:
+			return
+		}
+		if msg.MessageType == ResyncRequired {
+			b.Unsubscribe(s)
+			return
+		}
+	}
 }
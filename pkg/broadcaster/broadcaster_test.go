@@ -0,0 +1,470 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package broadcaster
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+/* Filter test: each field of a filter narrows the messages matched */
+
+func TestFilterMatches(t *testing.T) {
+	t.Log("Testing messages are matched against filters")
+
+	data := SocketData{
+		MessageType: PipelineRunUpdated,
+		Subject:     Subject{Kind: "PipelineRun", Namespace: "ns1", Name: "pipelinerun1", Labels: map[string]string{"app": "dashboard"}},
+	}
+	tests := map[string]struct {
+		filter  Filter
+		matches bool
+	}{
+		"empty":                {Filter{}, true},
+		"all fields":           {Filter{MessageTypes: []string{"PipelineRunCreated", "PipelineRunUpdated"}, Kind: "PipelineRun", Namespace: "ns1", Name: "pipelinerun1", LabelSelector: labels.SelectorFromSet(labels.Set{"app": "dashboard"})}, true},
+		"other message type":   {Filter{MessageTypes: []string{"PipelineRunCreated"}}, false},
+		"other kind":           {Filter{Kind: "TaskRun"}, false},
+		"other namespace":      {Filter{Namespace: "ns2"}, false},
+		"other name":           {Filter{Name: "pipelinerun2"}, false},
+		"other labels":         {Filter{LabelSelector: labels.SelectorFromSet(labels.Set{"app": "other"})}, false},
+		"label does not exist": {Filter{LabelSelector: labels.SelectorFromSet(labels.Set{"team": "a"})}, false},
+	}
+	for name, test := range tests {
+		if matches := test.filter.Matches(data); matches != test.matches {
+			t.Errorf("FAIL: %s filter expected %t, got %t", name, test.matches, matches)
+		}
+	}
+}
+
+/* Broadcaster test: subscribers only receive the messages matching their filter */
+
+func TestSubscribeWithFilter(t *testing.T) {
+	t.Log("Testing subscribers receive only matching messages")
+
+	c := make(chan SocketData)
+	b := NewBroadcaster(c)
+	ns1, _ := b.SubscribeWithFilter(Filter{Namespace: "ns1"})
+	all, _ := b.Subscribe()
+	defer b.Unsubscribe(ns1)
+	defer b.Unsubscribe(all)
+
+	go func() {
+		c <- SocketData{MessageType: Log, Payload: "first", Subject: Subject{Namespace: "ns2"}}
+		c <- SocketData{MessageType: Log, Payload: "second", Subject: Subject{Namespace: "ns1"}}
+	}()
+	// Subscribers are sent to one at a time, so read from both as messages arrive
+	var received, receivedNs1 []interface{}
+	for len(received)+len(receivedNs1) < 3 {
+		select {
+		case data := <-all.SubChan():
+			received = append(received, data.Payload)
+		case data := <-ns1.SubChan():
+			receivedNs1 = append(receivedNs1, data.Payload)
+		case <-time.After(time.Second * 5):
+			t.Fatalf("FAIL: timed out, received %v and %v", received, receivedNs1)
+		}
+	}
+	if !reflect.DeepEqual(received, []interface{}{"first", "second"}) {
+		t.Errorf("FAIL: the unfiltered subscriber should have received every message, got %v", received)
+	}
+	if !reflect.DeepEqual(receivedNs1, []interface{}{"second"}) {
+		t.Errorf("FAIL: the filtered subscriber should only have received the ns1 message, got %v", receivedNs1)
+	}
+}
+
+func queuedPayloads(s *Subscriber) []interface{} {
+	var payloads []interface{}
+	for {
+		msg, ok := s.queue.pop()
+		if !ok {
+			return payloads
+		}
+		payloads = append(payloads, msg.Payload)
+	}
+}
+
+/* Overflow test: each policy makes room in a full queue its own way */
+
+func TestOverflowPolicies(t *testing.T) {
+	t.Log("Testing the overflow policies")
+
+	updated := func(name string, payload interface{}) SocketData {
+		return SocketData{MessageType: PipelineRunUpdated, Payload: payload, Subject: Subject{Kind: "PipelineRun", Namespace: "ns1", Name: name}}
+	}
+	tests := []struct {
+		policy   OverflowPolicy
+		expected []interface{}
+	}{
+		{DropOldest, []interface{}{"b1", "a2", "c1"}},
+		{Coalesce, []interface{}{"a1", "b1", "c1"}},
+		{Disconnect, []interface{}{nil}},
+	}
+	for _, test := range tests {
+		s := &Subscriber{queued: make(chan struct{}, 1), queue: newMessageQueue(3)}
+		for _, msg := range []SocketData{updated("a", "a1"), updated("b", "b1"), updated("a", "a2"), updated("c", "c1")} {
+			s.enqueue(msg, test.policy)
+		}
+		if test.policy == Coalesce {
+			// Only replaced when full, then the newest message about the same object is replaced
+			s.queue.clear()
+			for _, msg := range []SocketData{updated("a", "a0"), updated("b", "b1"), updated("a", "a1"), updated("a", "a2"), {MessageType: Log, Payload: "c1"}} {
+				s.enqueue(msg, test.policy)
+			}
+			test.expected = []interface{}{"b1", "a2", "c1"}
+		}
+		if s.Dropped() == 0 {
+			t.Errorf("FAIL: policy %d should have counted the dropped messages", test.policy)
+		}
+		if payloads := queuedPayloads(s); !reflect.DeepEqual(payloads, test.expected) {
+			t.Errorf("FAIL: policy %d expected %v queued, got %v", test.policy, test.expected, payloads)
+		}
+	}
+}
+
+/* Broadcaster test: a subscriber that doesn't read doesn't hold up the others */
+
+func TestSlowSubscriber(t *testing.T) {
+	t.Log("Testing a slow subscriber doesn't block broadcasting")
+
+	c := make(chan SocketData)
+	b := NewBroadcasterWithOptions(c, Options{QueueSize: 2, Overflow: Disconnect})
+	slow, _ := b.Subscribe()
+	fast, _ := b.Subscribe()
+	defer b.Unsubscribe(fast)
+
+	// The fast subscriber reads each message before the next is sent, the slow one never reads
+	for i := 0; i < 10; i++ {
+		c <- SocketData{MessageType: Log, Payload: i}
+		select {
+		case data := <-fast.SubChan():
+			if data.Payload != i {
+				t.Errorf("FAIL: expected %d, got %v", i, data.Payload)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("FAIL: timed out waiting for message %d", i)
+		}
+	}
+
+	// Whatever the slow subscriber had been sent before falling behind, it ends with ResyncRequired
+	for {
+		select {
+		case data := <-slow.SubChan():
+			if data.MessageType != ResyncRequired {
+				continue
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("FAIL: timed out waiting for ResyncRequired")
+		}
+		break
+	}
+	select {
+	case <-slow.UnsubChan():
+	case <-time.After(time.Second * 5):
+		t.Fatalf("FAIL: the slow subscriber should have been unsubscribed")
+	}
+}
+
+func benchmarkBroadcast(bench *testing.B, subscribers int, reading bool) {
+	c := make(chan SocketData)
+	b := NewBroadcasterWithOptions(c, Options{Overflow: DropOldest})
+	defer b.Close()
+	var received sync.WaitGroup
+	for i := 0; i < subscribers; i++ {
+		subscriber, _ := b.Subscribe()
+		if !reading {
+			continue
+		}
+		received.Add(1)
+		go func() {
+			defer received.Done()
+			// Messages may be dropped if the reader falls behind, but never the newest
+			for data := range subscriber.SubChan() {
+				if data.Payload == bench.N-1 {
+					return
+				}
+			}
+		}()
+	}
+
+	bench.ResetTimer()
+	for n := 0; n < bench.N; n++ {
+		c <- SocketData{MessageType: PipelineRunUpdated, Payload: n}
+	}
+	received.Wait()
+}
+
+func BenchmarkBroadcast1000(b *testing.B) {
+	benchmarkBroadcast(b, 1000, true)
+}
+
+func BenchmarkBroadcast5000(b *testing.B) {
+	benchmarkBroadcast(b, 5000, true)
+}
+
+// Subscribers that never read, so every message overflows their queues once they are full
+func BenchmarkBroadcast5000Stalled(b *testing.B) {
+	benchmarkBroadcast(b, 5000, false)
+}
+
+/* Lifecycle test: a subscription ends when its context is done */
+
+func TestSubscribeContext(t *testing.T) {
+	t.Log("Testing a subscription ends with its context")
+
+	b := NewBroadcaster(make(chan SocketData))
+	defer b.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	subscriber, err := b.SubscribeContext(ctx, Subscription{})
+	if err != nil {
+		t.Fatalf("FAIL: could not subscribe: %s", err)
+	}
+	cancel()
+	select {
+	case <-subscriber.UnsubChan():
+	case <-time.After(time.Second * 5):
+		t.Fatalf("FAIL: the subscriber should have been unsubscribed")
+	}
+	if b.PoolSize() != 0 {
+		t.Errorf("FAIL: the subscriber should have been removed, %d remain", b.PoolSize())
+	}
+	if err := b.Unsubscribe(subscriber); err == nil {
+		t.Errorf("FAIL: unsubscribing again should have given an error")
+	}
+}
+
+/* Lifecycle test: Shutdown delivers queued messages before unsubscribing, and gives up when its context is done */
+
+func TestShutdown(t *testing.T) {
+	t.Log("Testing subscribers are drained on shutdown")
+
+	c := make(chan SocketData)
+	b := NewBroadcaster(c)
+	reading, _ := b.Subscribe()
+	stalled, _ := b.Subscribe()
+	for i := 0; i < 3; i++ {
+		c <- SocketData{MessageType: Log, Payload: i}
+	}
+
+	var received []interface{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case data := <-reading.SubChan():
+				received = append(received, data.Payload)
+			case <-reading.UnsubChan():
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	if err := b.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("FAIL: the stalled subscriber should have made shutdown time out, got %v", err)
+	}
+	<-done
+	if !reflect.DeepEqual(received, []interface{}{0, 1, 2}) {
+		t.Errorf("FAIL: the reading subscriber should have been sent every queued message, got %v", received)
+	}
+	select {
+	case <-stalled.UnsubChan():
+	default:
+		t.Errorf("FAIL: the stalled subscriber should have been unsubscribed when shutdown timed out")
+	}
+
+	if _, err := b.Subscribe(); err == nil {
+		t.Errorf("FAIL: subscribing after shutdown should have given an error")
+	}
+	if err := b.Close(); err == nil {
+		t.Errorf("FAIL: closing after shutdown should have given an error")
+	}
+
+	// Senders don't block once broadcasting has stopped
+	sent := make(chan struct{})
+	go func() {
+		c <- SocketData{MessageType: Log, Payload: "after shutdown"}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(time.Second * 5):
+		t.Errorf("FAIL: sending after shutdown should not have blocked")
+	}
+	select {
+	case data := <-reading.SubChan():
+		t.Errorf("FAIL: nothing should have been sent after shutdown, got %v", data)
+	default:
+	}
+}
+
+/* Lifecycle test: closing the channel drains the subscribers and stops the broadcaster */
+
+func TestChannelClosed(t *testing.T) {
+	t.Log("Testing the broadcaster stops when its channel is closed")
+
+	c := make(chan SocketData)
+	b := NewBroadcaster(c)
+	subscriber, _ := b.Subscribe()
+	c <- SocketData{MessageType: Log, Payload: "last"}
+	close(c)
+
+	select {
+	case data := <-subscriber.SubChan():
+		if data.Payload != "last" {
+			t.Errorf("FAIL: expected the last message, got %v", data.Payload)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf("FAIL: timed out waiting for the last message")
+	}
+	select {
+	case <-subscriber.UnsubChan():
+	case <-time.After(time.Second * 5):
+		t.Fatalf("FAIL: the subscriber should have been unsubscribed")
+	}
+	if b.PoolSize() != 0 {
+		t.Errorf("FAIL: an expired broadcaster should have no subscribers")
+	}
+}
+
+/* Lifecycle stress test, run with -race: subscribing, unsubscribing, broadcasting and closing all at once */
+
+func TestConcurrentLifecycle(t *testing.T) {
+	t.Log("Testing concurrent use of the broadcaster")
+
+	for round := 0; round < 20; round++ {
+		c := make(chan SocketData)
+		b := NewBroadcasterWithOptions(c, Options{QueueSize: 4})
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case c <- SocketData{MessageType: Log, Payload: i}:
+				case <-stop:
+					return
+				}
+			}
+		}()
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				subscriber, err := b.SubscribeContext(ctx, Subscription{})
+				if err != nil {
+					return
+				}
+				for n := 0; n < 10; n++ {
+					select {
+					case <-subscriber.SubChan():
+					case <-subscriber.UnsubChan():
+						return
+					}
+				}
+				b.PoolSize()
+				subscriber.Dropped()
+				// Some unsubscribe twice, some leave it to their context
+				if i%2 == 0 {
+					b.Unsubscribe(subscriber)
+					b.Unsubscribe(subscriber)
+				}
+			}(i)
+		}
+
+		time.Sleep(time.Millisecond * 5)
+		if round%2 == 0 {
+			b.Close()
+		} else {
+			b.Shutdown(context.Background())
+		}
+		close(stop)
+		wg.Wait()
+	}
+}
+
+/* Replay test: resumed subscribers are sent the messages they missed, or Reset if they are no longer kept */
+
+func TestResume(t *testing.T) {
+	t.Log("Testing resumed subscriptions")
+
+	c := make(chan SocketData)
+	b := NewBroadcasterWithOptions(c, Options{ReplaySize: 3})
+	defer b.Close()
+	all, _ := b.Subscribe()
+	var sequences []uint64
+	for i := 0; i < 5; i++ {
+		c <- SocketData{MessageType: PipelineRunUpdated, Payload: i, Subject: Subject{Namespace: fmt.Sprintf("ns%d", i%2)}}
+		data := <-all.SubChan()
+		if len(sequences) > 0 && data.Sequence != sequences[len(sequences)-1]+1 {
+			t.Errorf("FAIL: expected sequence numbers to increase by one, got %d after %v", data.Sequence, sequences)
+		}
+		sequences = append(sequences, data.Sequence)
+	}
+
+	receive := func(subscription Subscription, count int) []SocketData {
+		subscriber, err := b.SubscribeContext(context.Background(), subscription)
+		if err != nil {
+			t.Fatalf("FAIL: could not subscribe: %s", err)
+		}
+		defer b.Unsubscribe(subscriber)
+		var received []SocketData
+		for len(received) < count {
+			select {
+			case data := <-subscriber.SubChan():
+				received = append(received, data)
+			case <-time.After(time.Millisecond * 100):
+				return received
+			}
+		}
+		return received
+	}
+	payloads := func(received []SocketData) []interface{} {
+		var result []interface{}
+		for _, data := range received {
+			result = append(result, data.Payload)
+		}
+		return result
+	}
+
+	if received := receive(Subscription{Since: sequences[1]}, 3); !reflect.DeepEqual(payloads(received), []interface{}{2, 3, 4}) {
+		t.Errorf("FAIL: expected the missed messages to be replayed, got %v", received)
+	}
+	if received := receive(Subscription{Since: sequences[1], Filter: Filter{Namespace: "ns0"}}, 2); !reflect.DeepEqual(payloads(received), []interface{}{2, 4}) {
+		t.Errorf("FAIL: expected only the missed messages matching the filter, got %v", received)
+	}
+	for _, since := range []uint64{sequences[0], sequences[4] + 100} {
+		received := receive(Subscription{Since: since}, 2)
+		if len(received) != 1 || received[0].MessageType != Reset || received[0].Sequence != sequences[4] {
+			t.Errorf("FAIL: resuming after %d should have been reset, got %v", since, received)
+		}
+	}
+	if received := receive(Subscription{Since: sequences[4]}, 1); len(received) != 0 {
+		t.Errorf("FAIL: nothing was missed, got %v", received)
+	}
+
+	if subscriber, _ := b.Subscribe(); subscriber.Sequence() != sequences[4] {
+		t.Errorf("FAIL: a new subscriber's sequence number should have been the last broadcast, %d, got %d", sequences[4], subscriber.Sequence())
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package broadcaster
+
+// Messages waiting to be sent to a subscriber, oldest first, up to a fixed capacity
+type messageQueue struct {
+	messages []SocketData
+	head     int
+	size     int
+}
+
+func newMessageQueue(capacity int) messageQueue {
+	return messageQueue{messages: make([]SocketData, capacity)}
+}
+
+func (q *messageQueue) full() bool {
+	return q.size == len(q.messages)
+}
+
+// The queue must not be full
+func (q *messageQueue) push(msg SocketData) {
+	q.messages[(q.head+q.size)%len(q.messages)] = msg
+	q.size++
+}
+
+func (q *messageQueue) pop() (SocketData, bool) {
+	if q.size == 0 {
+		return SocketData{}, false
+	}
+	msg := q.messages[q.head]
+	// Don't keep the payload from being collected
+	q.messages[q.head] = SocketData{}
+	q.head = (q.head + 1) % len(q.messages)
+	q.size--
+	return msg, true
+}
+
+func (q *messageQueue) clear() {
+	for q.size > 0 {
+		q.pop()
+	}
+}
+
+func (q *messageQueue) peek() (SocketData, bool) {
+	if q.size == 0 {
+		return SocketData{}, false
+	}
+	return q.messages[q.head], true
+}
+
+// Oldest first
+func (q *messageQueue) each(handle func(msg SocketData)) {
+	for i := 0; i < q.size; i++ {
+		handle(q.messages[(q.head+i)%len(q.messages)])
+	}
+}
+
+// Removes the newest queued message the message coalesces with, returning false if there's none
+func (q *messageQueue) removeCoalesced(msg SocketData) bool {
+	for i := q.size - 1; i >= 0; i-- {
+		if !msg.coalesces(q.messages[(q.head+i)%len(q.messages)]) {
+			continue
+		}
+		// Move the messages after it back one
+		for j := i; j < q.size-1; j++ {
+			q.messages[(q.head+j)%len(q.messages)] = q.messages[(q.head+j+1)%len(q.messages)]
+		}
+		q.messages[(q.head+q.size-1)%len(q.messages)] = SocketData{}
+		q.size--
+		return true
+	}
+	return false
+}
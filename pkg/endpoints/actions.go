@@ -0,0 +1,371 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	jsonpatch "github.com/evanphx/json-patch"
+	logging "github.com/tektoncd/dashboard/pkg/logging"
+	"github.com/tektoncd/dashboard/pkg/utils"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// RunActionBody - represents the actions a user may request against a PipelineRun or TaskRun
+// Every field is optional but at least one action must be provided
+type RunActionBody struct {
+	/* Example payload
+	{
+		"resourceVersion": "12345",
+		"status": "PipelineRunCancelled", (or "TaskRunCancelled" for a TaskRun)
+		"timeout": "1h30m",
+		"addLabels": {"team": "foo"},
+		"removeLabels": ["old"],
+		"addAnnotations": {"note": "retried by hand"},
+		"removeAnnotations": [],
+		"spec": {"serviceAccount": "builder"}
+	}
+	*/
+	// When set the actions are only applied if the run has not changed since this version
+	RESOURCEVERSION   string            `json:"resourceVersion"`
+	STATUS            string            `json:"status"`
+	TIMEOUT           string            `json:"timeout"`
+	ADDLABELS         map[string]string `json:"addLabels"`
+	REMOVELABELS      []string          `json:"removeLabels"`
+	ADDANNOTATIONS    map[string]string `json:"addAnnotations"`
+	REMOVEANNOTATIONS []string          `json:"removeAnnotations"`
+	// JSON merge patch (RFC 7386) of the run spec, restricted to the patchable fields below
+	SPEC json.RawMessage `json:"spec,omitempty"`
+}
+
+// Spec fields that may be changed through a merge patch
+var patchablePipelineRunFields = map[string]bool{
+	"params":         true,
+	"resources":      true,
+	"serviceAccount": true,
+	"timeout":        true,
+}
+
+var patchableTaskRunFields = map[string]bool{
+	"serviceAccount": true,
+	"timeout":        true,
+}
+
+const pipelineRunCancelled = v1alpha1.PipelineRunSpecStatus("PipelineRunCancelled")
+const taskRunCancelled = v1alpha1.TaskRunSpecStatus("TaskRunCancelled")
+
+/* Apply a set of actions to a given PipelineRun by name in a given namespace */
+func (r Resource) patchPipelineRun(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In patchPipelineRun, name: %s, namespace: %s", name, namespace)
+
+	actionBody := RunActionBody{}
+	if err := request.ReadEntity(&actionBody); err != nil {
+		logging.Log.Errorf("error decoding the PipelineRun action request body: %s", err)
+		utils.RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+
+	pipelineRun, ok := r.applyPipelineRunActions(name, namespace, actionBody, response)
+	if !ok {
+		return
+	}
+	response.WriteEntity(pipelineRun)
+}
+
+/* Apply a set of actions to a given TaskRun by name in a given namespace */
+func (r Resource) patchTaskRun(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In patchTaskRun, name: %s, namespace: %s", name, namespace)
+
+	actionBody := RunActionBody{}
+	if err := request.ReadEntity(&actionBody); err != nil {
+		logging.Log.Errorf("error decoding the TaskRun action request body: %s", err)
+		utils.RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+
+	taskRun, ok := r.applyTaskRunActions(name, namespace, actionBody, response)
+	if !ok {
+		return
+	}
+	response.WriteEntity(taskRun)
+}
+
+/* Apply the actions to a PipelineRun and update it, responding with any error.
+ * Returns the updated PipelineRun and true if the update was made.
+ */
+func (r Resource) applyPipelineRunActions(name, namespace string, actionBody RunActionBody, response *restful.Response) (*v1alpha1.PipelineRun, bool) {
+	pipelineRuns := r.PipelineClient.TektonV1alpha1().PipelineRuns(namespace)
+	pipelineRun, err := pipelineRuns.Get(name, metav1.GetOptions{})
+	if err != nil || pipelineRun == nil {
+		utils.RespondError(response, err, http.StatusNotFound)
+		return nil, false
+	}
+	logging.Log.Debug("Found the PipelineRun ok")
+
+	if !verifyRunActionBody("PipelineRun", actionBody, pipelineRun.ObjectMeta, response) {
+		return nil, false
+	}
+
+	if actionBody.STATUS != "" {
+		currentStatus := pipelineRun.Spec.Status
+		desiredStatus := v1alpha1.PipelineRunSpecStatus(actionBody.STATUS)
+		logging.Log.Debugf("Current status of PipelineRun %s in namespace %s is: %s, wanting status: %s", name, namespace, currentStatus, desiredStatus)
+
+		// If there's anything else we want to allow, update this code
+		if desiredStatus != pipelineRunCancelled {
+			errorMsg := fmt.Sprintf("error updating PipelineRun status (bad request received), status must be set to %s.", pipelineRunCancelled)
+			utils.RespondErrorMessage(response, errorMsg, http.StatusBadRequest)
+			return nil, false
+		}
+		if currentStatus == desiredStatus {
+			errorMsg := fmt.Sprintf("error: Status was already set to %s", desiredStatus)
+			utils.RespondErrorMessage(response, errorMsg, http.StatusPreconditionFailed)
+			return nil, false
+		}
+		pipelineRun.Spec.Status = desiredStatus
+	}
+
+	originalTimeout := pipelineRun.Spec.Timeout
+	if err := applySpecPatch(&pipelineRun.Spec, actionBody.SPEC, patchablePipelineRunFields); err != nil {
+		utils.RespondError(response, err, http.StatusBadRequest)
+		return nil, false
+	}
+	if actionBody.TIMEOUT != "" {
+		// Validated by verifyRunActionBody
+		timeout, _ := time.ParseDuration(actionBody.TIMEOUT)
+		pipelineRun.Spec.Timeout = &metav1.Duration{Duration: timeout}
+	}
+	if !verifyPatchedTimeout("PipelineRun", originalTimeout, pipelineRun.Spec.Timeout, response) {
+		return nil, false
+	}
+	applyMetadataActions(&pipelineRun.ObjectMeta, actionBody)
+
+	updatedPipelineRun, err := pipelineRuns.Update(pipelineRun)
+	if err != nil {
+		respondWriteError(response, "PipelineRun", err)
+		return nil, false
+	}
+	logging.Log.Debugf("PipelineRun %s updated OK", name)
+	return updatedPipelineRun, true
+}
+
+/* Apply the actions to a TaskRun and update it, responding with any error.
+ * Returns the updated TaskRun and true if the update was made.
+ */
+func (r Resource) applyTaskRunActions(name, namespace string, actionBody RunActionBody, response *restful.Response) (*v1alpha1.TaskRun, bool) {
+	taskRuns := r.PipelineClient.TektonV1alpha1().TaskRuns(namespace)
+	taskRun, err := taskRuns.Get(name, metav1.GetOptions{})
+	if err != nil || taskRun == nil {
+		utils.RespondError(response, err, http.StatusNotFound)
+		return nil, false
+	}
+	logging.Log.Debug("Found the TaskRun ok")
+
+	if !verifyRunActionBody("TaskRun", actionBody, taskRun.ObjectMeta, response) {
+		return nil, false
+	}
+
+	if actionBody.STATUS != "" {
+		currentStatus := taskRun.Spec.Status
+		desiredStatus := v1alpha1.TaskRunSpecStatus(actionBody.STATUS)
+		logging.Log.Debugf("Current status of TaskRun %s in namespace %s is: %s, wanting status: %s", name, namespace, currentStatus, desiredStatus)
+
+		if desiredStatus != taskRunCancelled {
+			errorMsg := fmt.Sprintf("error updating TaskRun status (bad request received), status must be set to %s.", taskRunCancelled)
+			utils.RespondErrorMessage(response, errorMsg, http.StatusBadRequest)
+			return nil, false
+		}
+		if currentStatus == desiredStatus {
+			errorMsg := fmt.Sprintf("error: Status was already set to %s", desiredStatus)
+			utils.RespondErrorMessage(response, errorMsg, http.StatusPreconditionFailed)
+			return nil, false
+		}
+		taskRun.Spec.Status = desiredStatus
+	}
+
+	originalTimeout := taskRun.Spec.Timeout
+	if err := applySpecPatch(&taskRun.Spec, actionBody.SPEC, patchableTaskRunFields); err != nil {
+		utils.RespondError(response, err, http.StatusBadRequest)
+		return nil, false
+	}
+	if actionBody.TIMEOUT != "" {
+		// Validated by verifyRunActionBody
+		timeout, _ := time.ParseDuration(actionBody.TIMEOUT)
+		taskRun.Spec.Timeout = &metav1.Duration{Duration: timeout}
+	}
+	if !verifyPatchedTimeout("TaskRun", originalTimeout, taskRun.Spec.Timeout, response) {
+		return nil, false
+	}
+	applyMetadataActions(&taskRun.ObjectMeta, actionBody)
+
+	updatedTaskRun, err := taskRuns.Update(taskRun)
+	if err != nil {
+		respondWriteError(response, "TaskRun", err)
+		return nil, false
+	}
+	logging.Log.Debugf("TaskRun %s updated OK", name)
+	return updatedTaskRun, true
+}
+
+/* Verify the actions are well formed and that the run has not changed since the version the user saw
+ * Sends a 400 for malformed actions and a 412 if the resourceVersion does not match
+ */
+func verifyRunActionBody(kind string, actionBody RunActionBody, meta metav1.ObjectMeta, response *restful.Response) bool {
+	if actionBody.RESOURCEVERSION != "" && actionBody.RESOURCEVERSION != meta.ResourceVersion {
+		errorMsg := fmt.Sprintf("error: %s %s has been modified, resourceVersion is %s but %s was provided", kind, meta.Name, meta.ResourceVersion, actionBody.RESOURCEVERSION)
+		utils.RespondErrorMessage(response, errorMsg, http.StatusPreconditionFailed)
+		return false
+	}
+
+	if actionBody.STATUS == "" && actionBody.TIMEOUT == "" && len(actionBody.SPEC) == 0 &&
+		len(actionBody.ADDLABELS) == 0 && len(actionBody.REMOVELABELS) == 0 &&
+		len(actionBody.ADDANNOTATIONS) == 0 && len(actionBody.REMOVEANNOTATIONS) == 0 {
+		errorMsg := fmt.Sprintf("error updating %s (bad request received), at least one of status, timeout, addLabels, removeLabels, addAnnotations, removeAnnotations or spec must be provided.", kind)
+		utils.RespondErrorMessage(response, errorMsg, http.StatusBadRequest)
+		return false
+	}
+
+	if actionBody.TIMEOUT != "" {
+		if timeout, err := time.ParseDuration(actionBody.TIMEOUT); err != nil || timeout <= 0 {
+			errorMsg := fmt.Sprintf("error updating %s (bad request received), timeout must be a positive duration such as 1h30m, got: %s", kind, actionBody.TIMEOUT)
+			utils.RespondErrorMessage(response, errorMsg, http.StatusBadRequest)
+			return false
+		}
+	}
+
+	var problems []string
+	for key, value := range actionBody.ADDLABELS {
+		problems = append(problems, validation.IsQualifiedName(key)...)
+		problems = append(problems, validation.IsValidLabelValue(value)...)
+	}
+	for key := range actionBody.ADDANNOTATIONS {
+		problems = append(problems, validation.IsQualifiedName(key)...)
+	}
+	for _, key := range append(append([]string{}, actionBody.REMOVELABELS...), actionBody.REMOVEANNOTATIONS...) {
+		if _, ok := actionBody.ADDLABELS[key]; ok {
+			problems = append(problems, fmt.Sprintf("%s cannot be both added and removed", key))
+		} else if _, ok := actionBody.ADDANNOTATIONS[key]; ok {
+			problems = append(problems, fmt.Sprintf("%s cannot be both added and removed", key))
+		}
+	}
+	if len(problems) > 0 {
+		errorMsg := fmt.Sprintf("error updating %s (bad request received), invalid labels or annotations: %s", kind, strings.Join(problems, "; "))
+		utils.RespondErrorMessage(response, errorMsg, http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// A timeout changed through a spec patch must be positive, as one given by the timeout action must be
+func verifyPatchedTimeout(kind string, original, patched *metav1.Duration, response *restful.Response) bool {
+	if patched == nil || patched.Duration > 0 || reflect.DeepEqual(original, patched) {
+		return true
+	}
+	errorMsg := fmt.Sprintf("error updating %s (bad request received), spec timeout must be a positive duration such as 1h30m, got: %s", kind, patched.Duration)
+	utils.RespondErrorMessage(response, errorMsg, http.StatusBadRequest)
+	return false
+}
+
+// Add and remove the labels and annotations requested
+func applyMetadataActions(meta *metav1.ObjectMeta, actionBody RunActionBody) {
+	if len(actionBody.ADDLABELS) > 0 && meta.Labels == nil {
+		meta.Labels = make(map[string]string)
+	}
+	for key, value := range actionBody.ADDLABELS {
+		meta.Labels[key] = value
+	}
+	for _, key := range actionBody.REMOVELABELS {
+		delete(meta.Labels, key)
+	}
+	if len(actionBody.ADDANNOTATIONS) > 0 && meta.Annotations == nil {
+		meta.Annotations = make(map[string]string)
+	}
+	for key, value := range actionBody.ADDANNOTATIONS {
+		meta.Annotations[key] = value
+	}
+	for _, key := range actionBody.REMOVEANNOTATIONS {
+		delete(meta.Annotations, key)
+	}
+}
+
+// Merge patch the spec pointed to, only allowing the fields provided to be changed
+func applySpecPatch(spec interface{}, patch json.RawMessage, patchable map[string]bool) error {
+	if len(patch) == 0 {
+		return nil
+	}
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(patch, &fields); err != nil {
+		return fmt.Errorf("error: spec must be a JSON object: %s", err)
+	}
+	for field := range fields {
+		if !patchable[field] {
+			var allowed []string
+			for key := range patchable {
+				allowed = append(allowed, key)
+			}
+			sort.Strings(allowed)
+			return fmt.Errorf("error: spec field %s cannot be patched, patchable fields are: %s", field, strings.Join(allowed, ", "))
+		}
+	}
+
+	original, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	patched, err := jsonpatch.MergePatch(original, patch)
+	if err != nil {
+		return fmt.Errorf("error: could not apply spec patch: %s", err)
+	}
+	// Fields removed by the patch must not survive from the original
+	reflect.ValueOf(spec).Elem().Set(reflect.Zero(reflect.TypeOf(spec).Elem()))
+	if err := json.Unmarshal(patched, spec); err != nil {
+		return fmt.Errorf("error: patched spec is invalid: %s", err)
+	}
+	return nil
+}
+
+// A conflict means the object changed between our read and write so is reported like any other stale resourceVersion
+func respondWriteError(response *restful.Response, kind string, err error) {
+	logging.Log.Errorf("error writing %s: %s", kind, err)
+	switch {
+	case k8serrors.IsConflict(err):
+		utils.RespondError(response, errors.New("error: "+kind+" was modified while being updated, please retry: "+err.Error()), http.StatusPreconditionFailed)
+	case k8serrors.IsInvalid(err):
+		if statusError, ok := err.(*k8serrors.StatusError); ok {
+			respondStatusValidationError(response, kind, statusError)
+			return
+		}
+		utils.RespondError(response, err, http.StatusUnprocessableEntity)
+	case k8serrors.IsAlreadyExists(err):
+		utils.RespondError(response, err, http.StatusConflict)
+	case k8serrors.IsNotFound(err):
+		utils.RespondError(response, err, http.StatusNotFound)
+	default:
+		utils.RespondError(response, err, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Util to send a PATCH to patchPipelineRun and return the status code and body
+func patchPipelineRunTest(r *Resource, name, body string) (int, string) {
+	httpWriter := httptest.NewRecorder()
+	httpReq := dummyHttpRequest("PATCH", "http://wwww.dummy.com:8383/v1/namespaces/ns1/pipelinerun/"+name, strings.NewReader(body))
+	req := dummyRestfulRequest(httpReq, "ns1", name)
+	resp := dummyRestfulResponse(httpWriter)
+	r.patchPipelineRun(req, resp)
+	return resp.StatusCode(), httpWriter.Body.String()
+}
+
+/* PipelineRun actions test: labels, annotations, timeout and spec patches should all be applied */
+
+func TestPipelineRunActions(t *testing.T) {
+	t.Log("Testing PATCH actions are applied to a PipelineRun")
+
+	r := dummyResource()
+
+	pipelineRun1 := v1alpha1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "PipelineRun1",
+			ResourceVersion: "1",
+			Labels:          map[string]string{"remove": "me", "keep": "me"},
+		},
+		Spec: v1alpha1.PipelineRunSpec{
+			ServiceAccount: "default",
+			Params:         []v1alpha1.Param{{Name: "param1", Value: "value1"}},
+		},
+	}
+
+	_, err := r.PipelineClient.TektonV1alpha1().PipelineRuns("ns1").Create(&pipelineRun1)
+	if err != nil {
+		t.Errorf("Error creating the PipelineRun for use with TestPipelineRunActions, error: %s", err)
+	}
+
+	statusCode, body := patchPipelineRunTest(r, "PipelineRun1", `{"resourceVersion": "1", "timeout": "90m",
+		"addLabels": {"team": "foo"}, "removeLabels": ["remove"], "addAnnotations": {"note": "bar"},
+		"spec": {"serviceAccount": "builder", "params": null}}`)
+	if statusCode != 200 {
+		t.Fatalf("FAIL: should have been recognised as a 200, got %d: %s", statusCode, body)
+	}
+
+	result := v1alpha1.PipelineRun{}
+	json.NewDecoder(strings.NewReader(body)).Decode(&result)
+
+	if result.Labels["team"] != "foo" || result.Labels["keep"] != "me" {
+		t.Errorf("PipelineRun labels were not added: %v", result.Labels)
+	}
+	if _, ok := result.Labels["remove"]; ok {
+		t.Errorf("PipelineRun label was not removed: %v", result.Labels)
+	}
+	if result.Annotations["note"] != "bar" {
+		t.Errorf("PipelineRun annotations were not added: %v", result.Annotations)
+	}
+	if result.Spec.Timeout == nil || result.Spec.Timeout.Duration != 90*time.Minute {
+		t.Errorf("PipelineRun timeout was not changed: %v", result.Spec.Timeout)
+	}
+	if result.Spec.ServiceAccount != "builder" || len(result.Spec.Params) != 0 {
+		t.Errorf("PipelineRun spec was not patched: %v", result.Spec)
+	}
+}
+
+/* PipelineRun actions validation test: malformed actions give a 400, stale versions a 412 */
+
+func TestPipelineRunActionsValidation(t *testing.T) {
+	t.Log("Testing invalid PATCH actions are rejected")
+
+	r := dummyResource()
+
+	pipelineRun1 := v1alpha1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "PipelineRun1",
+			ResourceVersion: "2",
+		},
+		Spec: v1alpha1.PipelineRunSpec{},
+	}
+
+	_, err := r.PipelineClient.TektonV1alpha1().PipelineRuns("ns1").Create(&pipelineRun1)
+	if err != nil {
+		t.Errorf("Error creating the PipelineRun for use with TestPipelineRunActionsValidation, error: %s", err)
+	}
+
+	tests := []struct {
+		body       string
+		statusCode int
+	}{
+		{`{}`, 400},
+		{`{"status": "PipelineRunRunning"}`, 400},
+		{`{"timeout": "forever"}`, 400},
+		{`{"timeout": "-1h"}`, 400},
+		{`{"spec": {"timeout": "-1h"}}`, 400},
+		{`{"spec": {"timeout": "0s"}}`, 400},
+		{`{"addLabels": {"bad key!": "value"}}`, 400},
+		{`{"addLabels": {"key": "value"}, "removeLabels": ["key"]}`, 400},
+		{`{"spec": {"pipelineRef": {"name": "other"}}}`, 400},
+		{`{"spec": ["not", "an", "object"]}`, 400},
+		{`{"resourceVersion": "1", "timeout": "1h"}`, 412},
+	}
+	for _, test := range tests {
+		statusCode, body := patchPipelineRunTest(r, "PipelineRun1", test.body)
+		if statusCode != test.statusCode {
+			t.Errorf("FAIL: %s should have given a %d, got %d: %s", test.body, test.statusCode, statusCode, body)
+		}
+	}
+
+	statusCode, _ := patchPipelineRunTest(r, "IDoNotExist", `{"timeout": "1h"}`)
+	if statusCode != 404 {
+		t.Errorf("FAIL: should have been recognised as a 404, got %d", statusCode)
+	}
+}
+
+/* TaskRun actions test: labels and timeout should be applied and status rejected */
+
+func TestTaskRunActions(t *testing.T) {
+	t.Log("Testing PATCH actions are applied to a TaskRun")
+
+	r := dummyResource()
+
+	taskRun1 := v1alpha1.TaskRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "TaskRun1",
+		},
+		Spec: v1alpha1.TaskRunSpec{},
+	}
+
+	_, err := r.PipelineClient.TektonV1alpha1().TaskRuns("ns1").Create(&taskRun1)
+	if err != nil {
+		t.Errorf("Error creating the TaskRun for use with TestTaskRunActions, error: %s", err)
+	}
+
+	httpWriter := httptest.NewRecorder()
+	httpReq := dummyHttpRequest("PATCH", "http://wwww.dummy.com:8383/v1/namespaces/ns1/taskrun/TaskRun1",
+		strings.NewReader(`{"timeout": "10m", "addLabels": {"team": "foo"}}`))
+	req := dummyRestfulRequest(httpReq, "ns1", "TaskRun1")
+	resp := dummyRestfulResponse(httpWriter)
+	r.patchTaskRun(req, resp)
+
+	if resp.StatusCode() != 200 {
+		t.Fatalf("FAIL: should have been recognised as a 200, got %d: %s", resp.StatusCode(), httpWriter.Body.String())
+	}
+
+	result := v1alpha1.TaskRun{}
+	json.NewDecoder(httpWriter.Body).Decode(&result)
+
+	if result.Labels["team"] != "foo" {
+		t.Errorf("TaskRun labels were not added: %v", result.Labels)
+	}
+	if result.Spec.Timeout == nil || result.Spec.Timeout.Duration != 10*time.Minute {
+		t.Errorf("TaskRun timeout was not changed: %v", result.Spec.Timeout)
+	}
+
+	httpWriter = httptest.NewRecorder()
+	httpReq = dummyHttpRequest("PATCH", "http://wwww.dummy.com:8383/v1/namespaces/ns1/taskrun/TaskRun1",
+		strings.NewReader(`{"spec": {"taskRef": {"name": "other"}}}`))
+	req = dummyRestfulRequest(httpReq, "ns1", "TaskRun1")
+	resp = dummyRestfulResponse(httpWriter)
+	r.patchTaskRun(req, resp)
+
+	if resp.StatusCode() != 400 {
+		t.Errorf("FAIL: patching taskRef should have been recognised as a bad request, got %d", resp.StatusCode())
+	}
+
+	httpWriter = httptest.NewRecorder()
+	httpReq = dummyHttpRequest("PATCH", "http://wwww.dummy.com:8383/v1/namespaces/ns1/taskrun/TaskRun1",
+		strings.NewReader(`{"spec": {"timeout": "-1h"}}`))
+	req = dummyRestfulRequest(httpReq, "ns1", "TaskRun1")
+	resp = dummyRestfulResponse(httpWriter)
+	r.patchTaskRun(req, resp)
+
+	if resp.StatusCode() != 400 {
+		t.Errorf("FAIL: patching a negative timeout into the spec should have been recognised as a bad request, got %d", resp.StatusCode())
+	}
+}
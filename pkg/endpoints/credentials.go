@@ -301,7 +301,7 @@ func secretToCredential(secret *corev1.Secret) credential {
 	cred := credential{
 		Id:              secret.GetName(),
 		Username:        string(secret.Data["username"]),
-		Password:        "********",
+		Password:        redactedValue,
 		Description:     string(secret.Data["description"]),
 		Type:            string(secret.Data["type"]),
 		Url:             secret.ObjectMeta.Annotations,
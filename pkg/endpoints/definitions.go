@@ -0,0 +1,393 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	restful "github.com/emicklei/go-restful"
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/ghodss/yaml"
+	"github.com/knative/pkg/apis"
+	logging "github.com/tektoncd/dashboard/pkg/logging"
+	"github.com/tektoncd/dashboard/pkg/utils"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ValidationError - a structured description of why a definition was rejected
+type ValidationError struct {
+	KIND    string            `json:"kind"`
+	NAME    string            `json:"name"`
+	MESSAGE string            `json:"message"`
+	CAUSES  []ValidationCause `json:"causes"`
+}
+
+// ValidationCause - a single problem with a definition, Field is empty if the problem is not with one field
+type ValidationCause struct {
+	FIELD   string `json:"field"`
+	MESSAGE string `json:"message"`
+}
+
+// Pipelines, Tasks and PipelineResources all validate themselves
+type definition interface {
+	metav1.Object
+	Validate(ctx context.Context) *apis.FieldError
+}
+
+/* Create a Pipeline, from a JSON or YAML body, in a given namespace */
+func (r Resource) createPipeline(request *restful.Request, response *restful.Response) {
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In createPipeline, namespace: %s", namespace)
+
+	pipeline := v1alpha1.Pipeline{}
+	if !readDefinition(request, response, "Pipeline", &pipeline, namespace, "") {
+		return
+	}
+	created, err := r.PipelineClient.TektonV1alpha1().Pipelines(namespace).Create(&pipeline)
+	if err != nil {
+		respondWriteError(response, "Pipeline", err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusCreated, created)
+}
+
+/* Replace a given Pipeline by name in a given namespace */
+func (r Resource) replacePipeline(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In replacePipeline, name: %s, namespace: %s", name, namespace)
+
+	pipelines := r.PipelineClient.TektonV1alpha1().Pipelines(namespace)
+	current, err := pipelines.Get(name, metav1.GetOptions{})
+	if err != nil {
+		utils.RespondError(response, err, http.StatusNotFound)
+		return
+	}
+	pipeline := v1alpha1.Pipeline{}
+	if !readDefinition(request, response, "Pipeline", &pipeline, namespace, name) {
+		return
+	}
+	if pipeline.ResourceVersion == "" {
+		pipeline.ResourceVersion = current.ResourceVersion
+	}
+	updated, err := pipelines.Update(&pipeline)
+	if err != nil {
+		respondWriteError(response, "Pipeline", err)
+		return
+	}
+	response.WriteEntity(updated)
+}
+
+/* Merge patch a given Pipeline by name in a given namespace */
+func (r Resource) patchPipeline(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In patchPipeline, name: %s, namespace: %s", name, namespace)
+
+	pipelines := r.PipelineClient.TektonV1alpha1().Pipelines(namespace)
+	current, err := pipelines.Get(name, metav1.GetOptions{})
+	if err != nil {
+		utils.RespondError(response, err, http.StatusNotFound)
+		return
+	}
+	pipeline := v1alpha1.Pipeline{}
+	if !readDefinitionPatch(request, response, "Pipeline", current, &pipeline) {
+		return
+	}
+	updated, err := pipelines.Update(&pipeline)
+	if err != nil {
+		respondWriteError(response, "Pipeline", err)
+		return
+	}
+	response.WriteEntity(updated)
+}
+
+/* Delete a given Pipeline by name in a given namespace */
+func (r Resource) deletePipeline(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In deletePipeline, name: %s, namespace: %s", name, namespace)
+
+	if err := r.PipelineClient.TektonV1alpha1().Pipelines(namespace).Delete(name, &metav1.DeleteOptions{}); err != nil {
+		utils.RespondError(response, err, http.StatusNotFound)
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
+
+/* Create a Task, from a JSON or YAML body, in a given namespace */
+func (r Resource) createTask(request *restful.Request, response *restful.Response) {
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In createTask, namespace: %s", namespace)
+
+	task := v1alpha1.Task{}
+	if !readDefinition(request, response, "Task", &task, namespace, "") {
+		return
+	}
+	created, err := r.PipelineClient.TektonV1alpha1().Tasks(namespace).Create(&task)
+	if err != nil {
+		respondWriteError(response, "Task", err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusCreated, created)
+}
+
+/* Replace a given Task by name in a given namespace */
+func (r Resource) replaceTask(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In replaceTask, name: %s, namespace: %s", name, namespace)
+
+	tasks := r.PipelineClient.TektonV1alpha1().Tasks(namespace)
+	current, err := tasks.Get(name, metav1.GetOptions{})
+	if err != nil {
+		utils.RespondError(response, err, http.StatusNotFound)
+		return
+	}
+	task := v1alpha1.Task{}
+	if !readDefinition(request, response, "Task", &task, namespace, name) {
+		return
+	}
+	if task.ResourceVersion == "" {
+		task.ResourceVersion = current.ResourceVersion
+	}
+	updated, err := tasks.Update(&task)
+	if err != nil {
+		respondWriteError(response, "Task", err)
+		return
+	}
+	response.WriteEntity(updated)
+}
+
+/* Merge patch a given Task by name in a given namespace */
+func (r Resource) patchTask(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In patchTask, name: %s, namespace: %s", name, namespace)
+
+	tasks := r.PipelineClient.TektonV1alpha1().Tasks(namespace)
+	current, err := tasks.Get(name, metav1.GetOptions{})
+	if err != nil {
+		utils.RespondError(response, err, http.StatusNotFound)
+		return
+	}
+	task := v1alpha1.Task{}
+	if !readDefinitionPatch(request, response, "Task", current, &task) {
+		return
+	}
+	updated, err := tasks.Update(&task)
+	if err != nil {
+		respondWriteError(response, "Task", err)
+		return
+	}
+	response.WriteEntity(updated)
+}
+
+/* Delete a given Task by name in a given namespace */
+func (r Resource) deleteTask(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In deleteTask, name: %s, namespace: %s", name, namespace)
+
+	if err := r.PipelineClient.TektonV1alpha1().Tasks(namespace).Delete(name, &metav1.DeleteOptions{}); err != nil {
+		utils.RespondError(response, err, http.StatusNotFound)
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
+
+/* Create a PipelineResource, from a JSON or YAML body, in a given namespace */
+func (r Resource) createPipelineResource(request *restful.Request, response *restful.Response) {
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In createPipelineResource, namespace: %s", namespace)
+
+	pipelineResource := v1alpha1.PipelineResource{}
+	if !readDefinition(request, response, "PipelineResource", &pipelineResource, namespace, "") {
+		return
+	}
+	created, err := r.PipelineClient.TektonV1alpha1().PipelineResources(namespace).Create(&pipelineResource)
+	if err != nil {
+		respondWriteError(response, "PipelineResource", err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusCreated, created)
+}
+
+/* Replace a given PipelineResource by name in a given namespace */
+func (r Resource) replacePipelineResource(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In replacePipelineResource, name: %s, namespace: %s", name, namespace)
+
+	pipelineResources := r.PipelineClient.TektonV1alpha1().PipelineResources(namespace)
+	current, err := pipelineResources.Get(name, metav1.GetOptions{})
+	if err != nil {
+		utils.RespondError(response, err, http.StatusNotFound)
+		return
+	}
+	pipelineResource := v1alpha1.PipelineResource{}
+	if !readDefinition(request, response, "PipelineResource", &pipelineResource, namespace, name) {
+		return
+	}
+	if pipelineResource.ResourceVersion == "" {
+		pipelineResource.ResourceVersion = current.ResourceVersion
+	}
+	updated, err := pipelineResources.Update(&pipelineResource)
+	if err != nil {
+		respondWriteError(response, "PipelineResource", err)
+		return
+	}
+	response.WriteEntity(updated)
+}
+
+/* Merge patch a given PipelineResource by name in a given namespace */
+func (r Resource) patchPipelineResource(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In patchPipelineResource, name: %s, namespace: %s", name, namespace)
+
+	pipelineResources := r.PipelineClient.TektonV1alpha1().PipelineResources(namespace)
+	current, err := pipelineResources.Get(name, metav1.GetOptions{})
+	if err != nil {
+		utils.RespondError(response, err, http.StatusNotFound)
+		return
+	}
+	pipelineResource := v1alpha1.PipelineResource{}
+	if !readDefinitionPatch(request, response, "PipelineResource", current, &pipelineResource) {
+		return
+	}
+	updated, err := pipelineResources.Update(&pipelineResource)
+	if err != nil {
+		respondWriteError(response, "PipelineResource", err)
+		return
+	}
+	response.WriteEntity(updated)
+}
+
+/* Delete a given PipelineResource by name in a given namespace */
+func (r Resource) deletePipelineResource(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In deletePipelineResource, name: %s, namespace: %s", name, namespace)
+
+	if err := r.PipelineClient.TektonV1alpha1().PipelineResources(namespace).Delete(name, &metav1.DeleteOptions{}); err != nil {
+		utils.RespondError(response, err, http.StatusNotFound)
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// Returns the request body as JSON, converting it first if it was sent as YAML
+func readBodyAsJSON(request *restful.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(request.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	contentType := request.HeaderParameter("Content-Type")
+	if strings.HasPrefix(contentType, mimeYAML) || strings.HasPrefix(contentType, mimeXYAML) {
+		return yaml.YAMLToJSON(body)
+	}
+	return body, nil
+}
+
+/* Read a definition from the request body, check it belongs at this path and validate it.
+ * An empty name means the definition is being created and may have any name.
+ * Sends a 400 if the body cannot be read or is for somewhere else and a 422 if it is invalid.
+ */
+func readDefinition(request *restful.Request, response *restful.Response, kind string, obj definition, namespace, name string) bool {
+	body, err := readBodyAsJSON(request)
+	if err == nil {
+		err = json.Unmarshal(body, obj)
+	}
+	if err != nil {
+		utils.RespondErrorAndMessage(response, err, fmt.Sprintf("Error parsing %s request body: %s", kind, err), http.StatusBadRequest)
+		return false
+	}
+	return verifyDefinition(response, kind, obj, namespace, name)
+}
+
+/* Merge patch the current definition with the request body into patched, then check and validate it as readDefinition does */
+func readDefinitionPatch(request *restful.Request, response *restful.Response, kind string, current, patched definition) bool {
+	patch, err := readBodyAsJSON(request)
+	var original, merged []byte
+	if err == nil {
+		original, err = json.Marshal(current)
+	}
+	if err == nil {
+		merged, err = jsonpatch.MergePatch(original, patch)
+	}
+	if err == nil {
+		err = json.Unmarshal(merged, patched)
+	}
+	if err != nil {
+		utils.RespondErrorAndMessage(response, err, fmt.Sprintf("Error applying %s patch: %s", kind, err), http.StatusBadRequest)
+		return false
+	}
+	return verifyDefinition(response, kind, patched, current.GetNamespace(), current.GetName())
+}
+
+func verifyDefinition(response *restful.Response, kind string, obj definition, namespace, name string) bool {
+	if obj.GetNamespace() != "" && obj.GetNamespace() != namespace {
+		errorMessage := fmt.Sprintf("Error: %s namespace %s does not match the namespace in the path: %s", kind, obj.GetNamespace(), namespace)
+		utils.RespondErrorMessage(response, errorMessage, http.StatusBadRequest)
+		return false
+	}
+	obj.SetNamespace(namespace)
+	if name != "" {
+		if obj.GetName() != "" && obj.GetName() != name {
+			errorMessage := fmt.Sprintf("Error: %s name %s does not match the name in the path: %s", kind, obj.GetName(), name)
+			utils.RespondErrorMessage(response, errorMessage, http.StatusBadRequest)
+			return false
+		}
+		obj.SetName(name)
+	}
+
+	if fieldError := obj.Validate(context.Background()); fieldError != nil {
+		respondValidationError(response, kind, obj.GetName(), fieldError)
+		return false
+	}
+	return true
+}
+
+// Responds with a 422 describing each invalid field
+func respondValidationError(response *restful.Response, kind, name string, fieldError *apis.FieldError) {
+	logging.Log.Errorf("%s %s is invalid: %s", kind, name, fieldError)
+	validationError := ValidationError{KIND: kind, NAME: name, MESSAGE: fieldError.Error()}
+	for _, path := range fieldError.Paths {
+		validationError.CAUSES = append(validationError.CAUSES, ValidationCause{FIELD: path, MESSAGE: fieldError.Message})
+	}
+	if len(validationError.CAUSES) == 0 {
+		validationError.CAUSES = append(validationError.CAUSES, ValidationCause{MESSAGE: fieldError.Error()})
+	}
+	response.WriteHeaderAndEntity(http.StatusUnprocessableEntity, validationError)
+}
+
+// Responds with a 422 describing each cause the API server gave for rejecting an object
+func respondStatusValidationError(response *restful.Response, kind string, statusError *k8serrors.StatusError) {
+	status := statusError.ErrStatus
+	validationError := ValidationError{KIND: kind, MESSAGE: status.Message}
+	if status.Details != nil {
+		validationError.NAME = status.Details.Name
+		for _, cause := range status.Details.Causes {
+			validationError.CAUSES = append(validationError.CAUSES, ValidationCause{FIELD: cause.Field, MESSAGE: cause.Message})
+		}
+	}
+	response.WriteHeaderAndEntity(http.StatusUnprocessableEntity, validationError)
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	restful "github.com/emicklei/go-restful"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Util to call a definition handler with a body of the given content type and return the status code and body
+func definitionRequestTest(handler restful.RouteFunction, method, name, contentType, body string) (int, string) {
+	httpWriter := httptest.NewRecorder()
+	httpReq := dummyHttpRequest(method, "http://wwww.dummy.com:8383/v1/namespaces/ns1/task/"+name, strings.NewReader(body))
+	httpReq.Header.Set("Content-Type", contentType)
+	req := dummyRestfulRequest(httpReq, "ns1", name)
+	resp := dummyRestfulResponse(httpWriter)
+	handler(req, resp)
+	return resp.StatusCode(), httpWriter.Body.String()
+}
+
+/* Task CRUD test: create from YAML, replace and patch from JSON, then delete */
+
+func TestTaskDefinitions(t *testing.T) {
+	t.Log("Testing creating, replacing, patching and deleting a Task")
+
+	r := dummyResource()
+
+	taskYAML := `apiVersion: tekton.dev/v1alpha1
+kind: Task
+metadata:
+  name: task1
+spec:
+  steps:
+  - name: step1
+    image: busybox
+`
+	statusCode, body := definitionRequestTest(r.createTask, "POST", "", mimeYAML, taskYAML)
+	if statusCode != 201 {
+		t.Fatalf("FAIL: creating a Task from YAML should have given a 201, got %d: %s", statusCode, body)
+	}
+
+	statusCode, body = definitionRequestTest(r.createTask, "POST", "", mimeYAML, taskYAML)
+	if statusCode != 409 {
+		t.Errorf("FAIL: creating a Task that already exists should have given a 409, got %d: %s", statusCode, body)
+	}
+
+	statusCode, body = definitionRequestTest(r.replaceTask, "PUT", "task1", restful.MIME_JSON,
+		`{"metadata": {"name": "task1"}, "spec": {"steps": [{"name": "step2", "image": "alpine"}]}}`)
+	if statusCode != 200 {
+		t.Fatalf("FAIL: replacing a Task should have given a 200, got %d: %s", statusCode, body)
+	}
+
+	statusCode, body = definitionRequestTest(r.patchTask, "PATCH", "task1", restful.MIME_JSON,
+		`{"metadata": {"labels": {"team": "foo"}}}`)
+	if statusCode != 200 {
+		t.Fatalf("FAIL: patching a Task should have given a 200, got %d: %s", statusCode, body)
+	}
+
+	task, err := r.PipelineClient.TektonV1alpha1().Tasks("ns1").Get("task1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("task1 was not found: %s", err)
+	}
+	if task.Labels["team"] != "foo" || len(task.Spec.Steps) != 1 || task.Spec.Steps[0].Image != "alpine" {
+		t.Errorf("task1 was not replaced and patched: %v", task)
+	}
+
+	statusCode, _ = definitionRequestTest(r.deleteTask, "DELETE", "task1", restful.MIME_JSON, "")
+	if statusCode != 204 {
+		t.Errorf("FAIL: deleting a Task should have given a 204, got %d", statusCode)
+	}
+	statusCode, _ = definitionRequestTest(r.deleteTask, "DELETE", "task1", restful.MIME_JSON, "")
+	if statusCode != 404 {
+		t.Errorf("FAIL: deleting a Task that doesn't exist should have given a 404, got %d", statusCode)
+	}
+}
+
+/* Definition validation test: bad bodies give a 400, invalid definitions a structured 422 */
+
+func TestDefinitionValidation(t *testing.T) {
+	t.Log("Testing invalid definitions are rejected")
+
+	r := dummyResource()
+
+	pipeline1 := v1alpha1.Pipeline{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pipeline1",
+		},
+		Spec: v1alpha1.PipelineSpec{
+			Tasks: []v1alpha1.PipelineTask{{Name: "task1", TaskRef: v1alpha1.TaskRef{Name: "task1"}}},
+		},
+	}
+	if _, err := r.PipelineClient.TektonV1alpha1().Pipelines("ns1").Create(&pipeline1); err != nil {
+		t.Errorf("Error creating the Pipeline for use with TestDefinitionValidation, error: %s", err)
+	}
+
+	tests := []struct {
+		handler    restful.RouteFunction
+		method     string
+		name       string
+		body       string
+		statusCode int
+	}{
+		{r.createTask, "POST", "", `not json`, 400},
+		{r.createTask, "POST", "", `{"metadata": {"name": "task1", "namespace": "ns2"}, "spec": {"steps": [{"name": "step1", "image": "busybox"}]}}`, 400},
+		{r.createTask, "POST", "", `{"metadata": {"name": "task1"}, "spec": {}}`, 422},
+		{r.replacePipeline, "PUT", "pipeline1", `{"metadata": {"name": "pipeline2"}}`, 400},
+		{r.replacePipeline, "PUT", "pipeline2", `{"metadata": {"name": "pipeline2"}}`, 404},
+		{r.patchPipeline, "PATCH", "pipeline1", `{"spec": null}`, 422},
+		{r.createPipelineResource, "POST", "", `{"metadata": {"name": "resource1"}}`, 422},
+	}
+	for _, test := range tests {
+		statusCode, body := definitionRequestTest(test.handler, test.method, test.name, restful.MIME_JSON, test.body)
+		if statusCode != test.statusCode {
+			t.Errorf("FAIL: %s %s should have given a %d, got %d: %s", test.method, test.body, test.statusCode, statusCode, body)
+			continue
+		}
+		if statusCode == 422 {
+			validationError := ValidationError{}
+			if err := json.Unmarshal([]byte(body), &validationError); err != nil || len(validationError.CAUSES) == 0 {
+				t.Errorf("FAIL: %s %s should have given a structured validation error, got: %s", test.method, test.body, body)
+			}
+		}
+	}
+}
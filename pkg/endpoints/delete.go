@@ -0,0 +1,227 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	logging "github.com/tektoncd/dashboard/pkg/logging"
+	"github.com/tektoncd/dashboard/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// DeleteResult - the runs removed by a bulk delete, or that would be removed when dryRun is set
+type DeleteResult struct {
+	DRYRUN  bool     `json:"dryRun"`
+	DELETED []string `json:"deleted"`
+	// Runs that matched but could not be deleted, with the reason why
+	FAILED map[string]string `json:"failed,omitempty"`
+}
+
+// The runs a bulk delete applies to
+type deleteFilter struct {
+	selector        string
+	completedBefore *time.Time
+	includeRunning  bool
+	dryRun          bool
+}
+
+/* Delete a given PipelineRun by name in a given namespace */
+func (r Resource) deletePipelineRun(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In deletePipelineRun, name: %s, namespace: %s", name, namespace)
+
+	err := r.PipelineClient.TektonV1alpha1().PipelineRuns(namespace).Delete(name, &metav1.DeleteOptions{})
+	if err != nil {
+		respondWriteError(response, "PipelineRun "+name, err)
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
+
+/* Delete a given TaskRun by name in a given namespace */
+func (r Resource) deleteTaskRun(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In deleteTaskRun, name: %s, namespace: %s", name, namespace)
+
+	err := r.PipelineClient.TektonV1alpha1().TaskRuns(namespace).Delete(name, &metav1.DeleteOptions{})
+	if err != nil {
+		respondWriteError(response, "TaskRun "+name, err)
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
+
+/* Delete all PipelineRuns matching the query in a given namespace
+ * Query parameters (at least one of labelSelector, repository or completedBefore is required):
+ *  - labelSelector
+ *  - repository (matched through the gitServer, gitOrg and gitRepo labels)
+ *  - completedBefore (RFC3339, only runs that completed before this time are deleted)
+ *  - includeRunning (also delete runs that have not completed, ignored with completedBefore)
+ *  - dryRun (list what would be deleted without deleting it)
+ */
+func (r Resource) deletePipelineRuns(request *restful.Request, response *restful.Response) {
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In deletePipelineRuns, namespace: %s", namespace)
+
+	filter, ok := getDeleteFilter(request, response)
+	if !ok {
+		return
+	}
+
+	pipelineRuns := r.PipelineClient.TektonV1alpha1().PipelineRuns(namespace)
+	pipelineRunList, err := pipelineRuns.List(metav1.ListOptions{LabelSelector: filter.selector})
+	if err != nil {
+		utils.RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+
+	result := DeleteResult{DRYRUN: filter.dryRun, DELETED: []string{}}
+	for _, pipelineRun := range pipelineRunList.Items {
+		if !filter.matches(pipelineRun.Status.CompletionTime) {
+			continue
+		}
+		if !filter.dryRun {
+			if err := pipelineRuns.Delete(pipelineRun.Name, &metav1.DeleteOptions{}); err != nil {
+				result.addFailure(pipelineRun.Name, err)
+				continue
+			}
+		}
+		result.DELETED = append(result.DELETED, pipelineRun.Name)
+	}
+	writeDeleteResult(response, result)
+}
+
+/* Delete all TaskRuns matching the query in a given namespace
+ * Takes the same query parameters as deletePipelineRuns
+ */
+func (r Resource) deleteTaskRuns(request *restful.Request, response *restful.Response) {
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In deleteTaskRuns, namespace: %s", namespace)
+
+	filter, ok := getDeleteFilter(request, response)
+	if !ok {
+		return
+	}
+
+	taskRuns := r.PipelineClient.TektonV1alpha1().TaskRuns(namespace)
+	taskRunList, err := taskRuns.List(metav1.ListOptions{LabelSelector: filter.selector})
+	if err != nil {
+		utils.RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+
+	result := DeleteResult{DRYRUN: filter.dryRun, DELETED: []string{}}
+	for _, taskRun := range taskRunList.Items {
+		if !filter.matches(taskRun.Status.CompletionTime) {
+			continue
+		}
+		if !filter.dryRun {
+			if err := taskRuns.Delete(taskRun.Name, &metav1.DeleteOptions{}); err != nil {
+				result.addFailure(taskRun.Name, err)
+				continue
+			}
+		}
+		result.DELETED = append(result.DELETED, taskRun.Name)
+	}
+	writeDeleteResult(response, result)
+}
+
+/* Read and validate the bulk delete query parameters, sending a 400 if they are invalid */
+func getDeleteFilter(request *restful.Request, response *restful.Response) (deleteFilter, bool) {
+	filter := deleteFilter{}
+
+	var selectors []string
+	if labelSelector := request.QueryParameter("labelSelector"); labelSelector != "" {
+		if _, err := labels.Parse(labelSelector); err != nil {
+			utils.RespondErrorAndMessage(response, err, fmt.Sprintf("Error: invalid labelSelector: %s", err), http.StatusBadRequest)
+			return filter, false
+		}
+		selectors = append(selectors, labelSelector)
+	}
+	if repository := request.QueryParameter("repository"); repository != "" {
+		if !isValidRepoURL(repository) {
+			utils.RespondErrorMessage(response, "Error: repository must be of the form http(s)://server/org/repo", http.StatusBadRequest)
+			return filter, false
+		}
+		selectors = append(selectors, getRepositorySelector(repository))
+	}
+	filter.selector = strings.Join(selectors, ",")
+
+	if completedBefore := request.QueryParameter("completedBefore"); completedBefore != "" {
+		before, err := time.Parse(time.RFC3339, completedBefore)
+		if err != nil {
+			utils.RespondErrorAndMessage(response, err, "Error: completedBefore must be an RFC3339 timestamp e.g. 2019-04-01T00:00:00Z", http.StatusBadRequest)
+			return filter, false
+		}
+		filter.completedBefore = &before
+	}
+
+	if filter.selector == "" && filter.completedBefore == nil {
+		utils.RespondErrorMessage(response, "Error: at least one of labelSelector, repository or completedBefore must be supplied", http.StatusBadRequest)
+		return filter, false
+	}
+
+	if includeRunning := request.QueryParameter("includeRunning"); includeRunning != "" {
+		value, err := strconv.ParseBool(includeRunning)
+		if err != nil {
+			utils.RespondErrorAndMessage(response, err, "Error: includeRunning must be true or false", http.StatusBadRequest)
+			return filter, false
+		}
+		filter.includeRunning = value
+	}
+
+	if dryRun := request.QueryParameter("dryRun"); dryRun != "" {
+		value, err := strconv.ParseBool(dryRun)
+		if err != nil {
+			utils.RespondErrorAndMessage(response, err, "Error: dryRun must be true or false", http.StatusBadRequest)
+			return filter, false
+		}
+		filter.dryRun = value
+	}
+	return filter, true
+}
+
+// Returns true if a run with the given completion time should be deleted, the label selector having already been applied
+// Runs that have not completed are only deleted when asked for, as that stops them
+func (f deleteFilter) matches(completionTime *metav1.Time) bool {
+	if completionTime == nil {
+		return f.includeRunning && f.completedBefore == nil
+	}
+	return f.completedBefore == nil || completionTime.Time.Before(*f.completedBefore)
+}
+
+func (d *DeleteResult) addFailure(name string, err error) {
+	logging.Log.Errorf("error deleting %s: %s", name, err)
+	if d.FAILED == nil {
+		d.FAILED = make(map[string]string)
+	}
+	d.FAILED[name] = err.Error()
+}
+
+// Responds with a 500 if anything could not be deleted, the result still says what was
+func writeDeleteResult(response *restful.Response, result DeleteResult) {
+	if len(result.FAILED) > 0 {
+		response.WriteHeaderAndEntity(http.StatusInternalServerError, result)
+		return
+	}
+	response.WriteEntity(result)
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// Util to send a bulk DELETE to deletePipelineRuns and return the status code and result
+func deletePipelineRunsTest(r *Resource, query string) (int, DeleteResult) {
+	httpWriter := httptest.NewRecorder()
+	httpReq := dummyHttpRequest("DELETE", "http://wwww.dummy.com:8383/v1/namespaces/ns1/pipelinerun?"+query, nil)
+	req := dummyRestfulRequest(httpReq, "ns1", "")
+	resp := dummyRestfulResponse(httpWriter)
+	r.deletePipelineRuns(req, resp)
+
+	result := DeleteResult{}
+	json.NewDecoder(httpWriter.Body).Decode(&result)
+	sort.Strings(result.DELETED)
+	return resp.StatusCode(), result
+}
+
+/* Bulk delete test: runs for a repository completed before a time should be deleted, unless it's a dry run.
+ * Runs that have not completed are only deleted with includeRunning */
+
+func TestDeletePipelineRuns(t *testing.T) {
+	t.Log("Testing bulk deletion of PipelineRuns")
+
+	r := dummyResource()
+
+	repoLabels := map[string]string{gitServerLabel: "github.com", gitOrgLabel: "foo", gitRepoLabel: "bar"}
+	otherLabels := map[string]string{gitServerLabel: "github.com", gitOrgLabel: "foo", gitRepoLabel: "other"}
+	old := metav1.NewTime(time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC))
+	recent := metav1.NewTime(time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC))
+
+	pipelineRuns := []v1alpha1.PipelineRun{
+		{ObjectMeta: metav1.ObjectMeta{Name: "OldRun", Labels: repoLabels}, Status: v1alpha1.PipelineRunStatus{CompletionTime: &old}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "RecentRun", Labels: repoLabels}, Status: v1alpha1.PipelineRunStatus{CompletionTime: &recent}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "RunningRun", Labels: repoLabels}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "OtherRepoRun", Labels: otherLabels}, Status: v1alpha1.PipelineRunStatus{CompletionTime: &old}},
+	}
+	for i := range pipelineRuns {
+		if _, err := r.PipelineClient.TektonV1alpha1().PipelineRuns("ns1").Create(&pipelineRuns[i]); err != nil {
+			t.Errorf("Error creating the PipelineRun for use with TestDeletePipelineRuns, error: %s", err)
+		}
+	}
+
+	// Filters are required and must be valid
+	for _, query := range []string{"", "labelSelector=a%20b%20c", "completedBefore=yesterday", "repository=bar&dryRun=maybe", "repository=https://github.com/foo/bar&includeRunning=yes"} {
+		if statusCode, _ := deletePipelineRunsTest(r, query); statusCode != 400 {
+			t.Errorf("FAIL: %s should have been recognised as a bad request, got %d", query, statusCode)
+		}
+	}
+
+	statusCode, result := deletePipelineRunsTest(r, "repository=https://github.com/foo/bar&completedBefore=2019-02-01T00:00:00Z&dryRun=true")
+	if statusCode != 200 || !result.DRYRUN || !reflect.DeepEqual(result.DELETED, []string{"OldRun"}) {
+		t.Errorf("Dry run: expected: %v, returned %d: %v", []string{"OldRun"}, statusCode, result)
+	}
+	if _, err := r.PipelineClient.TektonV1alpha1().PipelineRuns("ns1").Get("OldRun", metav1.GetOptions{}); err != nil {
+		t.Errorf("OldRun should not have been deleted by a dry run: %s", err)
+	}
+
+	statusCode, result = deletePipelineRunsTest(r, "repository=https://github.com/foo/bar")
+	if statusCode != 200 || result.DRYRUN || !reflect.DeepEqual(result.DELETED, []string{"OldRun", "RecentRun"}) {
+		t.Errorf("Delete by repository: expected: %v, returned %d: %v", []string{"OldRun", "RecentRun"}, statusCode, result)
+	}
+
+	statusCode, result = deletePipelineRunsTest(r, "repository=https://github.com/foo/bar&includeRunning=true")
+	if statusCode != 200 || !reflect.DeepEqual(result.DELETED, []string{"RunningRun"}) {
+		t.Errorf("Delete by repository including running: expected: %v, returned %d: %v", []string{"RunningRun"}, statusCode, result)
+	}
+
+	pipelineRunList, _ := r.PipelineClient.TektonV1alpha1().PipelineRuns("ns1").List(metav1.ListOptions{})
+	if len(pipelineRunList.Items) != 1 || pipelineRunList.Items[0].Name != "OtherRepoRun" {
+		t.Errorf("Only OtherRepoRun should remain: %v", pipelineRunList.Items)
+	}
+}
+
+/* Delete by name test: 204 when deleted, 404 when not found, other errors aren't reported as not found */
+
+func TestDeleteRunByName(t *testing.T) {
+	t.Log("Testing deletion of PipelineRuns and TaskRuns by name")
+
+	r := dummyResource()
+
+	pipelineRun1 := v1alpha1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Name: "PipelineRun1"}}
+	taskRun1 := v1alpha1.TaskRun{ObjectMeta: metav1.ObjectMeta{Name: "TaskRun1"}}
+	if _, err := r.PipelineClient.TektonV1alpha1().PipelineRuns("ns1").Create(&pipelineRun1); err != nil {
+		t.Errorf("Error creating the PipelineRun for use with TestDeleteRunByName, error: %s", err)
+	}
+	if _, err := r.PipelineClient.TektonV1alpha1().TaskRuns("ns1").Create(&taskRun1); err != nil {
+		t.Errorf("Error creating the TaskRun for use with TestDeleteRunByName, error: %s", err)
+	}
+
+	tests := []struct {
+		kind       string
+		name       string
+		statusCode int
+	}{
+		{"pipelinerun", "PipelineRun1", 204},
+		{"pipelinerun", "PipelineRun1", 404},
+		{"taskrun", "TaskRun1", 204},
+		{"taskrun", "TaskRun1", 404},
+	}
+	for _, test := range tests {
+		httpWriter := httptest.NewRecorder()
+		httpReq := dummyHttpRequest("DELETE", "http://wwww.dummy.com:8383/v1/namespaces/ns1/"+test.kind+"/"+test.name, nil)
+		req := dummyRestfulRequest(httpReq, "ns1", test.name)
+		resp := dummyRestfulResponse(httpWriter)
+		if test.kind == "pipelinerun" {
+			r.deletePipelineRun(req, resp)
+		} else {
+			r.deleteTaskRun(req, resp)
+		}
+		if resp.StatusCode() != test.statusCode {
+			t.Errorf("FAIL: deleting %s %s should have given a %d, got %d", test.kind, test.name, test.statusCode, resp.StatusCode())
+		}
+	}
+
+	pipelineClient := dummyClientset()
+	pipelineClient.PrependReactor("delete", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, k8serrors.NewForbidden(v1alpha1.Resource(action.GetResource().Resource), "Run1", errors.New("not allowed"))
+	})
+	r.PipelineClient = pipelineClient
+	for _, kind := range []string{"pipelinerun", "taskrun"} {
+		httpWriter := httptest.NewRecorder()
+		httpReq := dummyHttpRequest("DELETE", "http://wwww.dummy.com:8383/v1/namespaces/ns1/"+kind+"/Run1", nil)
+		req := dummyRestfulRequest(httpReq, "ns1", "Run1")
+		resp := dummyRestfulResponse(httpWriter)
+		if kind == "pipelinerun" {
+			r.deletePipelineRun(req, resp)
+		} else {
+			r.deleteTaskRun(req, resp)
+		}
+		if resp.StatusCode() != 500 {
+			t.Errorf("FAIL: a forbidden delete of a %s should have given a 500, got %d", kind, resp.StatusCode())
+		}
+	}
+}
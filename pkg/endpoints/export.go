@@ -0,0 +1,213 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/ghodss/yaml"
+	logging "github.com/tektoncd/dashboard/pkg/logging"
+	"github.com/tektoncd/dashboard/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const yamlDocumentSeparator = "---\n"
+
+const lastAppliedConfigAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// An object in an exported bundle, both metav1.Object and runtime.Object
+type bundleObject interface {
+	metav1.Object
+	runtime.Object
+}
+
+/* Export a given PipelineRun by name in a given namespace, along with its Pipeline, Tasks,
+ * PipelineResources and TaskRuns, with cluster specific metadata removed so it can be applied elsewhere.
+ * Objects are written in the order they need to be created in.
+ * Query parameters:
+ *  - format (yaml, the default, for a multi-document YAML or tar for an archive with a file per object)
+ */
+func (r Resource) exportPipelineRun(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	namespace := request.PathParameter("namespace")
+	format := request.QueryParameter("format")
+	logging.Log.Debugf("In exportPipelineRun, name: %s, namespace: %s, format: %s", name, namespace, format)
+
+	if format != "" && format != "yaml" && format != "tar" {
+		utils.RespondErrorMessage(response, "Error: format must be yaml or tar", http.StatusBadRequest)
+		return
+	}
+
+	objects, err := r.getPipelineRunBundle(name, namespace)
+	if err != nil {
+		utils.RespondError(response, err, http.StatusNotFound)
+		return
+	}
+
+	var content []byte
+	if format == "tar" {
+		content, err = tarBundle(objects)
+	} else {
+		content, err = marshalBundle(objects)
+	}
+	if err != nil {
+		utils.RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+
+	contentType, extension := mimeYAML, "yaml"
+	if format == "tar" {
+		contentType, extension = "application/x-tar", "tar"
+	}
+	response.Header().Set(restful.HEADER_ContentType, contentType)
+	response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.%s", name, extension))
+	response.WriteHeader(http.StatusOK)
+	response.Write(content)
+}
+
+// Only the PipelineRun itself is required, anything it references that can't be found is left out of the bundle
+func (r Resource) getPipelineRunBundle(name, namespace string) ([]bundleObject, error) {
+	tekton := r.PipelineClient.TektonV1alpha1()
+	pipelineRun, err := tekton.PipelineRuns(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var resources, tasks, taskRuns []bundleObject
+	for _, binding := range pipelineRun.Spec.Resources {
+		resource, err := tekton.PipelineResources(namespace).Get(binding.ResourceRef.Name, metav1.GetOptions{})
+		if err != nil {
+			logging.Log.Warnf("PipelineResource %s of PipelineRun %s not exported: %s", binding.ResourceRef.Name, name, err)
+			continue
+		}
+		resources = append(resources, resource)
+	}
+
+	taskNames := []string{}
+	addTask := func(taskName string) {
+		for _, existing := range taskNames {
+			if existing == taskName {
+				return
+			}
+		}
+		taskNames = append(taskNames, taskName)
+	}
+
+	var pipelines []bundleObject
+	pipeline, err := tekton.Pipelines(namespace).Get(pipelineRun.Spec.PipelineRef.Name, metav1.GetOptions{})
+	if err != nil {
+		logging.Log.Warnf("Pipeline %s of PipelineRun %s not exported: %s", pipelineRun.Spec.PipelineRef.Name, name, err)
+	} else {
+		pipelines = append(pipelines, pipeline)
+		for _, pipelineTask := range pipeline.Spec.Tasks {
+			addTask(pipelineTask.TaskRef.Name)
+		}
+	}
+
+	for taskRunName := range pipelineRun.Status.TaskRuns {
+		taskRun, err := tekton.TaskRuns(namespace).Get(taskRunName, metav1.GetOptions{})
+		if err != nil {
+			logging.Log.Warnf("TaskRun %s of PipelineRun %s not exported: %s", taskRunName, name, err)
+			continue
+		}
+		if taskRun.Spec.TaskRef != nil {
+			addTask(taskRun.Spec.TaskRef.Name)
+		}
+		taskRuns = append(taskRuns, taskRun)
+	}
+	// Keep the bundle the same between exports, the TaskRuns come from a map
+	sort.Slice(taskRuns, func(i, j int) bool {
+		return taskRuns[i].GetName() < taskRuns[j].GetName()
+	})
+
+	for _, taskName := range taskNames {
+		task, err := tekton.Tasks(namespace).Get(taskName, metav1.GetOptions{})
+		if err != nil {
+			logging.Log.Warnf("Task %s of PipelineRun %s not exported: %s", taskName, name, err)
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+
+	objects := append(append(append(resources, tasks...), pipelines...), pipelineRun)
+	objects = append(objects, taskRuns...)
+	for _, object := range objects {
+		stripClusterMetadata(object)
+		setTypeMeta(object)
+	}
+	return objects, nil
+}
+
+// Removes everything that is set by the cluster the object was read from
+func stripClusterMetadata(object metav1.Object) {
+	object.SetNamespace("")
+	object.SetUID("")
+	object.SetResourceVersion("")
+	object.SetGeneration(0)
+	object.SetSelfLink("")
+	object.SetCreationTimestamp(metav1.Time{})
+	object.SetOwnerReferences(nil)
+	annotations := object.GetAnnotations()
+	delete(annotations, lastAppliedConfigAnnotation)
+	if len(annotations) == 0 {
+		object.SetAnnotations(nil)
+	}
+}
+
+// Writes the objects as a multi-document YAML
+func marshalBundle(objects []bundleObject) ([]byte, error) {
+	documents := []string{}
+	for _, object := range objects {
+		document, err := yaml.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, string(document))
+	}
+	return []byte(yamlDocumentSeparator + strings.Join(documents, yamlDocumentSeparator)), nil
+}
+
+// Writes the objects as a tar archive with a numbered file per object, so they extract in creation order
+func tarBundle(objects []bundleObject) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	writer := tar.NewWriter(buf)
+	for i, object := range objects {
+		document, err := yaml.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		kind := strings.ToLower(object.GetObjectKind().GroupVersionKind().Kind)
+		header := &tar.Header{
+			Name: fmt.Sprintf("%02d-%s-%s.yaml", i, kind, object.GetName()),
+			Mode: 0644,
+			Size: int64(len(document)),
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write(document); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
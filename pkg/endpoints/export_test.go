@@ -0,0 +1,147 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"archive/tar"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Util to call exportPipelineRun and return the status code and body
+func exportPipelineRunTest(r *Resource, name, query string) (int, string) {
+	httpWriter := httptest.NewRecorder()
+	httpReq := dummyHttpRequest("GET", "http://wwww.dummy.com:8383/v1/namespaces/ns1/pipelinerun/"+name+"/export?"+query, nil)
+	req := dummyRestfulRequest(httpReq, "ns1", name)
+	resp := dummyRestfulResponse(httpWriter)
+	r.exportPipelineRun(req, resp)
+	return resp.StatusCode(), httpWriter.Body.String()
+}
+
+/* Export test: the run and everything it references are exported in creation order without cluster metadata */
+
+func TestExportPipelineRun(t *testing.T) {
+	t.Log("Testing exporting a PipelineRun")
+
+	r := dummyResource()
+	tekton := r.PipelineClient.TektonV1alpha1()
+
+	clusterMeta := func(name string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "ns1",
+			UID:             "1234",
+			ResourceVersion: "5",
+			Annotations:     map[string]string{lastAppliedConfigAnnotation: "{}"},
+		}
+	}
+
+	resource1 := v1alpha1.PipelineResource{ObjectMeta: clusterMeta("resource1"), Spec: v1alpha1.PipelineResourceSpec{Type: v1alpha1.PipelineResourceTypeGit}}
+	task1 := v1alpha1.Task{ObjectMeta: clusterMeta("task1")}
+	task2 := v1alpha1.Task{ObjectMeta: clusterMeta("task2")}
+	pipeline1 := v1alpha1.Pipeline{
+		ObjectMeta: clusterMeta("pipeline1"),
+		Spec: v1alpha1.PipelineSpec{
+			Tasks: []v1alpha1.PipelineTask{
+				{Name: "first", TaskRef: v1alpha1.TaskRef{Name: "task1"}},
+				{Name: "second", TaskRef: v1alpha1.TaskRef{Name: "task2"}},
+				{Name: "third", TaskRef: v1alpha1.TaskRef{Name: "task1"}},
+			},
+		},
+	}
+	pipelineRun1 := v1alpha1.PipelineRun{
+		ObjectMeta: clusterMeta("pipelinerun1"),
+		Spec: v1alpha1.PipelineRunSpec{
+			PipelineRef: v1alpha1.PipelineRef{Name: "pipeline1"},
+			Resources: []v1alpha1.PipelineResourceBinding{
+				{Name: "source", ResourceRef: v1alpha1.PipelineResourceRef{Name: "resource1"}},
+			},
+		},
+		Status: v1alpha1.PipelineRunStatus{
+			TaskRuns: map[string]*v1alpha1.PipelineRunTaskRunStatus{
+				"pipelinerun1-second": {PipelineTaskName: "second"},
+				"pipelinerun1-first":  {PipelineTaskName: "first"},
+				"pipelinerun1-gone":   {PipelineTaskName: "third"},
+			},
+		},
+	}
+	taskRun1 := v1alpha1.TaskRun{ObjectMeta: clusterMeta("pipelinerun1-first"), Spec: v1alpha1.TaskRunSpec{TaskRef: &v1alpha1.TaskRef{Name: "task1"}}}
+	taskRun2 := v1alpha1.TaskRun{ObjectMeta: clusterMeta("pipelinerun1-second"), Spec: v1alpha1.TaskRunSpec{TaskRef: &v1alpha1.TaskRef{Name: "task2"}}}
+
+	tekton.PipelineResources("ns1").Create(&resource1)
+	tekton.Tasks("ns1").Create(&task1)
+	tekton.Tasks("ns1").Create(&task2)
+	tekton.Pipelines("ns1").Create(&pipeline1)
+	tekton.PipelineRuns("ns1").Create(&pipelineRun1)
+	tekton.TaskRuns("ns1").Create(&taskRun1)
+	tekton.TaskRuns("ns1").Create(&taskRun2)
+
+	statusCode, body := exportPipelineRunTest(r, "pipelinerun1", "")
+	if statusCode != 200 {
+		t.Fatalf("FAIL: exporting a PipelineRun should have given a 200, got %d: %s", statusCode, body)
+	}
+
+	var exported []string
+	for _, document := range strings.Split(body, yamlDocumentSeparator) {
+		if document == "" {
+			continue
+		}
+		object := struct {
+			metav1.TypeMeta `json:",inline"`
+			Metadata        metav1.ObjectMeta `json:"metadata"`
+		}{}
+		if err := yaml.Unmarshal([]byte(document), &object); err != nil {
+			t.Fatalf("FAIL: the exported document could not be read: %s: %s", err, document)
+		}
+		metadata := object.Metadata
+		if metadata.Namespace != "" || metadata.UID != "" || metadata.ResourceVersion != "" || len(metadata.Annotations) != 0 {
+			t.Errorf("FAIL: cluster metadata should have been removed from %s: %s", metadata.Name, document)
+		}
+		exported = append(exported, object.Kind+"/"+metadata.Name)
+	}
+	expected := []string{
+		"PipelineResource/resource1",
+		"Task/task1",
+		"Task/task2",
+		"Pipeline/pipeline1",
+		"PipelineRun/pipelinerun1",
+		"TaskRun/pipelinerun1-first",
+		"TaskRun/pipelinerun1-second",
+	}
+	if !reflect.DeepEqual(exported, expected) {
+		t.Errorf("FAIL: expected %v to be exported, got %v", expected, exported)
+	}
+
+	statusCode, body = exportPipelineRunTest(r, "pipelinerun1", "format=tar")
+	if statusCode != 200 {
+		t.Fatalf("FAIL: exporting a PipelineRun as tar should have given a 200, got %d: %s", statusCode, body)
+	}
+	archive := tar.NewReader(strings.NewReader(body))
+	header, err := archive.Next()
+	if err != nil || header.Name != "00-pipelineresource-resource1.yaml" {
+		t.Errorf("FAIL: the tar archive should have started with the PipelineResource, got %v: %s", header, err)
+	}
+
+	if statusCode, _ = exportPipelineRunTest(r, "pipelinerun1", "format=zip"); statusCode != 400 {
+		t.Errorf("FAIL: an unknown format should have given a 400, got %d", statusCode)
+	}
+	if statusCode, _ = exportPipelineRunTest(r, "pipelinerun2", ""); statusCode != 404 {
+		t.Errorf("FAIL: exporting a PipelineRun that doesn't exist should have given a 404, got %d", statusCode)
+	}
+}
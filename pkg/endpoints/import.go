@@ -0,0 +1,341 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+
+	restful "github.com/emicklei/go-restful"
+	logging "github.com/tektoncd/dashboard/pkg/logging"
+	"github.com/tektoncd/dashboard/pkg/utils"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// ImportResult - what an import did, or would do when dryRun is set, to each object
+type ImportResult struct {
+	DRYRUN  bool                 `json:"dryRun"`
+	OBJECTS []ImportObjectResult `json:"objects"`
+}
+
+// ImportObjectResult - what happened to a single imported object
+type ImportObjectResult struct {
+	KIND string `json:"kind"`
+	NAME string `json:"name"`
+	// One of created, updated, unchanged, invalid or failed
+	ACTION string `json:"action"`
+	// Why the object was invalid or failed
+	ERROR string `json:"error,omitempty"`
+	// How the object differs from the one in the cluster, only for updates on a dry run
+	DIFF string `json:"diff,omitempty"`
+}
+
+const (
+	importCreated   = "created"
+	importUpdated   = "updated"
+	importUnchanged = "unchanged"
+	importInvalid   = "invalid"
+	importFailed    = "failed"
+)
+
+// The kinds that can be imported, in the order they are applied so that references resolve
+var importOrder = []string{"PipelineResource", "Task", "Pipeline"}
+
+// A definition read from an import along with its result
+type importObject struct {
+	obj    definition
+	result ImportObjectResult
+}
+
+/* Import a bundle of Tasks, Pipelines and PipelineResources into a given namespace.
+ * The body is a multi-document YAML, a JSON array or a List. Every object is validated, and must
+ * appear only once, before any are applied, they are then created or, if they already exist, replaced.
+ * Query parameters:
+ *  - dryRun (report what would change, with a diff for updates, without changing anything)
+ */
+func (r Resource) importDefinitions(request *restful.Request, response *restful.Response) {
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In importDefinitions, namespace: %s", namespace)
+
+	dryRun := false
+	if value := request.QueryParameter("dryRun"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			utils.RespondErrorAndMessage(response, err, "Error: dryRun must be true or false", http.StatusBadRequest)
+			return
+		}
+		dryRun = parsed
+	}
+
+	documents, err := readImportDocuments(request.Request.Body)
+	if err != nil {
+		utils.RespondErrorAndMessage(response, err, fmt.Sprintf("Error parsing import request body: %s", err), http.StatusBadRequest)
+		return
+	}
+	if len(documents) == 0 {
+		utils.RespondErrorMessage(response, "Error: nothing to import", http.StatusBadRequest)
+		return
+	}
+
+	objects := make([]importObject, len(documents))
+	valid := true
+	imported := make(map[string]bool)
+	for i, document := range documents {
+		objects[i] = readImportObject(document, namespace)
+		// Applying the same object twice would leave whichever came last, with results that contradict each other
+		key := objects[i].result.KIND + "/" + objects[i].result.NAME
+		if objects[i].result.ACTION != importInvalid && imported[key] {
+			objects[i].result.ACTION = importInvalid
+			objects[i].result.ERROR = fmt.Sprintf("%s %s appears more than once in the import", objects[i].result.KIND, objects[i].result.NAME)
+		}
+		imported[key] = true
+		valid = valid && objects[i].result.ACTION != importInvalid
+	}
+	sort.SliceStable(objects, func(i, j int) bool {
+		return importRank(objects[i].result.KIND) < importRank(objects[j].result.KIND)
+	})
+
+	result := ImportResult{DRYRUN: dryRun, OBJECTS: []ImportObjectResult{}}
+	if !valid {
+		for _, object := range objects {
+			result.OBJECTS = append(result.OBJECTS, object.result)
+		}
+		response.WriteHeaderAndEntity(http.StatusUnprocessableEntity, result)
+		return
+	}
+
+	failed := false
+	for _, object := range objects {
+		objectResult := r.applyImportObject(object, namespace, dryRun)
+		failed = failed || objectResult.ACTION == importFailed
+		result.OBJECTS = append(result.OBJECTS, objectResult)
+	}
+	if failed {
+		response.WriteHeaderAndEntity(http.StatusInternalServerError, result)
+		return
+	}
+	response.WriteEntity(result)
+}
+
+// Splits the body into one JSON document per object, expanding arrays and Lists
+func readImportDocuments(body io.Reader) ([]json.RawMessage, error) {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(body, 4096)
+	var documents []json.RawMessage
+	for {
+		var document json.RawMessage
+		err := decoder.Decode(&document)
+		if err == io.EOF {
+			return documents, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		document = bytes.TrimSpace(document)
+		if len(document) == 0 || string(document) == "null" {
+			continue
+		}
+
+		if document[0] == '[' {
+			var items []json.RawMessage
+			if err := json.Unmarshal(document, &items); err != nil {
+				return nil, err
+			}
+			documents = append(documents, items...)
+			continue
+		}
+		list := struct {
+			metav1.TypeMeta `json:",inline"`
+			Items           []json.RawMessage `json:"items"`
+		}{}
+		if err := json.Unmarshal(document, &list); err != nil {
+			return nil, err
+		}
+		if list.Kind == "List" {
+			documents = append(documents, list.Items...)
+			continue
+		}
+		documents = append(documents, document)
+	}
+}
+
+// Reads and validates a single object, the result is invalid if it can't be imported
+func readImportObject(document json.RawMessage, namespace string) importObject {
+	typeMeta := metav1.TypeMeta{}
+	json.Unmarshal(document, &typeMeta)
+	object := importObject{result: ImportObjectResult{KIND: typeMeta.Kind}}
+	invalid := func(message string) importObject {
+		object.result.ACTION = importInvalid
+		object.result.ERROR = message
+		return object
+	}
+
+	if typeMeta.APIVersion != "" && typeMeta.APIVersion != v1alpha1.SchemeGroupVersion.String() {
+		return invalid(fmt.Sprintf("apiVersion must be %s", v1alpha1.SchemeGroupVersion.String()))
+	}
+	switch typeMeta.Kind {
+	case "PipelineResource":
+		object.obj = &v1alpha1.PipelineResource{}
+	case "Task":
+		object.obj = &v1alpha1.Task{}
+	case "Pipeline":
+		object.obj = &v1alpha1.Pipeline{}
+	default:
+		return invalid(fmt.Sprintf("kind must be one of %v", importOrder))
+	}
+	if err := json.Unmarshal(document, object.obj); err != nil {
+		return invalid(err.Error())
+	}
+	object.result.NAME = object.obj.GetName()
+	// Tekton's validation doesn't require a name, but an object without one can't be created or replaced
+	if object.result.NAME == "" {
+		return invalid("metadata.name is required")
+	}
+
+	if object.obj.GetNamespace() != "" && object.obj.GetNamespace() != namespace {
+		return invalid(fmt.Sprintf("namespace %s does not match the namespace in the path: %s", object.obj.GetNamespace(), namespace))
+	}
+	object.obj.SetNamespace(namespace)
+	if fieldError := object.obj.Validate(context.Background()); fieldError != nil {
+		return invalid(fieldError.Error())
+	}
+	return object
+}
+
+func importRank(kind string) int {
+	for i, importKind := range importOrder {
+		if importKind == kind {
+			return i
+		}
+	}
+	return len(importOrder)
+}
+
+// Creates the object if it doesn't exist and replaces it if it does, unless this is a dry run
+func (r Resource) applyImportObject(object importObject, namespace string, dryRun bool) ImportObjectResult {
+	result := object.result
+	fail := func(err error) ImportObjectResult {
+		logging.Log.Errorf("error importing %s %s: %s", result.KIND, result.NAME, err)
+		result.ACTION = importFailed
+		result.ERROR = err.Error()
+		return result
+	}
+
+	current, err := r.getDefinition(result.KIND, namespace, result.NAME)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fail(err)
+	}
+	if err != nil {
+		result.ACTION = importCreated
+		if !dryRun {
+			if err := r.createDefinition(namespace, object.obj); err != nil {
+				return fail(err)
+			}
+		}
+		return result
+	}
+
+	currentContent, err := importComparable(current)
+	if err != nil {
+		return fail(err)
+	}
+	importedContent, err := importComparable(object.obj)
+	if err != nil {
+		return fail(err)
+	}
+	if reflect.DeepEqual(currentContent, importedContent) {
+		result.ACTION = importUnchanged
+		return result
+	}
+
+	result.ACTION = importUpdated
+	if dryRun {
+		result.DIFF = diff.ObjectReflectDiff(currentContent, importedContent)
+		return result
+	}
+	object.obj.SetResourceVersion(current.GetResourceVersion())
+	if err := r.updateDefinition(namespace, object.obj); err != nil {
+		return fail(err)
+	}
+	return result
+}
+
+// The parts of a definition an import replaces, as generic JSON so they can be compared and diffed
+func importComparable(obj definition) (map[string]interface{}, error) {
+	content := map[string]interface{}{}
+	data, err := json.Marshal(obj)
+	if err == nil {
+		err = json.Unmarshal(data, &content)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"labels":      obj.GetLabels(),
+		"annotations": obj.GetAnnotations(),
+		"spec":        content["spec"],
+	}, nil
+}
+
+func (r Resource) getDefinition(kind, namespace, name string) (definition, error) {
+	tekton := r.PipelineClient.TektonV1alpha1()
+	switch kind {
+	case "PipelineResource":
+		return tekton.PipelineResources(namespace).Get(name, metav1.GetOptions{})
+	case "Task":
+		return tekton.Tasks(namespace).Get(name, metav1.GetOptions{})
+	case "Pipeline":
+		return tekton.Pipelines(namespace).Get(name, metav1.GetOptions{})
+	}
+	return nil, fmt.Errorf("unknown kind %s", kind)
+}
+
+func (r Resource) createDefinition(namespace string, obj definition) (err error) {
+	tekton := r.PipelineClient.TektonV1alpha1()
+	switch typed := obj.(type) {
+	case *v1alpha1.PipelineResource:
+		_, err = tekton.PipelineResources(namespace).Create(typed)
+	case *v1alpha1.Task:
+		_, err = tekton.Tasks(namespace).Create(typed)
+	case *v1alpha1.Pipeline:
+		_, err = tekton.Pipelines(namespace).Create(typed)
+	default:
+		err = fmt.Errorf("unknown definition %T", obj)
+	}
+	return err
+}
+
+func (r Resource) updateDefinition(namespace string, obj definition) (err error) {
+	tekton := r.PipelineClient.TektonV1alpha1()
+	switch typed := obj.(type) {
+	case *v1alpha1.PipelineResource:
+		_, err = tekton.PipelineResources(namespace).Update(typed)
+	case *v1alpha1.Task:
+		_, err = tekton.Tasks(namespace).Update(typed)
+	case *v1alpha1.Pipeline:
+		_, err = tekton.Pipelines(namespace).Update(typed)
+	default:
+		err = fmt.Errorf("unknown definition %T", obj)
+	}
+	return err
+}
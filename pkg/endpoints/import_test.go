@@ -0,0 +1,207 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	restful "github.com/emicklei/go-restful"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Util to send a bundle to importDefinitions and return the status code and result
+func importDefinitionsTest(r *Resource, query, contentType, body string) (int, ImportResult) {
+	httpWriter := httptest.NewRecorder()
+	httpReq := dummyHttpRequest("POST", "http://wwww.dummy.com:8383/v1/namespaces/ns1/import?"+query, strings.NewReader(body))
+	httpReq.Header.Set("Content-Type", contentType)
+	req := dummyRestfulRequest(httpReq, "ns1", "")
+	resp := dummyRestfulResponse(httpWriter)
+	r.importDefinitions(req, resp)
+
+	result := ImportResult{}
+	json.NewDecoder(httpWriter.Body).Decode(&result)
+	return resp.StatusCode(), result
+}
+
+// Summarises a result as kind/name:action for comparison
+func importActions(result ImportResult) []string {
+	actions := []string{}
+	for _, object := range result.OBJECTS {
+		actions = append(actions, object.KIND+"/"+object.NAME+":"+object.ACTION)
+	}
+	return actions
+}
+
+const importBundle = `---
+apiVersion: tekton.dev/v1alpha1
+kind: Pipeline
+metadata:
+  name: pipeline1
+spec:
+  tasks:
+  - name: build
+    taskRef:
+      name: task1
+---
+apiVersion: tekton.dev/v1alpha1
+kind: Task
+metadata:
+  name: task1
+spec:
+  steps:
+  - name: step1
+    image: busybox
+---
+apiVersion: tekton.dev/v1alpha1
+kind: PipelineResource
+metadata:
+  name: resource1
+spec:
+  type: git
+  params:
+  - name: url
+    value: https://github.com/foo/bar
+`
+
+/* Import test: objects are applied in dependency order, created then updated or left unchanged */
+
+func TestImportDefinitions(t *testing.T) {
+	t.Log("Testing importing a bundle of definitions")
+
+	r := dummyResource()
+
+	statusCode, result := importDefinitionsTest(r, "", mimeYAML, importBundle)
+	expected := []string{"PipelineResource/resource1:created", "Task/task1:created", "Pipeline/pipeline1:created"}
+	if statusCode != 200 || !reflect.DeepEqual(importActions(result), expected) {
+		t.Fatalf("FAIL: expected %v with a 200, got %d: %v", expected, statusCode, result)
+	}
+	if _, err := r.PipelineClient.TektonV1alpha1().Pipelines("ns1").Get("pipeline1", metav1.GetOptions{}); err != nil {
+		t.Errorf("FAIL: pipeline1 should have been created: %s", err)
+	}
+
+	changed := strings.Replace(importBundle, "image: busybox", "image: alpine", 1)
+	statusCode, result = importDefinitionsTest(r, "dryRun=true", mimeYAML, changed)
+	expected = []string{"PipelineResource/resource1:unchanged", "Task/task1:updated", "Pipeline/pipeline1:unchanged"}
+	if statusCode != 200 || !result.DRYRUN || !reflect.DeepEqual(importActions(result), expected) {
+		t.Fatalf("FAIL: dry run: expected %v with a 200, got %d: %v", expected, statusCode, result)
+	}
+	if !strings.Contains(result.OBJECTS[1].DIFF, "alpine") {
+		t.Errorf("FAIL: the dry run should have included a diff for task1, got: %s", result.OBJECTS[1].DIFF)
+	}
+	task, _ := r.PipelineClient.TektonV1alpha1().Tasks("ns1").Get("task1", metav1.GetOptions{})
+	if task.Spec.Steps[0].Image != "busybox" {
+		t.Errorf("FAIL: a dry run should not have updated task1")
+	}
+
+	statusCode, result = importDefinitionsTest(r, "", mimeYAML, changed)
+	if statusCode != 200 || !reflect.DeepEqual(importActions(result), expected) {
+		t.Fatalf("FAIL: expected %v with a 200, got %d: %v", expected, statusCode, result)
+	}
+	task, _ = r.PipelineClient.TektonV1alpha1().Tasks("ns1").Get("task1", metav1.GetOptions{})
+	if task.Spec.Steps[0].Image != "alpine" {
+		t.Errorf("FAIL: task1 should have been updated, got image %s", task.Spec.Steps[0].Image)
+	}
+}
+
+/* Import test: bundles may be JSON arrays or Lists */
+
+func TestImportDefinitionsJSON(t *testing.T) {
+	t.Log("Testing importing definitions as JSON")
+
+	r := dummyResource()
+
+	task := `{"apiVersion": "tekton.dev/v1alpha1", "kind": "Task", "metadata": {"name": "task1"}, "spec": {"steps": [{"name": "step1", "image": "busybox"}]}}`
+	task2 := strings.Replace(task, "task1", "task2", 1)
+
+	statusCode, result := importDefinitionsTest(r, "", restful.MIME_JSON, "["+task+"]")
+	if statusCode != 200 || !reflect.DeepEqual(importActions(result), []string{"Task/task1:created"}) {
+		t.Errorf("FAIL: importing a JSON array should have created task1, got %d: %v", statusCode, result)
+	}
+	statusCode, result = importDefinitionsTest(r, "", restful.MIME_JSON, `{"apiVersion": "v1", "kind": "List", "items": [`+task+`, `+task2+`]}`)
+	if statusCode != 200 || !reflect.DeepEqual(importActions(result), []string{"Task/task1:unchanged", "Task/task2:created"}) {
+		t.Errorf("FAIL: importing a List should have created task2, got %d: %v", statusCode, result)
+	}
+}
+
+/* Import validation test: nothing is applied if any object is invalid */
+
+func TestImportDefinitionsInvalid(t *testing.T) {
+	t.Log("Testing invalid imports are rejected")
+
+	r := dummyResource()
+
+	invalid := importBundle + `---
+apiVersion: tekton.dev/v1alpha1
+kind: PipelineRun
+metadata:
+  name: pipelinerun1
+`
+	statusCode, result := importDefinitionsTest(r, "", mimeYAML, invalid)
+	if statusCode != 422 || len(result.OBJECTS) != 4 || result.OBJECTS[3].ACTION != importInvalid {
+		t.Errorf("FAIL: importing an unsupported kind should have given a 422, got %d: %v", statusCode, result)
+	}
+	if _, err := r.PipelineClient.TektonV1alpha1().Tasks("ns1").Get("task1", metav1.GetOptions{}); err == nil {
+		t.Errorf("FAIL: nothing should have been imported when an object is invalid")
+	}
+
+	invalid = strings.Replace(importBundle, "type: git", "type: notatype", 1)
+	if statusCode, result = importDefinitionsTest(r, "", mimeYAML, invalid); statusCode != 422 {
+		t.Errorf("FAIL: importing an invalid PipelineResource should have given a 422, got %d: %v", statusCode, result)
+	}
+
+	duplicated := importBundle + `---
+apiVersion: tekton.dev/v1alpha1
+kind: Task
+metadata:
+  name: task1
+spec:
+  steps:
+  - name: step1
+    image: alpine
+`
+	statusCode, result = importDefinitionsTest(r, "", mimeYAML, duplicated)
+	expected := []string{"PipelineResource/resource1:", "Task/task1:", "Task/task1:invalid", "Pipeline/pipeline1:"}
+	if statusCode != 422 || !reflect.DeepEqual(importActions(result), expected) {
+		t.Errorf("FAIL: importing task1 twice should have given a 422 with the second invalid, got %d: %v", statusCode, importActions(result))
+	}
+
+	unnamed := importBundle + `---
+apiVersion: tekton.dev/v1alpha1
+kind: Task
+spec:
+  steps:
+  - name: step1
+    image: alpine
+`
+	statusCode, result = importDefinitionsTest(r, "", mimeYAML, unnamed)
+	expected = []string{"PipelineResource/resource1:", "Task/task1:", "Task/:invalid", "Pipeline/pipeline1:"}
+	if statusCode != 422 || !reflect.DeepEqual(importActions(result), expected) {
+		t.Errorf("FAIL: importing a Task without a name should have given a 422 with it invalid, got %d: %v", statusCode, importActions(result))
+	}
+	if _, err := r.PipelineClient.TektonV1alpha1().Tasks("ns1").Get("task1", metav1.GetOptions{}); err == nil {
+		t.Errorf("FAIL: nothing should have been imported when an object has no name")
+	}
+
+	for _, body := range []string{"", "---\n", "key: [unclosed"} {
+		if statusCode, _ = importDefinitionsTest(r, "", mimeYAML, body); statusCode != 400 {
+			t.Errorf("FAIL: importing %q should have given a 400, got %d", body, statusCode)
+		}
+	}
+	if statusCode, _ = importDefinitionsTest(r, "dryRun=maybe", mimeYAML, importBundle); statusCode != 400 {
+		t.Errorf("FAIL: an invalid dryRun should have given a 400, got %d", statusCode)
+	}
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tektoncd/dashboard/pkg/archive"
+	logging "github.com/tektoncd/dashboard/pkg/logging"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// What was archived for a TaskRun, written after its container logs so it is only found once they are all stored
+type archivedTaskRun struct {
+	UID         string
+	PodName     string
+	PipelineRun string
+	Containers  []archivedContainer
+}
+
+type archivedContainer struct {
+	Name string
+	// One of step, pod or init
+	Type  string
+	Image string
+	State v1.ContainerState
+}
+
+// TaskRuns archived or being archived since the dashboard started, namespace/name -> UID
+var archivedTaskRuns = new(sync.Map)
+
+// Limits how many TaskRuns are archived at once, there may be many completed TaskRuns when the dashboard starts
+var archiveSlots = make(chan struct{}, 4)
+
+func archivedTaskRunKey(namespace, taskRunName string) string {
+	return namespace + "/" + taskRunName + "/manifest.json"
+}
+
+func archivedContainerKey(namespace, taskRunName, container string) string {
+	return namespace + "/" + taskRunName + "/containers/" + container + ".log"
+}
+
+func (a archivedTaskRun) logSources() []logSource {
+	var sources []logSource
+	for _, container := range a.Containers {
+		sources = append(sources, logSource{containerType: container.Type, container: container.Name, image: container.Image, state: container.State})
+	}
+	return sources
+}
+
+/* Archive the container logs of a completed TaskRun, unless it has already been archived.
+ * Logs are stored with timestamps so the log query parameters still work once they are served from the archive,
+ * and with credentials redacted so they aren't kept outside the cluster.
+ */
+func (r Resource) archiveTaskRunLogs(taskRun *v1alpha1.TaskRun) {
+	if r.LogArchive == nil || taskRun.Status.PodName == "" {
+		return
+	}
+	key := taskRun.Namespace + "/" + taskRun.Name
+	if uid, ok := archivedTaskRuns.Load(key); ok && uid == string(taskRun.UID) {
+		return
+	}
+	archivedTaskRuns.Store(key, string(taskRun.UID))
+
+	go func() {
+		archiveSlots <- struct{}{}
+		defer func() { <-archiveSlots }()
+
+		if archived, ok := r.getArchivedTaskRun(taskRun.Namespace, taskRun.Name); ok && archived.UID == string(taskRun.UID) {
+			return
+		}
+		pod, err := r.K8sClient.CoreV1().Pods(taskRun.Namespace).Get(taskRun.Status.PodName, metav1.GetOptions{})
+		if err != nil {
+			logging.Log.Debugf("Could not get pod %s to archive the logs of TaskRun %s: %s", taskRun.Status.PodName, key, err)
+			if !k8serrors.IsNotFound(err) {
+				// Tried again when the TaskRun is next resynced
+				archivedTaskRuns.Delete(key)
+			}
+			return
+		}
+
+		archived := archivedTaskRun{
+			UID:         string(taskRun.UID),
+			PodName:     pod.Name,
+			PipelineRun: taskRun.Labels[pipelineRunLabel],
+		}
+		redactor := r.getLogRedactor(taskRun.Namespace, taskRun.Spec.ServiceAccount)
+		open := redactor.redactLogs(r.podLogOpener(taskRun.Namespace, pod.Name, v1.PodLogOptions{Timestamps: true}))
+		sources := getLogSources(pod, func(container string) bool {
+			return strings.HasPrefix(container, stepContainerPrefix)
+		})
+		for _, source := range sources {
+			podLogs, ok := open(source.container)
+			if !ok {
+				continue
+			}
+			err := r.LogArchive.Write(archivedContainerKey(taskRun.Namespace, taskRun.Name, source.container), podLogs)
+			podLogs.Close()
+			if err != nil {
+				logging.Log.Errorf("Error archiving the log of %s in TaskRun %s: %s", source.container, key, err)
+				continue
+			}
+			archived.Containers = append(archived.Containers, archivedContainer{Name: source.container, Type: source.containerType, Image: source.image, State: source.state})
+		}
+
+		manifest, _ := json.Marshal(archived)
+		if err := r.LogArchive.Write(archivedTaskRunKey(taskRun.Namespace, taskRun.Name), bytes.NewReader(manifest)); err != nil {
+			logging.Log.Errorf("Error archiving TaskRun %s: %s", key, err)
+			archivedTaskRuns.Delete(key)
+			return
+		}
+		logging.Log.Debugf("Archived the logs of TaskRun %s", key)
+	}()
+}
+
+// False if there's no archive or the TaskRun isn't in it
+func (r Resource) getArchivedTaskRun(namespace, taskRunName string) (archivedTaskRun, bool) {
+	archived := archivedTaskRun{}
+	if r.LogArchive == nil {
+		return archived, false
+	}
+	manifest, err := r.LogArchive.Read(archivedTaskRunKey(namespace, taskRunName))
+	if err != nil {
+		if err != archive.ErrNotFound {
+			logging.Log.Errorf("Error reading archived TaskRun %s/%s: %s", namespace, taskRunName, err)
+		}
+		return archived, false
+	}
+	defer manifest.Close()
+	if err := json.NewDecoder(manifest).Decode(&archived); err != nil {
+		logging.Log.Errorf("Error reading archived TaskRun %s/%s: %s", namespace, taskRunName, err)
+		return archived, false
+	}
+	return archived, true
+}
+
+/* Opens archived container logs, applying the log options as the pod would have.
+ * Following isn't needed as archived logs are complete, and there's only one instance of each container to read.
+ */
+func (r Resource) archiveLogOpener(namespace, taskRunName string, options v1.PodLogOptions) logOpener {
+	return func(container string) (io.ReadCloser, bool) {
+		stored, err := r.LogArchive.Read(archivedContainerKey(namespace, taskRunName, container))
+		if err != nil {
+			if err != archive.ErrNotFound {
+				logging.Log.Errorf("Error reading the archived log of %s in TaskRun %s/%s: %s", container, namespace, taskRunName, err)
+			}
+			return nil, false
+		}
+		reader, writer := io.Pipe()
+		go func() {
+			defer stored.Close()
+			writer.CloseWithError(filterArchivedLog(stored, writer, options, time.Now()))
+		}()
+		return reader, true
+	}
+}
+
+// Writes the lines of an archived log that the options select
+func filterArchivedLog(stored io.Reader, writer io.Writer, options v1.PodLogOptions, now time.Time) error {
+	var since time.Time
+	if options.SinceTime != nil {
+		since = options.SinceTime.Time
+	}
+	if options.SinceSeconds != nil {
+		since = now.Add(-time.Duration(*options.SinceSeconds) * time.Second)
+	}
+	remaining := int64(-1)
+	if options.LimitBytes != nil {
+		remaining = *options.LimitBytes
+	}
+
+	var writeErr error
+	write := func(line string) {
+		if writeErr != nil || remaining == 0 {
+			return
+		}
+		data := []byte(line + "\n")
+		if remaining > 0 {
+			if int64(len(data)) > remaining {
+				data = data[:remaining]
+			}
+			remaining -= int64(len(data))
+		}
+		_, writeErr = writer.Write(data)
+	}
+
+	var tail []string
+	err := scanLogLines(stored, func(line string) {
+		timestamp, text := splitLogTimestamp(line)
+		if !since.IsZero() && timestamp.Before(since) {
+			return
+		}
+		if options.Timestamps {
+			text = line
+		}
+		if options.TailLines == nil {
+			write(text)
+			return
+		}
+		tail = append(tail, text)
+		if int64(len(tail)) > *options.TailLines {
+			tail = tail[1:]
+		}
+	})
+	for _, line := range tail {
+		write(line)
+	}
+	if err != nil {
+		return err
+	}
+	return writeErr
+}
+
+// Lines are archived with the RFC3339 timestamp Kubernetes prefixes them with, a zero time if there isn't one
+func splitLogTimestamp(line string) (time.Time, string) {
+	space := strings.IndexByte(line, ' ')
+	if space < 0 {
+		space = len(line)
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, line[:space])
+	if err != nil {
+		return time.Time{}, line
+	}
+	if space == len(line) {
+		return timestamp, ""
+	}
+	return timestamp, line[space+1:]
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tektoncd/dashboard/pkg/archive"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Util to archive logs in memory
+type dummyLogArchive map[string]string
+
+func (a dummyLogArchive) Write(key string, content io.Reader) error {
+	data, err := ioutil.ReadAll(content)
+	a[key] = string(data)
+	return err
+}
+
+func (a dummyLogArchive) Read(key string) (io.ReadCloser, error) {
+	data, ok := a[key]
+	if !ok {
+		return nil, archive.ErrNotFound
+	}
+	return ioutil.NopCloser(strings.NewReader(data)), nil
+}
+
+const dummyArchivedLog = "2019-04-01T10:00:00.000000001Z first\n" +
+	"2019-04-01T10:00:01Z second\n" +
+	"2019-04-01T10:00:02Z third\n"
+
+func dummyArchivedTaskRun(r *Resource) dummyLogArchive {
+	logArchive := dummyLogArchive{}
+	manifest, _ := json.Marshal(archivedTaskRun{
+		UID:         "uid1",
+		PodName:     "pod1",
+		PipelineRun: "pipelinerun1",
+		Containers: []archivedContainer{
+			{Name: "init1", Type: initContainerType},
+			{Name: "build-step-one", Type: stepContainerType, State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 1}}},
+		},
+	})
+	logArchive[archivedTaskRunKey("ns1", "taskrun1")] = string(manifest)
+	logArchive[archivedContainerKey("ns1", "taskrun1", "init1")] = "2019-04-01T09:59:59Z setup\n"
+	logArchive[archivedContainerKey("ns1", "taskrun1", "build-step-one")] = dummyArchivedLog
+	r.LogArchive = logArchive
+	return logArchive
+}
+
+/* Log archive test: archived logs are filtered by the log options as the pod's logs would be */
+
+func TestFilterArchivedLog(t *testing.T) {
+	t.Log("Testing the log options are applied to archived logs")
+
+	int64Pointer := func(value int64) *int64 { return &value }
+	now := time.Date(2019, 4, 1, 10, 0, 3, 0, time.UTC)
+	tests := []struct {
+		options  v1.PodLogOptions
+		expected string
+	}{
+		{v1.PodLogOptions{}, "first\nsecond\nthird\n"},
+		{v1.PodLogOptions{Timestamps: true}, dummyArchivedLog},
+		{v1.PodLogOptions{TailLines: int64Pointer(2)}, "second\nthird\n"},
+		{v1.PodLogOptions{TailLines: int64Pointer(0)}, ""},
+		{v1.PodLogOptions{SinceSeconds: int64Pointer(2)}, "second\nthird\n"},
+		{v1.PodLogOptions{SinceTime: &metav1.Time{Time: now.Add(-time.Second)}}, "third\n"},
+		{v1.PodLogOptions{LimitBytes: int64Pointer(8)}, "first\nse"},
+	}
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		if err := filterArchivedLog(strings.NewReader(dummyArchivedLog), buf, test.options, now); err != nil {
+			t.Errorf("FAIL: error filtering the log with %v: %s", test.options, err)
+		}
+		if buf.String() != test.expected {
+			t.Errorf("FAIL: with %v expected %q, got %q", test.options, test.expected, buf.String())
+		}
+	}
+}
+
+/* Log archive test: the log of a TaskRun whose pod is gone is read from the archive */
+
+func TestGetArchivedTaskRunLog(t *testing.T) {
+	t.Log("Testing TaskRun logs fall back to the log archive")
+
+	r := dummyResource()
+	dummyArchivedTaskRun(r)
+
+	httpWriter := httptest.NewRecorder()
+	httpReq := dummyHttpRequest("GET", "http://wwww.dummy.com:8383/v1/namespaces/ns1/taskrunlog/taskrun1?tailLines=1", nil)
+	req := dummyRestfulRequest(httpReq, "ns1", "taskrun1")
+	resp := dummyRestfulResponse(httpWriter)
+	r.getTaskRunLog(req, resp)
+	if resp.StatusCode() != 200 {
+		t.Fatalf("FAIL: the archived log should have been found, got %d", resp.StatusCode())
+	}
+	exitCode := int32(1)
+	expected := TaskRunLog{
+		PodName: "pod1",
+		StepContainers: []LogContainer{
+			{Name: "build-step-one", Logs: []string{"third"}, LogContainerMetadata: LogContainerMetadata{
+				StepName: "one",
+				State:    "terminated",
+				ExitCode: &exitCode,
+			}},
+		},
+		InitContainers: []LogContainer{
+			{Name: "init1", Logs: []string{"setup"}},
+		},
+	}
+	expectedJSON, _ := json.Marshal(expected)
+	if httpWriter.Body.String() != string(expectedJSON)+"\n" {
+		t.Errorf("FAIL: expected %s, got %s", expectedJSON, httpWriter.Body.String())
+	}
+
+	// A TaskRun of the same name that wasn't archived
+	taskRun := v1alpha1.TaskRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "taskrun1", Namespace: "ns1", UID: "uid2"},
+		Status:     v1alpha1.TaskRunStatus{PodName: "pod2"},
+	}
+	r.PipelineClient.TektonV1alpha1().TaskRuns("ns1").Create(&taskRun)
+	httpWriter = httptest.NewRecorder()
+	resp = dummyRestfulResponse(httpWriter)
+	r.getTaskRunLog(dummyRestfulRequest(httpReq, "ns1", "taskrun1"), resp)
+	if resp.StatusCode() != 404 {
+		t.Errorf("FAIL: the log of another TaskRun of the same name should not have been given, got %d", resp.StatusCode())
+	}
+}
+
+/* Log archive test: a TaskRun without a pod yet and nothing archived has no log */
+
+func TestGetPendingTaskRunLog(t *testing.T) {
+	t.Log("Testing the log of a TaskRun without a pod")
+
+	r := dummyResource()
+	taskRun := v1alpha1.TaskRun{ObjectMeta: metav1.ObjectMeta{Name: "taskrun1", Namespace: "ns1"}}
+	r.PipelineClient.TektonV1alpha1().TaskRuns("ns1").Create(&taskRun)
+
+	httpWriter := httptest.NewRecorder()
+	httpReq := dummyHttpRequest("GET", "http://wwww.dummy.com:8383/v1/namespaces/ns1/taskrunlog/taskrun1", nil)
+	resp := dummyRestfulResponse(httpWriter)
+	r.getTaskRunLog(dummyRestfulRequest(httpReq, "ns1", "taskrun1"), resp)
+	if resp.StatusCode() != 404 || !strings.Contains(httpWriter.Body.String(), "has no pod") {
+		t.Errorf("FAIL: the TaskRun without a pod should have given a 404, got %d: %s", resp.StatusCode(), httpWriter.Body.String())
+	}
+}
+
+/* Log archive test: completed TaskRuns are only archived when there is an archive, and only once */
+
+func TestArchiveTaskRunLogs(t *testing.T) {
+	t.Log("Testing completed TaskRuns are archived once")
+
+	r := dummyResource()
+	taskRun := v1alpha1.TaskRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "taskrun1", Namespace: "ns1", UID: "uid1"},
+		Status:     v1alpha1.TaskRunStatus{PodName: "pod1"},
+	}
+	r.archiveTaskRunLogs(&taskRun)
+	if _, ok := archivedTaskRuns.Load("ns1/taskrun1"); ok {
+		t.Errorf("FAIL: nothing should be archived without a log archive")
+	}
+
+	logArchive := dummyArchivedTaskRun(r)
+	manifest := logArchive[archivedTaskRunKey("ns1", "taskrun1")]
+	r.archiveTaskRunLogs(&taskRun)
+	if uid, _ := archivedTaskRuns.Load("ns1/taskrun1"); uid != "uid1" {
+		t.Errorf("FAIL: the TaskRun should have been recorded as archived, got %v", uid)
+	}
+	r.taskRunDeleted(&taskRun)
+	if _, ok := archivedTaskRuns.Load("ns1/taskrun1"); ok {
+		t.Errorf("FAIL: a deleted TaskRun should have been forgotten")
+	}
+	if logArchive[archivedTaskRunKey("ns1", "taskrun1")] != manifest {
+		t.Errorf("FAIL: an archived TaskRun should not have been archived again")
+	}
+}
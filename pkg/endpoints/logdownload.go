@@ -0,0 +1,243 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	duckv1alpha1 "github.com/knative/pkg/apis/duck/v1alpha1"
+	logging "github.com/tektoncd/dashboard/pkg/logging"
+	"github.com/tektoncd/dashboard/pkg/utils"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Name of the manifest in a log download
+const logManifestFile = "manifest.json"
+
+// LogDownloadManifest - describes the TaskRuns and container logs in a PipelineRun's log download
+type LogDownloadManifest struct {
+	NAMESPACE   string               `json:"namespace"`
+	PIPELINERUN string               `json:"pipelinerun"`
+	TASKRUNS    []LogDownloadTaskRun `json:"taskruns"`
+}
+
+// LogDownloadTaskRun - a TaskRun in a log download
+type LogDownloadTaskRun struct {
+	TASKRUN      string `json:"taskrun"`
+	PIPELINETASK string `json:"pipelineTask"`
+	POD          string `json:"pod"`
+	// True, False or Unknown while it is running
+	SUCCEEDED      string                 `json:"succeeded"`
+	REASON         string                 `json:"reason,omitempty"`
+	STARTTIME      *metav1.Time           `json:"startTime,omitempty"`
+	COMPLETIONTIME *metav1.Time           `json:"completionTime,omitempty"`
+	CONTAINERS     []LogDownloadContainer `json:"containers"`
+}
+
+// LogDownloadContainer - a container of a TaskRun in a log download
+type LogDownloadContainer struct {
+	NAME string `json:"name"`
+	// One of step, pod or init
+	CONTAINERTYPE string `json:"containerType"`
+	// Path of the container's log in the download, empty if its log couldn't be read
+	FILE string `json:"file,omitempty"`
+	// One of waiting, running or terminated, empty if it isn't known
+	STATE      string       `json:"state,omitempty"`
+	EXITCODE   *int32       `json:"exitCode,omitempty"`
+	REASON     string       `json:"reason,omitempty"`
+	STARTEDAT  *metav1.Time `json:"startedAt,omitempty"`
+	FINISHEDAT *metav1.Time `json:"finishedAt,omitempty"`
+}
+
+// Writes the files of a log download
+type logDownloadWriter interface {
+	writeFile(name string, content io.Reader) error
+	Close() error
+}
+
+/* Download the logs of a given PipelineRun by name in a given namespace as an archive,
+ * with a file per container at taskrun/container.log and a manifest describing them.
+ * Query parameters:
+ *  - format (zip, the default, or tar)
+ *  - the log query parameters, other than follow
+ */
+func (r Resource) downloadPipelineRunLog(request *restful.Request, response *restful.Response) {
+	namespace := request.PathParameter("namespace")
+	name := request.PathParameter("name")
+	format := request.QueryParameter("format")
+	logging.Log.Debugf("In downloadPipelineRunLog, name: %s, namespace: %s, format: %s", name, namespace, format)
+
+	if format != "" && format != "zip" && format != "tar" {
+		utils.RespondErrorMessage(response, "Error: format must be zip or tar", http.StatusBadRequest)
+		return
+	}
+	options, ok := getLogOptions(request, response)
+	if !ok {
+		return
+	}
+	if options.Follow {
+		utils.RespondErrorMessage(response, "Error: follow is not supported when downloading", http.StatusBadRequest)
+		return
+	}
+
+	pipelinerun, err := r.PipelineClient.TektonV1alpha1().PipelineRuns(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		utils.RespondError(response, err, http.StatusNotFound)
+		return
+	}
+
+	contentType, extension := "application/zip", "zip"
+	if format == "tar" {
+		contentType, extension = "application/x-tar", "tar"
+	}
+	response.Header().Set(restful.HEADER_ContentType, contentType)
+	response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-logs.%s", name, extension))
+	response.WriteHeader(http.StatusOK)
+
+	var writer logDownloadWriter
+	if format == "tar" {
+		writer = &tarLogDownloadWriter{writer: tar.NewWriter(response)}
+	} else {
+		writer = &zipLogDownloadWriter{writer: zip.NewWriter(response)}
+	}
+	if err := r.writeLogDownload(writer, namespace, pipelinerun, options); err != nil {
+		// Too late for an error status, the download is left incomplete
+		logging.Log.Errorf("Error writing the log download of PipelineRun %s: %s", name, err)
+		return
+	}
+	if err := writer.Close(); err != nil {
+		logging.Log.Errorf("Error writing the log download of PipelineRun %s: %s", name, err)
+	}
+}
+
+// The manifest is written last, once it is known which logs could be read
+func (r Resource) writeLogDownload(writer logDownloadWriter, namespace string, pipelinerun *v1alpha1.PipelineRun, options v1.PodLogOptions) error {
+	manifest := LogDownloadManifest{NAMESPACE: namespace, PIPELINERUN: pipelinerun.Name, TASKRUNS: []LogDownloadTaskRun{}}
+	var err error
+	r.forEachTaskRunLog(namespace, pipelinerun, options, func(key, podname string, sources []logSource, open logOpener) bool {
+		taskRun := getLogDownloadTaskRun(key, podname, pipelinerun.Status.TaskRuns[key])
+		for _, source := range sources {
+			container := getLogDownloadContainer(source)
+			if podLogs, ok := open(source.container); ok {
+				container.FILE = key + "/" + source.container + ".log"
+				err = writer.writeFile(container.FILE, podLogs)
+				podLogs.Close()
+				if err != nil {
+					return false
+				}
+			}
+			taskRun.CONTAINERS = append(taskRun.CONTAINERS, container)
+		}
+		manifest.TASKRUNS = append(manifest.TASKRUNS, taskRun)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writer.writeFile(logManifestFile, bytes.NewReader(content))
+}
+
+func getLogDownloadTaskRun(name, podName string, status *v1alpha1.PipelineRunTaskRunStatus) LogDownloadTaskRun {
+	taskRun := LogDownloadTaskRun{
+		TASKRUN:        name,
+		PIPELINETASK:   status.PipelineTaskName,
+		POD:            podName,
+		SUCCEEDED:      string(v1.ConditionUnknown),
+		STARTTIME:      status.Status.StartTime,
+		COMPLETIONTIME: status.Status.CompletionTime,
+		CONTAINERS:     []LogDownloadContainer{},
+	}
+	if condition := status.Status.GetCondition(duckv1alpha1.ConditionSucceeded); condition != nil {
+		taskRun.SUCCEEDED = string(condition.Status)
+		taskRun.REASON = condition.Reason
+	}
+	return taskRun
+}
+
+func getLogDownloadContainer(source logSource) LogDownloadContainer {
+	metadata := getLogContainerMetadata(source)
+	return LogDownloadContainer{
+		NAME:          source.container,
+		CONTAINERTYPE: source.containerType,
+		STATE:         metadata.State,
+		EXITCODE:      metadata.ExitCode,
+		REASON:        metadata.Reason,
+		STARTEDAT:     metadata.StartedAt,
+		FINISHEDAT:    metadata.FinishedAt,
+	}
+}
+
+type zipLogDownloadWriter struct {
+	writer *zip.Writer
+}
+
+func (w *zipLogDownloadWriter) writeFile(name string, content io.Reader) error {
+	file, err := w.writer.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, content)
+	return err
+}
+
+func (w *zipLogDownloadWriter) Close() error {
+	return w.writer.Close()
+}
+
+// A tar header needs the size of the file, so each log is read into a temporary file first rather than held in memory
+type tarLogDownloadWriter struct {
+	writer *tar.Writer
+}
+
+func (w *tarLogDownloadWriter) writeFile(name string, content io.Reader) error {
+	file, err := ioutil.TempFile("", "logdownload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	size, err := io.Copy(file, content)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	header := &tar.Header{Name: name, Mode: 0644, Size: size, ModTime: time.Now()}
+	if err := w.writer.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(w.writer, file)
+	return err
+}
+
+func (w *tarLogDownloadWriter) Close() error {
+	return w.writer.Close()
+}
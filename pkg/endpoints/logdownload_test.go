@@ -0,0 +1,117 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	duckv1alpha1 "github.com/knative/pkg/apis/duck/v1alpha1"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Util to download the logs of a PipelineRun, giving the files in the download
+func downloadPipelineRunLogTest(r *Resource, query string) (int, map[string]string) {
+	httpWriter := httptest.NewRecorder()
+	httpReq := dummyHttpRequest("GET", "http://wwww.dummy.com:8383/v1/namespaces/ns1/pipelinerunlog/pipelinerun1/download?"+query, nil)
+	req := dummyRestfulRequest(httpReq, "ns1", "pipelinerun1")
+	resp := dummyRestfulResponse(httpWriter)
+	r.downloadPipelineRunLog(req, resp)
+
+	files := make(map[string]string)
+	body := httpWriter.Body.Bytes()
+	switch httpWriter.Header().Get("Content-Type") {
+	case "application/zip":
+		reader, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			return resp.StatusCode(), nil
+		}
+		for _, file := range reader.File {
+			content, _ := file.Open()
+			data, _ := ioutil.ReadAll(content)
+			files[file.Name] = string(data)
+		}
+	case "application/x-tar":
+		reader := tar.NewReader(bytes.NewReader(body))
+		for {
+			header, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return resp.StatusCode(), nil
+			}
+			data, _ := ioutil.ReadAll(reader)
+			files[header.Name] = string(data)
+		}
+	}
+	return resp.StatusCode(), files
+}
+
+/* Log download test: each container's log is a file, described by the manifest */
+
+func TestDownloadPipelineRunLog(t *testing.T) {
+	t.Log("Testing the logs of a PipelineRun are downloaded as an archive")
+
+	r := dummyResource()
+	dummyArchivedTaskRun(r)
+	taskRunStatus := v1alpha1.TaskRunStatus{PodName: "pod1"}
+	taskRunStatus.SetCondition(&duckv1alpha1.Condition{Type: duckv1alpha1.ConditionSucceeded, Status: corev1.ConditionFalse, Reason: "Failed"})
+	pipelineRun := v1alpha1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "pipelinerun1", Namespace: "ns1"},
+		Status: v1alpha1.PipelineRunStatus{
+			TaskRuns: map[string]*v1alpha1.PipelineRunTaskRunStatus{
+				"taskrun1": {PipelineTaskName: "build", Status: &taskRunStatus},
+			},
+		},
+	}
+	r.PipelineClient.TektonV1alpha1().PipelineRuns("ns1").Create(&pipelineRun)
+
+	for _, format := range []string{"zip", "tar"} {
+		code, files := downloadPipelineRunLogTest(r, "format="+format)
+		if code != 200 {
+			t.Fatalf("FAIL: the %s download should have succeeded, got %d", format, code)
+		}
+		if files["taskrun1/build-step-one.log"] != "first\nsecond\nthird\n" || files["taskrun1/init1.log"] != "setup\n" {
+			t.Errorf("FAIL: the %s download should have had a file per container, got %v", format, files)
+		}
+
+		manifest := LogDownloadManifest{}
+		if err := json.Unmarshal([]byte(files[logManifestFile]), &manifest); err != nil {
+			t.Fatalf("FAIL: the %s download should have had a manifest: %s", format, err)
+		}
+		if len(manifest.TASKRUNS) != 1 || len(manifest.TASKRUNS[0].CONTAINERS) != 2 {
+			t.Fatalf("FAIL: the manifest should have described the TaskRun and its containers, got %v", manifest)
+		}
+		taskRun := manifest.TASKRUNS[0]
+		if taskRun.PIPELINETASK != "build" || taskRun.SUCCEEDED != "False" || taskRun.REASON != "Failed" {
+			t.Errorf("FAIL: the manifest should have given the TaskRun's status, got %v", taskRun)
+		}
+		step := taskRun.CONTAINERS[1]
+		if step.FILE != "taskrun1/build-step-one.log" || step.STATE != "terminated" || step.EXITCODE == nil || *step.EXITCODE != 1 {
+			t.Errorf("FAIL: the manifest should have given the step's file and exit code, got %v", step)
+		}
+	}
+
+	if code, _ := downloadPipelineRunLogTest(r, "format=rar"); code != 400 {
+		t.Errorf("FAIL: an unknown format should have given a 400, got %d", code)
+	}
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	duckv1alpha1 "github.com/knative/pkg/apis/duck/v1alpha1"
+	"github.com/tektoncd/dashboard/pkg/broadcaster"
+	logging "github.com/tektoncd/dashboard/pkg/logging"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	informers "github.com/tektoncd/pipeline/pkg/client/informers/externalversions"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// LogLine - a line written by a step container of a running TaskRun, sent over the log websocket
+type LogLine struct {
+	NAMESPACE string `json:"namespace"`
+	// Empty if the TaskRun was not run by a PipelineRun
+	PIPELINERUN string `json:"pipelinerun,omitempty"`
+	TASKRUN     string `json:"taskrun"`
+	POD         string `json:"pod"`
+	CONTAINER   string `json:"container"`
+	// One of step, pod or init
+	CONTAINERTYPE string `json:"containerType"`
+	LINE          string `json:"line"`
+}
+
+// Unexported field within tekton
+const stepContainerPrefix = "build-step-"
+
+// Label Tekton adds to the TaskRuns of a PipelineRun
+const pipelineRunLabel = "tekton.dev/pipelineRun"
+
+// Longest log line handled at once, a longer line is split into lines of this size
+const maxLogLineSize = 1024 * 1024
+
+// Step containers that are being or have been followed, namespace/pod/container -> struct{}
+var followedContainers = new(sync.Map)
+
+// StartTaskRunController - registers the code that follows the logs of running TaskRuns
+func (r Resource) StartTaskRunController(stopCh <-chan struct{}) {
+	logging.Log.Debug("Into StartTaskRunController")
+
+	taskRunInformerFactory := informers.NewSharedInformerFactory(r.PipelineClient, time.Second*30)
+	taskRunInformer := taskRunInformerFactory.Tekton().V1alpha1().TaskRuns()
+	taskRunInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    r.taskRunCreated,
+		UpdateFunc: r.taskRunUpdated,
+		DeleteFunc: r.taskRunDeleted,
+	})
+	go taskRunInformerFactory.Start(stopCh)
+	logging.Log.Info("TaskRun Controller Started")
+}
+
+func (r Resource) taskRunCreated(obj interface{}) {
+	r.followTaskRunLogs(obj.(*v1alpha1.TaskRun))
+}
+
+// Steps start one after another, so keep checking for new containers to follow as the TaskRun changes
+func (r Resource) taskRunUpdated(oldObj, newObj interface{}) {
+	r.followTaskRunLogs(newObj.(*v1alpha1.TaskRun))
+}
+
+// Archived logs are kept, only the record that the TaskRun was archived and its followed containers are forgotten
+func (r Resource) taskRunDeleted(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if taskRun, ok := obj.(*v1alpha1.TaskRun); ok {
+		archivedTaskRuns.Delete(taskRun.Namespace + "/" + taskRun.Name)
+		if taskRun.Status.PodName != "" {
+			forgetFollowedContainers(taskRun.Namespace + "/" + taskRun.Status.PodName + "/")
+		}
+	}
+}
+
+// Forgets the followed containers of a pod, anything still being followed ends with its container
+func forgetFollowedContainers(podKey string) {
+	followedContainers.Range(func(key, value interface{}) bool {
+		if strings.HasPrefix(key.(string), podKey) {
+			followedContainers.Delete(key)
+		}
+		return true
+	})
+}
+
+/* Start following the log of every step container of a running TaskRun that isn't already being followed.
+ * Containers that have not started yet can't be followed, they are tried again when the TaskRun next changes.
+ * Nothing is followed while no one is subscribed to the log websocket.
+ * Once the TaskRun completes its logs are archived, if there's a log archive.
+ */
+func (r Resource) followTaskRunLogs(taskRun *v1alpha1.TaskRun) {
+	podName := taskRun.Status.PodName
+	if podName == "" {
+		return
+	}
+	podKey := taskRun.Namespace + "/" + podName + "/"
+
+	condition := taskRun.Status.GetCondition(duckv1alpha1.ConditionSucceeded)
+	if condition != nil && condition.Status != v1.ConditionUnknown {
+		forgetFollowedContainers(podKey)
+		r.archiveTaskRunLogs(taskRun)
+		return
+	}
+	if logBroadcaster.PoolSize() == 0 {
+		return
+	}
+
+	pod, err := r.K8sClient.CoreV1().Pods(taskRun.Namespace).Get(podName, metav1.GetOptions{})
+	if err != nil {
+		logging.Log.Debugf("Could not get pod %s of TaskRun %s to follow: %s", podName, taskRun.Name, err)
+		return
+	}
+	var redactor *logRedactor
+	for _, container := range pod.Spec.Containers {
+		if !strings.HasPrefix(container.Name, stepContainerPrefix) {
+			continue
+		}
+		key := podKey + container.Name
+		if _, loaded := followedContainers.LoadOrStore(key, struct{}{}); loaded {
+			continue
+		}
+		if redactor == nil {
+			redactor = r.getLogRedactor(taskRun.Namespace, taskRun.Spec.ServiceAccount)
+		}
+		logLine := LogLine{
+			NAMESPACE:   taskRun.Namespace,
+			PIPELINERUN: taskRun.Labels[pipelineRunLabel],
+			TASKRUN:     taskRun.Name,
+			POD:         podName,
+			CONTAINER:   container.Name,
+			// Only step containers are followed
+			CONTAINERTYPE: stepContainerType,
+		}
+		go r.followContainerLog(key, logLine, taskRunSubject(taskRun), redactor)
+	}
+}
+
+// Publishes the container's log until it ends, which is when the container terminates
+func (r Resource) followContainerLog(key string, logLine LogLine, subject broadcaster.Subject, redactor *logRedactor) {
+	req := r.K8sClient.CoreV1().Pods(logLine.NAMESPACE).GetLogs(logLine.POD, &v1.PodLogOptions{Container: logLine.CONTAINER, Follow: true})
+	if req.URL().Path == "" {
+		followedContainers.Delete(key)
+		return
+	}
+	podLogs, err := req.Stream()
+	if err != nil {
+		logging.Log.Debugf("Could not follow the log of %s: %s", key, err)
+		followedContainers.Delete(key)
+		return
+	}
+	defer podLogs.Close()
+
+	// Remembered until the TaskRun completes so the log isn't followed again from the start
+	if err := publishLogLines(podLogs, logLine, subject, redactor); err != nil {
+		logging.Log.Errorf("Error following the log of %s: %s", key, err)
+	}
+}
+
+// Sends each line read as a Log message with credentials redacted, logLine gives the fields every message has
+func publishLogLines(reader io.Reader, logLine LogLine, subject broadcaster.Subject, redactor *logRedactor) error {
+	return scanLogLines(reader, func(line string) {
+		logLine.LINE = redactor.redact(line)
+		logChannel <- broadcaster.SocketData{
+			MessageType: broadcaster.Log,
+			Payload:     logLine,
+			Subject:     subject,
+		}
+	})
+}
+
+// Log messages are about the TaskRun, its labels include the PipelineRun it was run by
+func taskRunSubject(taskRun *v1alpha1.TaskRun) broadcaster.Subject {
+	return broadcaster.Subject{
+		Kind:      taskRunKind,
+		Namespace: taskRun.Namespace,
+		Name:      taskRun.Name,
+		Labels:    taskRun.Labels,
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	duckv1alpha1 "github.com/knative/pkg/apis/duck/v1alpha1"
+	"github.com/tektoncd/dashboard/pkg/broadcaster"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+/* Log following test: each line is published to log websocket subscribers tagged with where it came from */
+
+func TestPublishLogLines(t *testing.T) {
+	t.Log("Testing log lines are published with credentials redacted")
+
+	subscriber, err := logBroadcaster.Subscribe()
+	if err != nil {
+		t.Fatalf("Error subscribing to the log broadcaster: %s", err)
+	}
+	defer logBroadcaster.Unsubscribe(subscriber)
+
+	otherNamespace, err := logBroadcaster.SubscribeWithFilter(broadcaster.Filter{Namespace: "ns2"})
+	if err != nil {
+		t.Fatalf("Error subscribing to the log broadcaster: %s", err)
+	}
+	defer logBroadcaster.Unsubscribe(otherNamespace)
+
+	logLine := LogLine{NAMESPACE: "ns1", PIPELINERUN: "pipelinerun1", TASKRUN: "taskrun1", POD: "pod1", CONTAINER: "build-step-one"}
+	subject := broadcaster.Subject{Kind: taskRunKind, Namespace: "ns1", Name: "taskrun1"}
+	redactor := newLogRedactor([]string{"s3cr3t-token"})
+	go publishLogLines(strings.NewReader("first line\nsecond line s3cr3t-token\n"), logLine, subject, redactor)
+
+	for _, expected := range []string{"first line", "second line ********"} {
+		select {
+		case socketData := <-subscriber.SubChan():
+			received, ok := socketData.Payload.(LogLine)
+			if socketData.MessageType != broadcaster.Log || !ok {
+				t.Fatalf("FAIL: expected a Log message, got %v", socketData)
+			}
+			expectedLine := logLine
+			expectedLine.LINE = expected
+			if received != expectedLine {
+				t.Errorf("FAIL: expected %v, got %v", expectedLine, received)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("FAIL: timed out waiting for %q", expected)
+		}
+	}
+	select {
+	case socketData := <-otherNamespace.SubChan():
+		t.Errorf("FAIL: a subscriber to another namespace should not have received %v", socketData)
+	default:
+	}
+}
+
+/* Log following test: containers are forgotten once their TaskRun completes or is deleted */
+
+func TestFollowTaskRunLogsCompleted(t *testing.T) {
+	t.Log("Testing followed containers are forgotten when the TaskRun completes or is deleted")
+
+	r := dummyResource()
+
+	followedContainers.Store("ns1/pod1/build-step-one", struct{}{})
+	followedContainers.Store("ns1/pod2/build-step-one", struct{}{})
+
+	taskRun := v1alpha1.TaskRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "taskrun1", Namespace: "ns1"},
+		Status:     v1alpha1.TaskRunStatus{PodName: "pod1"},
+	}
+	taskRun.Status.SetCondition(&duckv1alpha1.Condition{Type: duckv1alpha1.ConditionSucceeded, Status: corev1.ConditionTrue})
+	r.followTaskRunLogs(&taskRun)
+
+	if _, ok := followedContainers.Load("ns1/pod1/build-step-one"); ok {
+		t.Errorf("FAIL: the container of the completed TaskRun should have been forgotten")
+	}
+	if _, ok := followedContainers.Load("ns1/pod2/build-step-one"); !ok {
+		t.Errorf("FAIL: the container of another TaskRun should still be remembered")
+	}
+
+	// Deleted while still running
+	r.taskRunDeleted(&v1alpha1.TaskRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "taskrun2", Namespace: "ns1"},
+		Status:     v1alpha1.TaskRunStatus{PodName: "pod2"},
+	})
+	if _, ok := followedContainers.Load("ns1/pod2/build-step-one"); ok {
+		t.Errorf("FAIL: the container of the deleted TaskRun should have been forgotten")
+	}
+}
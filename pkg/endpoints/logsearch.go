@@ -0,0 +1,212 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"io"
+	"net/http"
+	"regexp"
+
+	restful "github.com/emicklei/go-restful"
+	logging "github.com/tektoncd/dashboard/pkg/logging"
+	"github.com/tektoncd/dashboard/pkg/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LogSearchResult - the lines of a PipelineRun's logs matching a search
+type LogSearchResult struct {
+	QUERY   string     `json:"query"`
+	MATCHES []LogMatch `json:"matches"`
+	// True if there were more matches than the limit, only the first are given
+	TRUNCATED bool `json:"truncated"`
+}
+
+// LogMatch - a log line matching a search, with the lines around it
+type LogMatch struct {
+	TASKRUN   string `json:"taskrun"`
+	POD       string `json:"pod"`
+	CONTAINER string `json:"container"`
+	// One of step, pod or init
+	CONTAINERTYPE string `json:"containerType"`
+	// Starting at 1, within the container's log
+	LINENUMBER int      `json:"lineNumber"`
+	LINE       string   `json:"line"`
+	BEFORE     []string `json:"before"`
+	AFTER      []string `json:"after"`
+}
+
+// Limits on the search query parameters
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+	maxSearchContext   = 50
+)
+
+// A search across container logs, stopping once a match past the limit is found
+type logSearch struct {
+	match   func(line string) bool
+	context int
+	limit   int
+	result  LogSearchResult
+}
+
+/* Search the logs of a PipelineRun's TaskRuns, giving each matching line with the lines around it.
+ * Query parameters:
+ *  - q (required, what to search for)
+ *  - regex (q is a regular expression rather than text to find in a line, default false)
+ *  - ignoreCase (default false)
+ *  - context (how many lines before and after each match to give, default 0)
+ *  - limit (the most matches to give, default 100)
+ *  - the log query parameters, other than follow, to choose which lines are searched
+ */
+func (r Resource) searchPipelineRunLog(request *restful.Request, response *restful.Response) {
+	namespace := request.PathParameter("namespace")
+	name := request.PathParameter("name")
+	logging.Log.Debugf("In searchPipelineRunLog, name: %s, namespace: %s", name, namespace)
+
+	search, ok := getLogSearch(request, response)
+	if !ok {
+		return
+	}
+	options, ok := getLogOptions(request, response)
+	if !ok {
+		return
+	}
+	if options.Follow {
+		utils.RespondErrorMessage(response, "Error: follow is not supported when searching", http.StatusBadRequest)
+		return
+	}
+
+	pipelinerun, err := r.PipelineClient.TektonV1alpha1().PipelineRuns(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		utils.RespondError(response, err, http.StatusNotFound)
+		return
+	}
+
+	// Once the limit is hit there's no need to read any more logs
+	r.forEachTaskRunLog(namespace, pipelinerun, options, func(key, podname string, sources []logSource, open logOpener) bool {
+		for _, source := range sources {
+			if search.result.TRUNCATED {
+				return false
+			}
+			podLogs, ok := open(source.container)
+			if !ok {
+				continue
+			}
+			match := LogMatch{TASKRUN: key, POD: podname, CONTAINER: source.container, CONTAINERTYPE: source.containerType}
+			if err := search.searchLog(podLogs, match); err != nil {
+				logging.Log.Errorf("Error searching the log of %s in pod %s: %s", source.container, podname, err)
+			}
+			podLogs.Close()
+		}
+		return !search.result.TRUNCATED
+	})
+	response.WriteEntity(search.result)
+}
+
+// Read the search query parameters, sending a 400 if they are invalid
+func getLogSearch(request *restful.Request, response *restful.Response) (*logSearch, bool) {
+	badRequest := func(message string) (*logSearch, bool) {
+		utils.RespondErrorMessage(response, "Error: "+message, http.StatusBadRequest)
+		return nil, false
+	}
+
+	query := request.QueryParameter("q")
+	if query == "" {
+		return badRequest("q must be supplied")
+	}
+	isRegex, err := getBoolParameter(request, "regex")
+	if err != nil {
+		return badRequest("regex must be true or false")
+	}
+	ignoreCase, err := getBoolParameter(request, "ignoreCase")
+	if err != nil {
+		return badRequest("ignoreCase must be true or false")
+	}
+	context, err := getInt64Parameter(request, "context", 0)
+	if err != nil || (context != nil && *context > maxSearchContext) {
+		return badRequest("context must be a number of lines, from 0 to 50")
+	}
+	limit, err := getInt64Parameter(request, "limit", 1)
+	if err != nil || (limit != nil && *limit > maxSearchLimit) {
+		return badRequest("limit must be a number of matches, from 1 to 1000")
+	}
+
+	search := &logSearch{limit: defaultSearchLimit, result: LogSearchResult{QUERY: query, MATCHES: []LogMatch{}}}
+	if context != nil {
+		search.context = int(*context)
+	}
+	if limit != nil {
+		search.limit = int(*limit)
+	}
+	if !isRegex {
+		query = regexp.QuoteMeta(query)
+	}
+	if ignoreCase {
+		query = "(?i)" + query
+	}
+	expression, err := regexp.Compile(query)
+	if err != nil {
+		return badRequest("q is not a valid regular expression: " + err.Error())
+	}
+	search.match = expression.MatchString
+	return search, true
+}
+
+// Adds the matching lines of a container's log to the result, match gives the fields every match has
+func (s *logSearch) searchLog(reader io.Reader, match LogMatch) error {
+	// The last lines read, for the context before a match
+	var before []string
+	// Matches still waiting for lines after them
+	var waiting []int
+	lineNumber := 0
+
+	return scanLogLinesUntil(reader, func(line string) bool {
+		lineNumber++
+		stillWaiting := waiting[:0]
+		for _, index := range waiting {
+			s.result.MATCHES[index].AFTER = append(s.result.MATCHES[index].AFTER, line)
+			if len(s.result.MATCHES[index].AFTER) < s.context {
+				stillWaiting = append(stillWaiting, index)
+			}
+		}
+		waiting = stillWaiting
+
+		if s.match(line) {
+			if len(s.result.MATCHES) == s.limit {
+				s.result.TRUNCATED = true
+			} else {
+				match.LINENUMBER = lineNumber
+				match.LINE = line
+				match.BEFORE = append([]string{}, before...)
+				match.AFTER = []string{}
+				s.result.MATCHES = append(s.result.MATCHES, match)
+				if s.context > 0 {
+					waiting = append(waiting, len(s.result.MATCHES)-1)
+				}
+			}
+		}
+		// Past the limit, only read on for the lines after the matches already found
+		if s.result.TRUNCATED && len(waiting) == 0 {
+			return false
+		}
+
+		if s.context > 0 {
+			before = append(before, line)
+			if len(before) > s.context {
+				before = before[1:]
+			}
+		}
+		return true
+	})
+}
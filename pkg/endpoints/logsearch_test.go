@@ -0,0 +1,121 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// Util to search a PipelineRun whose TaskRun logs are in the log archive
+func searchPipelineRunLogTest(r *Resource, query string) (int, LogSearchResult) {
+	httpWriter := httptest.NewRecorder()
+	httpReq := dummyHttpRequest("GET", "http://wwww.dummy.com:8383/v1/namespaces/ns1/pipelinerunlog/pipelinerun1/search?"+query, nil)
+	req := dummyRestfulRequest(httpReq, "ns1", "pipelinerun1")
+	resp := dummyRestfulResponse(httpWriter)
+	r.searchPipelineRunLog(req, resp)
+
+	result := LogSearchResult{}
+	json.NewDecoder(httpWriter.Body).Decode(&result)
+	return resp.StatusCode(), result
+}
+
+/* Log search test: matching lines are found with their context, by text or regular expression */
+
+func TestSearchPipelineRunLog(t *testing.T) {
+	t.Log("Testing the logs of a PipelineRun are searched")
+
+	r := dummyResource()
+	k8sClient := dummyK8sClientset()
+	r.K8sClient = k8sClient
+	logArchive := dummyArchivedTaskRun(r)
+	logArchive[archivedContainerKey("ns1", "taskrun1", "build-step-one")] = strings.Join([]string{
+		"2019-04-01T10:00:00Z compiling",
+		"2019-04-01T10:00:01Z main.go:10: error: undefined x",
+		"2019-04-01T10:00:02Z main.go:12: Error: undefined y",
+		"2019-04-01T10:00:03Z build failed",
+	}, "\n")
+	pipelineRun := v1alpha1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "pipelinerun1", Namespace: "ns1"},
+		Status: v1alpha1.PipelineRunStatus{
+			TaskRuns: map[string]*v1alpha1.PipelineRunTaskRunStatus{
+				"taskrun1": {Status: &v1alpha1.TaskRunStatus{PodName: "pod1"}},
+				"taskrun2": {Status: &v1alpha1.TaskRunStatus{PodName: "pod2"}},
+			},
+		},
+	}
+	r.PipelineClient.TektonV1alpha1().PipelineRuns("ns1").Create(&pipelineRun)
+
+	code, result := searchPipelineRunLogTest(r, "q=error:&context=1")
+	if code != 200 {
+		t.Fatalf("FAIL: the search should have succeeded, got %d", code)
+	}
+	expected := []LogMatch{{
+		TASKRUN:       "taskrun1",
+		POD:           "pod1",
+		CONTAINER:     "build-step-one",
+		CONTAINERTYPE: stepContainerType,
+		LINENUMBER:    2,
+		LINE:          "main.go:10: error: undefined x",
+		BEFORE:        []string{"compiling"},
+		AFTER:         []string{"main.go:12: Error: undefined y"},
+	}}
+	if !reflect.DeepEqual(result.MATCHES, expected) || result.TRUNCATED {
+		t.Errorf("FAIL: expected %v, got %v", expected, result)
+	}
+
+	k8sClient.ClearActions()
+	_, result = searchPipelineRunLogTest(r, "q=error:&ignoreCase=true&limit=1")
+	if len(result.MATCHES) != 1 || !result.TRUNCATED {
+		t.Errorf("FAIL: the matches past the limit should have been left out, got %v", result)
+	}
+	for _, action := range k8sClient.Actions() {
+		if get, ok := action.(k8stesting.GetAction); ok && get.GetName() == "pod2" {
+			t.Errorf("FAIL: the logs of taskrun2 should not have been read once the limit was hit")
+		}
+	}
+
+	_, result = searchPipelineRunLogTest(r, "regex=true&q="+url.QueryEscape(`^main\.go:\d+:`))
+	if len(result.MATCHES) != 2 || result.MATCHES[1].LINENUMBER != 3 {
+		t.Errorf("FAIL: both lines should have matched the regular expression, got %v", result)
+	}
+
+	_, result = searchPipelineRunLogTest(r, "q=setup")
+	if len(result.MATCHES) != 1 || result.MATCHES[0].CONTAINERTYPE != initContainerType {
+		t.Errorf("FAIL: init containers should have been searched, got %v", result)
+	}
+}
+
+/* Log search test: invalid searches are rejected */
+
+func TestSearchPipelineRunLogInvalid(t *testing.T) {
+	t.Log("Testing invalid log searches")
+
+	r := dummyResource()
+	for _, query := range []string{"", "q=", "q=(&regex=true", "q=x&context=51", "q=x&limit=0", "q=x&follow=true"} {
+		if code, _ := searchPipelineRunLogTest(r, query); code != 400 {
+			t.Errorf("FAIL: %q should have given a 400, got %d", query, code)
+		}
+	}
+	if code, _ := searchPipelineRunLogTest(r, "q=x"); code != 404 {
+		t.Errorf("FAIL: searching a missing PipelineRun should have given a 404, got %d", code)
+	}
+}
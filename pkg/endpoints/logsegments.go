@@ -0,0 +1,261 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/tektoncd/dashboard/pkg/utils"
+)
+
+// Log format giving each line as segments, as newline delimited SegmentedLogLines
+const segmentsLogFormat = "segments"
+
+// The types of LogSegment
+const (
+	textSegment       = "text"
+	groupStartSegment = "groupStart"
+	groupEndSegment   = "groupEnd"
+	annotationSegment = "annotation"
+)
+
+// SegmentedLogLine - a LogLine with the line parsed into segments. The line is given without ANSI escapes
+type SegmentedLogLine struct {
+	LogLine
+	SEGMENTS []LogSegment `json:"segments"`
+}
+
+// LogSegment - part of a log line: styled text, the start or end of a group of lines, or an annotation
+type LogSegment struct {
+	// One of text, groupStart, groupEnd or annotation
+	TYPE string `json:"type"`
+	// The text, the group's title or the annotation's message
+	TEXT  string    `json:"text,omitempty"`
+	STYLE *LogStyle `json:"style,omitempty"`
+	// Annotations only: error, warning, notice or debug, and where in the source it is about, if given
+	LEVEL  string `json:"level,omitempty"`
+	FILE   string `json:"file,omitempty"`
+	LINE   int    `json:"line,omitempty"`
+	COLUMN int    `json:"column,omitempty"`
+}
+
+// LogStyle - how text is styled by ANSI escapes.
+// Colours are a name such as red or bright-red, a 256 colour palette index or #rrggbb
+type LogStyle struct {
+	BOLD       bool   `json:"bold,omitempty"`
+	FAINT      bool   `json:"faint,omitempty"`
+	ITALIC     bool   `json:"italic,omitempty"`
+	UNDERLINE  bool   `json:"underline,omitempty"`
+	FOREGROUND string `json:"foreground,omitempty"`
+	BACKGROUND string `json:"background,omitempty"`
+}
+
+var ansiColours = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// Annotation commands, as used by GitHub Actions e.g. ::error file=main.go,line=10::undefined x
+var annotationLevels = map[string]bool{"error": true, "warning": true, "notice": true, "debug": true}
+
+/* Read the format query parameter of the log endpoints, sending a 400 if it is invalid.
+ * Returns true if the log should be given as segments
+ */
+func getLogFormat(request *restful.Request, response *restful.Response) (bool, bool) {
+	switch request.QueryParameter("format") {
+	case "":
+		return false, true
+	case segmentsLogFormat:
+		return true, true
+	}
+	utils.RespondErrorMessage(response, "Error: format must be "+segmentsLogFormat, http.StatusBadRequest)
+	return false, false
+}
+
+// Parses the lines of a log, a style set by ANSI escapes carries on to the following lines as it would in a terminal
+type logSegmenter struct {
+	style LogStyle
+}
+
+// Returns the line without ANSI escapes and its segments
+func (s *logSegmenter) segment(line string) (string, []LogSegment) {
+	text, segments := s.parseANSI(line)
+	if annotation, ok := parseLogMarker(text); ok {
+		return text, []LogSegment{annotation}
+	}
+	return text, segments
+}
+
+// Splits the line into text with the same style, following the SGR escapes and leaving out any other escapes
+func (s *logSegmenter) parseANSI(line string) (string, []LogSegment) {
+	var text strings.Builder
+	var current strings.Builder
+	segments := []LogSegment{}
+	addSegment := func() {
+		if current.Len() == 0 {
+			return
+		}
+		segment := LogSegment{TYPE: textSegment, TEXT: current.String()}
+		if s.style != (LogStyle{}) {
+			style := s.style
+			segment.STYLE = &style
+		}
+		segments = append(segments, segment)
+		current.Reset()
+	}
+
+	for i := 0; i < len(line); i++ {
+		if line[i] != '\x1b' {
+			text.WriteByte(line[i])
+			current.WriteByte(line[i])
+			continue
+		}
+		if i+1 >= len(line) || line[i+1] != '[' {
+			// Not a control sequence, drop the escape character
+			continue
+		}
+		// A control sequence is parameter bytes then a final byte from @ to ~
+		end := i + 2
+		for end < len(line) && (line[end] < '@' || line[end] > '~') {
+			end++
+		}
+		if end == len(line) {
+			break
+		}
+		if line[end] == 'm' {
+			addSegment()
+			s.applySGR(line[i+2 : end])
+		}
+		i = end
+	}
+	addSegment()
+	return text.String(), segments
+}
+
+func (s *logSegmenter) applySGR(parameters string) {
+	var codes []int
+	for _, parameter := range strings.Split(parameters, ";") {
+		code, err := strconv.Atoi(parameter)
+		if err != nil {
+			// An empty parameter is 0, anything else unknown resets too rather than guessing
+			code = 0
+		}
+		codes = append(codes, code)
+	}
+
+	for i := 0; i < len(codes); i++ {
+		code := codes[i]
+		switch {
+		case code == 0:
+			s.style = LogStyle{}
+		case code == 1:
+			s.style.BOLD = true
+		case code == 2:
+			s.style.FAINT = true
+		case code == 3:
+			s.style.ITALIC = true
+		case code == 4:
+			s.style.UNDERLINE = true
+		case code == 22:
+			s.style.BOLD, s.style.FAINT = false, false
+		case code == 23:
+			s.style.ITALIC = false
+		case code == 24:
+			s.style.UNDERLINE = false
+		case code >= 30 && code <= 37:
+			s.style.FOREGROUND = ansiColours[code-30]
+		case code == 39:
+			s.style.FOREGROUND = ""
+		case code >= 40 && code <= 47:
+			s.style.BACKGROUND = ansiColours[code-40]
+		case code == 49:
+			s.style.BACKGROUND = ""
+		case code >= 90 && code <= 97:
+			s.style.FOREGROUND = "bright-" + ansiColours[code-90]
+		case code >= 100 && code <= 107:
+			s.style.BACKGROUND = "bright-" + ansiColours[code-100]
+		case code == 38 || code == 48:
+			colour, used := extendedColour(codes[i+1:])
+			if code == 38 {
+				s.style.FOREGROUND = colour
+			} else {
+				s.style.BACKGROUND = colour
+			}
+			i += used
+		}
+	}
+}
+
+// Reads a 5;n palette or 2;r;g;b colour, returning how many codes it used
+func extendedColour(codes []int) (string, int) {
+	if len(codes) >= 2 && codes[0] == 5 {
+		return strconv.Itoa(codes[1]), 2
+	}
+	if len(codes) >= 4 && codes[0] == 2 {
+		return fmt.Sprintf("#%02x%02x%02x", codes[1]&0xff, codes[2]&0xff, codes[3]&0xff), 4
+	}
+	return "", len(codes)
+}
+
+/* Parse a ::command properties::message marker, which must be the whole line.
+ * Supported are group, endgroup and the annotations error, warning, notice and debug with file, line and col properties
+ */
+func parseLogMarker(line string) (LogSegment, bool) {
+	if !strings.HasPrefix(line, "::") {
+		return LogSegment{}, false
+	}
+	end := strings.Index(line[2:], "::")
+	if end < 0 {
+		return LogSegment{}, false
+	}
+	command := line[2 : 2+end]
+	message := unescapeLogMarker(line[4+end:])
+	properties := ""
+	if space := strings.IndexByte(command, ' '); space >= 0 {
+		command, properties = command[:space], command[space+1:]
+	}
+
+	switch {
+	case command == "group":
+		return LogSegment{TYPE: groupStartSegment, TEXT: message}, true
+	case command == "endgroup":
+		return LogSegment{TYPE: groupEndSegment}, true
+	case annotationLevels[command]:
+		segment := LogSegment{TYPE: annotationSegment, LEVEL: command, TEXT: message}
+		for _, property := range strings.Split(properties, ",") {
+			keyValue := strings.SplitN(property, "=", 2)
+			if len(keyValue) != 2 {
+				continue
+			}
+			value := unescapeLogMarker(strings.TrimSpace(keyValue[1]))
+			switch strings.TrimSpace(keyValue[0]) {
+			case "file":
+				segment.FILE = value
+			case "line":
+				segment.LINE, _ = strconv.Atoi(value)
+			case "col":
+				segment.COLUMN, _ = strconv.Atoi(value)
+			}
+		}
+		return segment, true
+	}
+	return LogSegment{}, false
+}
+
+// Markers escape characters that would end them, or the line, as %XX
+var logMarkerUnescaper = strings.NewReplacer("%25", "%", "%0D", "\r", "%0A", "\n", "%3A", ":", "%2C", ",")
+
+func unescapeLogMarker(value string) string {
+	return logMarkerUnescaper.Replace(value)
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+/* Log segments test: ANSI escapes become styled text, carrying on to the following lines */
+
+func TestSegmentANSI(t *testing.T) {
+	t.Log("Testing ANSI escapes are parsed into styled segments")
+
+	segmenter := &logSegmenter{}
+	text, segments := segmenter.segment("plain \x1b[1;31mbold red\x1b[0m \x1b[38;5;208morange\x1b[K")
+	if text != "plain bold red orange" {
+		t.Errorf("FAIL: the escapes should have been removed from the text, got %q", text)
+	}
+	expected := []LogSegment{
+		{TYPE: textSegment, TEXT: "plain "},
+		{TYPE: textSegment, TEXT: "bold red", STYLE: &LogStyle{BOLD: true, FOREGROUND: "red"}},
+		{TYPE: textSegment, TEXT: " "},
+		{TYPE: textSegment, TEXT: "orange", STYLE: &LogStyle{FOREGROUND: "208"}},
+	}
+	if !reflect.DeepEqual(segments, expected) {
+		t.Errorf("FAIL: expected %v, got %v", expected, segments)
+	}
+
+	_, segments = segmenter.segment("still orange \x1b[48;2;0;128;255;92mbright green on blue\x1b[39;49m")
+	expected = []LogSegment{
+		{TYPE: textSegment, TEXT: "still orange ", STYLE: &LogStyle{FOREGROUND: "208"}},
+		{TYPE: textSegment, TEXT: "bright green on blue", STYLE: &LogStyle{FOREGROUND: "bright-green", BACKGROUND: "#0080ff"}},
+	}
+	if !reflect.DeepEqual(segments, expected) {
+		t.Errorf("FAIL: expected %v, got %v", expected, segments)
+	}
+
+	if _, segments = segmenter.segment(""); len(segments) != 0 {
+		t.Errorf("FAIL: an empty line should have no segments, got %v", segments)
+	}
+}
+
+/* Log segments test: group and annotation markers */
+
+func TestSegmentMarkers(t *testing.T) {
+	t.Log("Testing group and annotation markers are parsed")
+
+	tests := map[string]LogSegment{
+		"::group::Compile":             {TYPE: groupStartSegment, TEXT: "Compile"},
+		"::endgroup::":                 {TYPE: groupEndSegment},
+		"::warning::deprecated%0Aflag": {TYPE: annotationSegment, LEVEL: "warning", TEXT: "deprecated\nflag"},
+		"\x1b[31m::error file=cmd/main.go,line=10,col=4::undefined: x\x1b[0m": {
+			TYPE: annotationSegment, LEVEL: "error", TEXT: "undefined: x", FILE: "cmd/main.go", LINE: 10, COLUMN: 4,
+		},
+	}
+	for line, expected := range tests {
+		_, segments := (&logSegmenter{}).segment(line)
+		if !reflect.DeepEqual(segments, []LogSegment{expected}) {
+			t.Errorf("FAIL: %q expected %v, got %v", line, expected, segments)
+		}
+	}
+
+	for _, line := range []string{"::unknown::x", "::error", "text ::error::x"} {
+		if _, segments := (&logSegmenter{}).segment(line); len(segments) != 1 || segments[0].TYPE != textSegment {
+			t.Errorf("FAIL: %q should have been text, got %v", line, segments)
+		}
+	}
+}
+
+/* Log segments test: format=segments gives newline delimited SegmentedLogLines */
+
+func TestLogFormatSegments(t *testing.T) {
+	t.Log("Testing the segments log format")
+
+	buf := new(bytes.Buffer)
+	logLine := LogLine{NAMESPACE: "ns1", TASKRUN: "taskrun1", POD: "pod1"}
+	logs := map[string]string{"build-step-one": "::group::Build\n\x1b[32mok\x1b[0m\n::endgroup::\n"}
+	writeLogLines(buf, logLine, dummyLogSources, dummyLogOpener(logs), true)
+
+	var types []string
+	decoder := json.NewDecoder(buf)
+	for decoder.More() {
+		line := SegmentedLogLine{}
+		if err := decoder.Decode(&line); err != nil {
+			t.Fatalf("FAIL: could not decode a log line: %s", err)
+		}
+		if line.TASKRUN != "taskrun1" || line.CONTAINER != "build-step-one" || len(line.SEGMENTS) != 1 {
+			t.Fatalf("FAIL: unexpected log line %v", line)
+		}
+		types = append(types, line.SEGMENTS[0].TYPE+": "+line.LINE)
+	}
+	expected := []string{"groupStart: ::group::Build", "text: ok", "groupEnd: ::endgroup::"}
+	if !reflect.DeepEqual(types, expected) {
+		t.Errorf("FAIL: expected %v, got %v", expected, types)
+	}
+
+	httpWriter := httptest.NewRecorder()
+	httpReq := dummyHttpRequest("GET", "http://wwww.dummy.com:8383/v1/namespaces/ns1/taskrunlog/taskrun1?format=html", nil)
+	resp := dummyRestfulResponse(httpWriter)
+	dummyResource().getTaskRunLog(dummyRestfulRequest(httpReq, "ns1", "taskrun1"), resp)
+	if resp.StatusCode() != 400 {
+		t.Errorf("FAIL: an unknown format should have given a 400, got %d", resp.StatusCode())
+	}
+
+	httpWriter = httptest.NewRecorder()
+	httpReq = dummyHttpRequest("GET", "http://wwww.dummy.com:8383/v1/namespaces/ns1/log/pod1?format=html", nil)
+	resp = dummyRestfulResponse(httpWriter)
+	dummyResource().getPodLog(dummyRestfulRequest(httpReq, "ns1", "pod1"), resp)
+	if resp.StatusCode() != 400 {
+		t.Errorf("FAIL: an unknown format should have given a 400 for a pod log, got %d", resp.StatusCode())
+	}
+}
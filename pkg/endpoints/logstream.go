@@ -0,0 +1,429 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	logging "github.com/tektoncd/dashboard/pkg/logging"
+	"github.com/tektoncd/dashboard/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Newline delimited JSON, a LogLine per line
+const mimeNDJSON = "application/x-ndjson"
+
+// The kinds of container in a TaskRun's pod, as grouped by TaskRunLog
+const (
+	stepContainerType = "step"
+	podContainerType  = "pod"
+	initContainerType = "init"
+)
+
+// A container whose log is part of a TaskRun's log
+type logSource struct {
+	containerType string
+	container     string
+	// Empty if the container's image or state isn't known
+	image string
+	state v1.ContainerState
+}
+
+// Opens the log of a container, returning false if it can't be read
+type logOpener func(container string) (io.ReadCloser, bool)
+
+// Writes logs to the response as they are read, flushing after every write when following so nothing is held back
+type logStreamWriter struct {
+	response *restful.Response
+	flush    bool
+}
+
+func newLogStreamWriter(response *restful.Response, follow bool) *logStreamWriter {
+	return &logStreamWriter{response: response, flush: follow}
+}
+
+// No content length is set, so anything larger than the server's buffer is sent chunked
+func (w *logStreamWriter) start(contentType string) {
+	w.response.Header().Set(restful.HEADER_ContentType, contentType)
+	w.response.WriteHeader(http.StatusOK)
+}
+
+func (w *logStreamWriter) Write(data []byte) (int, error) {
+	written, err := w.response.Write(data)
+	if w.flush {
+		if flusher, ok := w.response.ResponseWriter.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+	return written, err
+}
+
+/* Read the log query parameters, sending a 400 if they are invalid.
+ * Query parameters:
+ *  - follow (keep streaming the log until the containers terminate)
+ *  - tailLines (only the last lines of each container's log)
+ *  - sinceSeconds (only lines written in the last seconds, not with sinceTime)
+ *  - sinceTime (RFC3339, only lines written after this time, not with sinceSeconds)
+ *  - timestamps (prefix each line with the time it was written)
+ *  - previous (the log of the previous instance of each container, if it restarted)
+ *  - limitBytes (stop after this many bytes of each container's log)
+ */
+func getLogOptions(request *restful.Request, response *restful.Response) (v1.PodLogOptions, bool) {
+	options := v1.PodLogOptions{}
+	var err error
+	badRequest := func(message string) (v1.PodLogOptions, bool) {
+		utils.RespondErrorMessage(response, "Error: "+message, http.StatusBadRequest)
+		return options, false
+	}
+
+	if options.Follow, err = getBoolParameter(request, "follow"); err != nil {
+		return badRequest("follow must be true or false")
+	}
+	if options.Timestamps, err = getBoolParameter(request, "timestamps"); err != nil {
+		return badRequest("timestamps must be true or false")
+	}
+	if options.Previous, err = getBoolParameter(request, "previous"); err != nil {
+		return badRequest("previous must be true or false")
+	}
+	if options.TailLines, err = getInt64Parameter(request, "tailLines", 0); err != nil {
+		return badRequest("tailLines must be a number of lines, 0 or more")
+	}
+	if options.SinceSeconds, err = getInt64Parameter(request, "sinceSeconds", 1); err != nil {
+		return badRequest("sinceSeconds must be a number of seconds, 1 or more")
+	}
+	if options.LimitBytes, err = getInt64Parameter(request, "limitBytes", 1); err != nil {
+		return badRequest("limitBytes must be a number of bytes, 1 or more")
+	}
+	if sinceTime := request.QueryParameter("sinceTime"); sinceTime != "" {
+		since, err := time.Parse(time.RFC3339, sinceTime)
+		if err != nil {
+			return badRequest("sinceTime must be an RFC3339 timestamp e.g. 2019-04-01T00:00:00Z")
+		}
+		options.SinceTime = &metav1.Time{Time: since}
+	}
+	if options.SinceSeconds != nil && options.SinceTime != nil {
+		return badRequest("only one of sinceSeconds and sinceTime may be supplied")
+	}
+	return options, true
+}
+
+// False if the parameter isn't supplied
+func getBoolParameter(request *restful.Request, name string) (bool, error) {
+	value := request.QueryParameter(name)
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// Nil if the parameter isn't supplied
+func getInt64Parameter(request *restful.Request, name string, min int64) (*int64, error) {
+	value := request.QueryParameter(name)
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	if parsed < min {
+		return nil, fmt.Errorf("%s must be at least %d", name, min)
+	}
+	return &parsed, nil
+}
+
+func acceptsNDJSON(request *restful.Request) bool {
+	return strings.Contains(request.HeaderParameter("Accept"), mimeNDJSON)
+}
+
+// Lists the containers of a TaskRun's pod in the order they run, init containers first
+func getLogSources(pod *v1.Pod, isStep func(container string) bool) []logSource {
+	states := make(map[string]v1.ContainerState)
+	for _, status := range append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...) {
+		states[status.Name] = status.State
+	}
+	var sources []logSource
+	for _, container := range pod.Spec.InitContainers {
+		sources = append(sources, logSource{containerType: initContainerType, container: container.Name, image: container.Image, state: states[container.Name]})
+	}
+	for _, container := range pod.Spec.Containers {
+		containerType := podContainerType
+		if isStep(container.Name) {
+			containerType = stepContainerType
+		}
+		sources = append(sources, logSource{containerType: containerType, container: container.Name, image: container.Image, state: states[container.Name]})
+	}
+	return sources
+}
+
+// Opens container logs in a pod, a log can't be read when, for example, its container hasn't started
+func (r Resource) podLogOpener(namespace, podName string, options v1.PodLogOptions) logOpener {
+	return func(container string) (io.ReadCloser, bool) {
+		containerOptions := options
+		containerOptions.Container = container
+		req := r.K8sClient.CoreV1().Pods(namespace).GetLogs(podName, &containerOptions)
+		if req.URL().Path == "" {
+			return nil, false
+		}
+		podLogs, err := req.Stream()
+		if err != nil {
+			logging.Log.Debugf("Could not read the log of %s in pod %s: %s", container, podName, err)
+			return nil, false
+		}
+		return podLogs, true
+	}
+}
+
+// Calls handle with each line read, without the line ending. A line longer than maxLogLineSize is handled in parts of that size
+func scanLogLines(reader io.Reader, handle func(line string)) error {
+	return scanLogLinesUntil(reader, func(line string) bool {
+		handle(line)
+		return true
+	})
+}
+
+// As scanLogLines, stopping without an error when handle returns false
+func scanLogLinesUntil(reader io.Reader, handle func(line string) bool) error {
+	buffered := bufio.NewReaderSize(reader, 64*1024)
+	var line []byte
+	split := false
+	for {
+		data, isPrefix, err := buffered.ReadLine()
+		if err != nil {
+			if len(line) > 0 && !handle(string(line)) {
+				return nil
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		line = append(line, data...)
+		for len(line) >= maxLogLineSize {
+			if !handle(string(line[:maxLogLineSize])) {
+				return nil
+			}
+			line = line[maxLogLineSize:]
+			split = true
+		}
+		if !isPrefix {
+			// Nothing is left of a split line that was an exact multiple of the size
+			if (len(line) > 0 || !split) && !handle(string(line)) {
+				return nil
+			}
+			line, split = line[:0], false
+		}
+	}
+}
+
+// Encodes each line of a log as a LogLine, or a SegmentedLogLine with segments, logLine gives the fields every line has
+func encodeLogLines(encoder *json.Encoder, logLine LogLine, log io.Reader, segments bool) error {
+	segmenter := &logSegmenter{}
+	return scanLogLines(log, func(line string) {
+		if !segments {
+			logLine.LINE = line
+			encoder.Encode(logLine)
+			return
+		}
+		segmented := SegmentedLogLine{LogLine: logLine}
+		segmented.LINE, segmented.SEGMENTS = segmenter.segment(line)
+		encoder.Encode(segmented)
+	})
+}
+
+/* Writes each line of each container's log as a LogLine, logLine gives the fields every line has.
+ * With segments each line is written as a SegmentedLogLine instead
+ */
+func writeLogLines(writer io.Writer, logLine LogLine, sources []logSource, open logOpener, segments bool) {
+	encoder := json.NewEncoder(writer)
+	for _, source := range sources {
+		podLogs, ok := open(source.container)
+		if !ok {
+			continue
+		}
+		logLine.CONTAINER = source.container
+		logLine.CONTAINERTYPE = source.containerType
+		err := encodeLogLines(encoder, logLine, podLogs, segments)
+		if err != nil {
+			logging.Log.Errorf("Error reading the log of %s in pod %s: %s", source.container, logLine.POD, err)
+		}
+		podLogs.Close()
+	}
+}
+
+// Writes a TaskRun's log as newline delimited JSON if it is accepted or segments are wanted, otherwise as a TaskRunLog
+func writeTaskRunLogResponse(request *restful.Request, writer *logStreamWriter, logLine LogLine, sources []logSource, open logOpener, options v1.PodLogOptions) {
+	segments := request.QueryParameter("format") == segmentsLogFormat
+	if segments || acceptsNDJSON(request) {
+		writer.start(mimeNDJSON)
+		writeLogLines(writer, logLine, sources, open, segments)
+		return
+	}
+	writer.start(restful.MIME_JSON)
+	writeTaskRunLog(writer, logLine.POD, sources, open, options)
+}
+
+/* Write a TaskRunLog as JSON a line at a time, so the logs are never all held in memory.
+ * The JSON is the same as encoding a TaskRunLog: containers whose log can't be read and empty lines are left out.
+ * options are those the logs were opened with, to tell whether a log was truncated by limitBytes.
+ * Whether tailLines left anything out can't be told from the lines read, so it isn't reported
+ */
+func writeTaskRunLog(writer io.Writer, podName string, sources []logSource, open logOpener, options v1.PodLogOptions) {
+	write := func(value interface{}) {
+		data, _ := json.Marshal(value)
+		writer.Write(data)
+	}
+	writeString := func(data string) {
+		io.WriteString(writer, data)
+	}
+
+	writeString(`{"PodName":`)
+	write(podName)
+	groups := []struct {
+		field         string
+		containerType string
+	}{
+		{"StepContainers", stepContainerType},
+		{"PodContainers", podContainerType},
+		{"InitContainers", initContainerType},
+	}
+	for _, group := range groups {
+		writeString(`,"` + group.field + `":`)
+		containers := 0
+		for _, source := range sources {
+			if source.containerType != group.containerType {
+				continue
+			}
+			podLogs, ok := open(source.container)
+			if !ok {
+				continue
+			}
+			if containers == 0 {
+				writeString("[")
+			} else {
+				writeString(",")
+			}
+			containers++
+			writeString(`{"Name":`)
+			write(source.container)
+			writeString(`,"Logs":`)
+			var reader io.Reader = podLogs
+			// Redacted logs count what was read before redaction, which changes the size of the log
+			counter, counted := podLogs.(logByteCounter)
+			if !counted {
+				countingLogs := &countingReader{reader: podLogs}
+				reader, counter = countingLogs, countingLogs
+			}
+			lines := 0
+			err := scanLogLines(reader, func(line string) {
+				if line == "" {
+					return
+				}
+				if lines == 0 {
+					writeString("[")
+				} else {
+					writeString(",")
+				}
+				lines++
+				write(line)
+			})
+			if err != nil {
+				logging.Log.Errorf("Error reading the log of %s in pod %s: %s", source.container, podName, err)
+			}
+			podLogs.Close()
+			if lines == 0 {
+				writeString("null")
+			} else {
+				writeString("]")
+			}
+
+			// Encoded as the embedded LogContainerMetadata fields would be
+			metadata := getLogContainerMetadata(source)
+			metadata.Truncated = options.LimitBytes != nil && counter.bytesRead() >= *options.LimitBytes
+			data, _ := json.Marshal(metadata)
+			if len(data) > 2 {
+				writeString(",")
+				writer.Write(data[1 : len(data)-1])
+			}
+			writeString("}")
+		}
+		if containers == 0 {
+			writeString("null")
+		} else {
+			writeString("]")
+		}
+	}
+	writeString("}\n")
+}
+
+func getLogContainerMetadata(source logSource) LogContainerMetadata {
+	metadata := LogContainerMetadata{Image: source.image}
+	if source.containerType == stepContainerType {
+		metadata.StepName = strings.TrimPrefix(source.container, stepContainerPrefix)
+	}
+	state := source.state
+	switch {
+	case state.Terminated != nil:
+		metadata.State = "terminated"
+		exitCode := state.Terminated.ExitCode
+		metadata.ExitCode = &exitCode
+		metadata.Reason = state.Terminated.Reason
+		metadata.StartedAt = knownTime(state.Terminated.StartedAt)
+		metadata.FinishedAt = knownTime(state.Terminated.FinishedAt)
+	case state.Running != nil:
+		metadata.State = "running"
+		metadata.StartedAt = knownTime(state.Running.StartedAt)
+	case state.Waiting != nil:
+		metadata.State = "waiting"
+		metadata.Reason = state.Waiting.Reason
+	}
+	return metadata
+}
+
+// Nil for a zero time, so it is left out rather than encoded as null
+func knownTime(t metav1.Time) *metav1.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// Logs that know how many bytes were read from the container's log, limitBytes applies to those
+type logByteCounter interface {
+	bytesRead() int64
+}
+
+// Counts the bytes read
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (c *countingReader) Read(data []byte) (int, error) {
+	read, err := c.reader.Read(data)
+	c.count += int64(read)
+	return read, err
+}
+
+func (c *countingReader) bytesRead() int64 {
+	return c.count
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Util to open logs from strings rather than pods, containers without a log can't be opened
+func dummyLogOpener(logs map[string]string) logOpener {
+	return func(container string) (io.ReadCloser, bool) {
+		log, ok := logs[container]
+		if !ok {
+			return nil, false
+		}
+		return ioutil.NopCloser(strings.NewReader(log)), true
+	}
+}
+
+var dummyStartTime = metav1.Date(2019, 4, 1, 10, 0, 0, 0, time.UTC)
+var dummyFinishTime = metav1.Date(2019, 4, 1, 10, 1, 0, 0, time.UTC)
+
+var dummyLogSources = []logSource{
+	{containerType: initContainerType, container: "init1"},
+	{containerType: stepContainerType, container: "build-step-one", image: "busybox", state: v1.ContainerState{
+		Terminated: &v1.ContainerStateTerminated{Reason: "Completed", StartedAt: dummyStartTime, FinishedAt: dummyFinishTime},
+	}},
+	{containerType: podContainerType, container: "sidecar"},
+	{containerType: stepContainerType, container: "build-step-two", state: v1.ContainerState{
+		Waiting: &v1.ContainerStateWaiting{Reason: "PodInitializing"},
+	}},
+}
+
+var dummyLogs = map[string]string{
+	"init1":          "first\n\nsecond\n",
+	"build-step-one": "hello <world>",
+	"build-step-two": "",
+}
+
+/* Log streaming test: the streamed JSON should be exactly what encoding the whole TaskRunLog gives */
+
+func TestWriteTaskRunLog(t *testing.T) {
+	t.Log("Testing a TaskRunLog is streamed as JSON")
+
+	buf := new(bytes.Buffer)
+	writeTaskRunLog(buf, "pod1", dummyLogSources, dummyLogOpener(dummyLogs), v1.PodLogOptions{})
+
+	exitCode := int32(0)
+	expected := TaskRunLog{
+		PodName: "pod1",
+		StepContainers: []LogContainer{
+			{Name: "build-step-one", Logs: []string{"hello <world>"}, LogContainerMetadata: LogContainerMetadata{
+				StepName:   "one",
+				Image:      "busybox",
+				State:      "terminated",
+				ExitCode:   &exitCode,
+				Reason:     "Completed",
+				StartedAt:  &dummyStartTime,
+				FinishedAt: &dummyFinishTime,
+			}},
+			{Name: "build-step-two", LogContainerMetadata: LogContainerMetadata{StepName: "two", State: "waiting", Reason: "PodInitializing"}},
+		},
+		InitContainers: []LogContainer{
+			{Name: "init1", Logs: []string{"first", "second"}},
+		},
+	}
+	expectedJSON, _ := json.Marshal(expected)
+	if buf.String() != string(expectedJSON)+"\n" {
+		t.Errorf("FAIL: expected %s, got %s", expectedJSON, buf.String())
+	}
+}
+
+/* Log streaming test: logs cut short by the log options are marked as truncated */
+
+func TestWriteTaskRunLogTruncated(t *testing.T) {
+	t.Log("Testing truncated logs are marked in a TaskRunLog")
+
+	tailLines, limitBytes := int64(2), int64(13)
+	tests := []struct {
+		options   v1.PodLogOptions
+		truncated map[string]bool
+	}{
+		{v1.PodLogOptions{}, map[string]bool{}},
+		{v1.PodLogOptions{TailLines: &tailLines}, map[string]bool{}},
+		{v1.PodLogOptions{LimitBytes: &limitBytes}, map[string]bool{"init1": true, "build-step-one": true}},
+	}
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		writeTaskRunLog(buf, "pod1", dummyLogSources, dummyLogOpener(dummyLogs), test.options)
+		taskRunLog := TaskRunLog{}
+		if err := json.Unmarshal(buf.Bytes(), &taskRunLog); err != nil {
+			t.Fatalf("FAIL: the TaskRunLog should have been valid JSON: %s", err)
+		}
+		for _, container := range append(taskRunLog.StepContainers, taskRunLog.InitContainers...) {
+			if container.Truncated != test.truncated[container.Name] {
+				t.Errorf("FAIL: with %v %s should have been truncated: %t", test.options, container.Name, test.truncated[container.Name])
+			}
+		}
+	}
+}
+
+/* Log streaming test: whether a redacted log was truncated is told from its size before redaction */
+
+func TestWriteTaskRunLogTruncatedRedacted(t *testing.T) {
+	t.Log("Testing truncated redacted logs are marked in a TaskRunLog")
+
+	// The first log is at the limit once its secret is masked, the second under it until a line ending is added
+	logs := map[string]string{
+		"build-step-one": "login s3cr3t-token-value",
+		"init1":          "no secret in this line!",
+	}
+	limitBytes := int64(24)
+	open := newLogRedactor([]string{"s3cr3t-token-value"}).redactLogs(dummyLogOpener(logs))
+	buf := new(bytes.Buffer)
+	writeTaskRunLog(buf, "pod1", dummyLogSources, open, v1.PodLogOptions{LimitBytes: &limitBytes})
+	taskRunLog := TaskRunLog{}
+	if err := json.Unmarshal(buf.Bytes(), &taskRunLog); err != nil {
+		t.Fatalf("FAIL: the TaskRunLog should have been valid JSON: %s", err)
+	}
+	if len(taskRunLog.StepContainers) == 0 || !taskRunLog.StepContainers[0].Truncated || taskRunLog.StepContainers[0].Logs[0] != "login ********" {
+		t.Errorf("FAIL: the redacted log at the limit should have been truncated, got %v", taskRunLog.StepContainers)
+	}
+	if len(taskRunLog.InitContainers) == 0 || taskRunLog.InitContainers[0].Truncated {
+		t.Errorf("FAIL: the log under the limit should not have been truncated, got %v", taskRunLog.InitContainers)
+	}
+}
+
+/* Log streaming test: lines longer than the maximum are read in parts rather than ending the log */
+
+func TestScanLogLines(t *testing.T) {
+	t.Log("Testing long log lines are split")
+
+	log := "first\n\n" + strings.Repeat("x", 2*maxLogLineSize+5) + "\n" + strings.Repeat("y", maxLogLineSize) + "\nlast"
+	var lengths []int
+	if err := scanLogLines(strings.NewReader(log), func(line string) {
+		lengths = append(lengths, len(line))
+	}); err != nil {
+		t.Errorf("FAIL: the log should have been read without an error: %s", err)
+	}
+	expected := []int{5, 0, maxLogLineSize, maxLogLineSize, 5, maxLogLineSize, 4}
+	if !reflect.DeepEqual(lengths, expected) {
+		t.Errorf("FAIL: expected lines of length %v, got %v", expected, lengths)
+	}
+}
+
+/* Log streaming test: each line is written as newline delimited JSON */
+
+func TestWriteLogLines(t *testing.T) {
+	t.Log("Testing log lines are streamed as newline delimited JSON")
+
+	buf := new(bytes.Buffer)
+	logLine := LogLine{NAMESPACE: "ns1", TASKRUN: "taskrun1", POD: "pod1"}
+	writeLogLines(buf, logLine, dummyLogSources, dummyLogOpener(dummyLogs), false)
+
+	var received []string
+	decoder := json.NewDecoder(buf)
+	for decoder.More() {
+		line := LogLine{}
+		if err := decoder.Decode(&line); err != nil {
+			t.Fatalf("FAIL: could not decode a log line: %s", err)
+		}
+		if line.NAMESPACE != "ns1" || line.TASKRUN != "taskrun1" || line.POD != "pod1" {
+			t.Errorf("FAIL: the log line should have been tagged with where it came from, got %v", line)
+		}
+		received = append(received, line.CONTAINERTYPE+"/"+line.CONTAINER+": "+line.LINE)
+	}
+	expected := []string{
+		"init/init1: first",
+		"init/init1: ",
+		"init/init1: second",
+		"step/build-step-one: hello <world>",
+	}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("FAIL: expected %v, got %v", expected, received)
+	}
+}
+
+/* Log streaming test: follow must be a boolean and flushes each write */
+
+func TestLogFollow(t *testing.T) {
+	t.Log("Testing the follow query parameter")
+
+	r := dummyResource()
+
+	httpWriter := httptest.NewRecorder()
+	httpReq := dummyHttpRequest("GET", "http://wwww.dummy.com:8383/v1/namespaces/ns1/pipelinerunlog/PipelineRun1?follow=maybe", nil)
+	req := dummyRestfulRequest(httpReq, "ns1", "PipelineRun1")
+	resp := dummyRestfulResponse(httpWriter)
+	r.getPipelineRunLog(req, resp)
+	if resp.StatusCode() != 400 {
+		t.Errorf("FAIL: an invalid follow should have given a 400, got %d", resp.StatusCode())
+	}
+
+	httpWriter = httptest.NewRecorder()
+	writer := newLogStreamWriter(dummyRestfulResponse(httpWriter), true)
+	writer.start("text/plain")
+	writer.Write([]byte("line\n"))
+	if !httpWriter.Flushed || httpWriter.Body.String() != "line\n" {
+		t.Errorf("FAIL: a followed log should be flushed as it is written")
+	}
+}
+
+/* Log options test: query parameters are validated and passed on to the pod log requests */
+
+func TestLogOptions(t *testing.T) {
+	t.Log("Testing the log query parameters")
+
+	tests := []struct {
+		query string
+		valid bool
+	}{
+		{"", true},
+		{"tailLines=200&timestamps=true", true},
+		{"tailLines=0", true},
+		{"sinceSeconds=60&previous=true&limitBytes=1024", true},
+		{"sinceTime=2019-04-01T00:00:00Z", true},
+		{"tailLines=-1", false},
+		{"tailLines=last", false},
+		{"sinceSeconds=0", false},
+		{"sinceTime=yesterday", false},
+		{"sinceSeconds=60&sinceTime=2019-04-01T00:00:00Z", false},
+		{"timestamps=maybe", false},
+		{"previous=maybe", false},
+		{"limitBytes=0", false},
+	}
+	for _, test := range tests {
+		httpWriter := httptest.NewRecorder()
+		httpReq := dummyHttpRequest("GET", "http://wwww.dummy.com:8383/v1/namespaces/ns1/log/pod1?"+test.query, nil)
+		req := dummyRestfulRequest(httpReq, "ns1", "pod1")
+		resp := dummyRestfulResponse(httpWriter)
+		_, valid := getLogOptions(req, resp)
+		if valid != test.valid {
+			t.Errorf("FAIL: %q should have been valid: %t, got %t", test.query, test.valid, valid)
+		}
+		if !valid && resp.StatusCode() != 400 {
+			t.Errorf("FAIL: %q should have given a 400, got %d", test.query, resp.StatusCode())
+		}
+	}
+
+	httpReq := dummyHttpRequest("GET", "http://wwww.dummy.com:8383/v1/namespaces/ns1/log/pod1?tailLines=200&timestamps=true&sinceTime=2019-04-01T00:00:00Z", nil)
+	options, _ := getLogOptions(dummyRestfulRequest(httpReq, "ns1", "pod1"), dummyRestfulResponse(httptest.NewRecorder()))
+	if options.TailLines == nil || *options.TailLines != 200 || !options.Timestamps || options.SinceTime == nil || options.SinceTime.Year() != 2019 {
+		t.Errorf("FAIL: the query parameters should have been set on the log options, got %v", options)
+	}
+}
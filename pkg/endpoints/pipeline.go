@@ -14,10 +14,12 @@ package endpoints
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -30,6 +32,7 @@ import (
 	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
 	informers "github.com/tektoncd/pipeline/pkg/client/informers/externalversions"
 	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/cache"
 )
@@ -49,13 +52,22 @@ type BuildRequest struct {
 	{
 		"repourl": "https://github.ibm.com/your-org/test-project",
 		"commitid": "7d84981c66718ee2dda1af280f915cc2feb6ffow",
-		"reponame": "test-project"
+		"reponame": "test-project",
+		"branch": "master",
+		"pipelinename": "simple-pipeline",
+		"serviceaccount": "default",
+		"registrylocation": "docker.io/your-org",
+		"params": [{"name": "foo", "value": "bar"}]
 	}
 	*/
-	REPOURL  string `json:"repourl"`
-	COMMITID string `json:"commitid"`
-	REPONAME string `json:"reponame"`
-	BRANCH   string `json:"branch"`
+	REPOURL          string           `json:"repourl"`
+	COMMITID         string           `json:"commitid"`
+	REPONAME         string           `json:"reponame"`
+	BRANCH           string           `json:"branch"`
+	PIPELINENAME     string           `json:"pipelinename"`
+	SERVICEACCOUNT   string           `json:"serviceaccount"`
+	REGISTRYLOCATION string           `json:"registrylocation"`
+	PARAMS           []v1alpha1.Param `json:"params"`
 }
 
 // PipelineRunUpdateBody - represents a request that a user may provide for updating a PipelineRun
@@ -64,6 +76,19 @@ type PipelineRunUpdateBody struct {
 	STATUS string `json:"status"`
 }
 
+// TaskRunUpdateBody - represents a request that a user may provide for updating a TaskRun
+// As with PipelineRuns only cancelling is supported, see RunActionBody for everything else
+type TaskRunUpdateBody struct {
+	STATUS string `json:"status"`
+}
+
+// PipelineRunRerunBody - represents a request to rerun a PipelineRun, optionally overriding its inputs
+// Params and resources are matched by name, anything not supplied is copied from the original PipelineRun
+type PipelineRunRerunBody struct {
+	PARAMS    []v1alpha1.Param                   `json:"params"`
+	RESOURCES []v1alpha1.PipelineResourceBinding `json:"resources"`
+}
+
 type TaskRunLog struct {
 	PodName string
 	// Containers correlating to Task step definitions
@@ -76,11 +101,28 @@ type TaskRunLog struct {
 type LogContainer struct {
 	Name string
 	Logs []string
+	LogContainerMetadata
+}
+
+// LogContainerMetadata - what is known about a container whose log is in a TaskRunLog
+type LogContainerMetadata struct {
+	// The Task step name, without the build-step- prefix, for step containers
+	StepName string `json:",omitempty"`
+	Image    string `json:",omitempty"`
+	// One of waiting, running or terminated, empty if it isn't known
+	State      string       `json:",omitempty"`
+	ExitCode   *int32       `json:",omitempty"`
+	Reason     string       `json:",omitempty"`
+	StartedAt  *metav1.Time `json:",omitempty"`
+	FinishedAt *metav1.Time `json:",omitempty"`
+	// True if the log was cut short by limitBytes
+	Truncated bool `json:",omitempty"`
 }
 
 const gitServerLabel = "gitServer"
 const gitOrgLabel = "gitOrg"
 const gitRepoLabel = "gitRepo"
+const rerunOfLabel = "rerunOf"
 
 /* Get all pipelines in a given namespace */
 func (r Resource) getAllPipelines(request *restful.Request, response *restful.Response) {
@@ -115,9 +157,9 @@ func (r Resource) getPipelineImpl(name, namespace string) (v1alpha1.Pipeline, er
 
 	pipelines := r.PipelineClient.TektonV1alpha1().Pipelines(namespace)
 	pipeline, err := pipelines.Get(name, metav1.GetOptions{})
-	if err != nil {
+	if err != nil || pipeline == nil {
 		logging.Log.Errorf("could not retrieve the pipeline called %s in namespace %s", name, namespace)
-		return *pipeline, err
+		return v1alpha1.Pipeline{}, err
 	} else {
 		logging.Log.Debugf("Found the pipeline definition OK")
 	}
@@ -135,8 +177,7 @@ func (r Resource) getAllPipelineRuns(request *restful.Request, response *restful
 	var pipelinerunList *v1alpha1.PipelineRunList
 	var err error
 	if repository != "" {
-		server, org, repo := getGitValues(repository)
-		match := gitServerLabel + "=" + server + "," + gitOrgLabel + "=" + org + "," + gitRepoLabel + "=" + repo
+		match := getRepositorySelector(repository)
 		pipelinerunList, err = pipelineruns.List(metav1.ListOptions{LabelSelector: match})
 		logging.Log.Debugf("+%v", pipelinerunList.Items)
 	} else {
@@ -164,6 +205,137 @@ func (r Resource) getPipelineRun(request *restful.Request, response *restful.Res
 	response.WriteEntity(pipelinerun)
 }
 
+/* Create a new manual PipelineRun, and the PipelineResources it binds, in a given namespace */
+func (r Resource) createPipelineRun(request *restful.Request, response *restful.Response) {
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In createPipelineRun, namespace: %s", namespace)
+
+	buildRequest := BuildRequest{}
+	if err := request.ReadEntity(&buildRequest); err != nil {
+		logging.Log.Errorf("error decoding the manual build request body: %s", err)
+		utils.RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+
+	if buildRequest.PIPELINENAME == "" || !isValidRepoURL(buildRequest.REPOURL) {
+		errorMsg := "error creating PipelineRun (bad request received), pipelinename and a repourl of the form http(s)://server/org/repo must be supplied."
+		utils.RespondErrorMessage(response, errorMsg, http.StatusBadRequest)
+		return
+	}
+
+	pipeline, err := r.getPipelineImpl(buildRequest.PIPELINENAME, namespace)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			utils.RespondError(response, err, http.StatusNotFound)
+		} else {
+			utils.RespondError(response, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	gitServer, gitOrg, gitRepo := getGitValues(buildRequest.REPOURL)
+	buildInformation := BuildInformation{
+		REPOURL:   buildRequest.REPOURL,
+		SHORTID:   getShortID(buildRequest.COMMITID),
+		COMMITID:  buildRequest.COMMITID,
+		REPONAME:  buildRequest.REPONAME,
+		TIMESTAMP: getDateTimeAsString(),
+	}
+	if buildInformation.REPONAME == "" {
+		buildInformation.REPONAME = gitRepo
+	}
+
+	pipelineRun, err := r.createPipelineRunImpl(pipeline, buildInformation, buildRequest, gitServer, gitOrg, gitRepo, namespace)
+	if err != nil {
+		logging.Log.Errorf("error creating PipelineRun for pipeline %s: %s", pipeline.Name, err)
+		utils.RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	logging.Log.Debugf("Created PipelineRun %s OK", pipelineRun.Name)
+	response.WriteHeaderAndEntity(http.StatusCreated, pipelineRun)
+}
+
+/* Create the PipelineResources and the PipelineRun for a manual build with generated names, deleting the PipelineResources again if the PipelineRun can't be created: the caller needs to handle any errors */
+func (r Resource) createPipelineRunImpl(pipeline v1alpha1.Pipeline, buildInformation BuildInformation, buildRequest BuildRequest,
+	gitServer, gitOrg, gitRepo, namespace string) (*v1alpha1.PipelineRun, error) {
+
+	resourceNamePrefix := getResourceName(buildInformation.REPONAME)
+	if buildInformation.SHORTID != "" {
+		resourceNamePrefix = resourceNamePrefix + "-" + buildInformation.SHORTID
+	}
+
+	revision := buildInformation.COMMITID
+	if revision == "" {
+		revision = buildRequest.BRANCH
+	}
+	if revision == "" {
+		revision = "master"
+	}
+
+	pipelineResources := r.PipelineClient.TektonV1alpha1().PipelineResources(namespace)
+
+	gitResource, err := pipelineResources.Create(definePipelineResource(resourceNamePrefix+"-git-source-", namespace,
+		[]v1alpha1.Param{{Name: "url", Value: buildInformation.REPOURL}, {Name: "revision", Value: revision}},
+		v1alpha1.PipelineResourceTypeGit))
+	if err != nil {
+		return nil, err
+	}
+	createdResources := []string{gitResource.Name}
+
+	var imageResource *v1alpha1.PipelineResource
+	if buildRequest.REGISTRYLOCATION != "" {
+		imageURL := strings.TrimSuffix(buildRequest.REGISTRYLOCATION, "/") + "/" + getResourceName(buildInformation.REPONAME)
+		if buildInformation.SHORTID != "" {
+			imageURL = imageURL + ":" + buildInformation.SHORTID
+		}
+		imageResource, err = pipelineResources.Create(definePipelineResource(resourceNamePrefix+"-docker-image-", namespace,
+			[]v1alpha1.Param{{Name: "url", Value: imageURL}},
+			v1alpha1.PipelineResourceTypeImage))
+		if err != nil {
+			r.deletePipelineResources(namespace, createdResources)
+			return nil, err
+		}
+		createdResources = append(createdResources, imageResource.Name)
+	}
+
+	// Bind our resources to whatever the Pipeline declares of the same type
+	var resourceBinding []v1alpha1.PipelineResourceBinding
+	for _, declared := range pipeline.Spec.Resources {
+		switch {
+		case declared.Type == v1alpha1.PipelineResourceTypeGit:
+			resourceBinding = append(resourceBinding, v1alpha1.PipelineResourceBinding{
+				Name:        declared.Name,
+				ResourceRef: v1alpha1.PipelineResourceRef{Name: gitResource.Name},
+			})
+		case declared.Type == v1alpha1.PipelineResourceTypeImage && imageResource != nil:
+			resourceBinding = append(resourceBinding, v1alpha1.PipelineResourceBinding{
+				Name:        declared.Name,
+				ResourceRef: v1alpha1.PipelineResourceRef{Name: imageResource.Name},
+			})
+		}
+	}
+
+	pipelineRun := definePipelineRun(pipeline.Name+"-run-", namespace, buildRequest.SERVICEACCOUNT, gitServer, gitOrg, gitRepo,
+		pipeline, v1alpha1.PipelineTriggerTypeManual, resourceBinding, buildRequest.PARAMS)
+
+	created, err := r.PipelineClient.TektonV1alpha1().PipelineRuns(namespace).Create(pipelineRun)
+	if err != nil {
+		r.deletePipelineResources(namespace, createdResources)
+		return nil, err
+	}
+	return created, nil
+}
+
+/* Delete the PipelineResources created for a manual build that couldn't be started, logging rather than returning any errors */
+func (r Resource) deletePipelineResources(namespace string, names []string) {
+	for _, name := range names {
+		err := r.PipelineClient.TektonV1alpha1().PipelineResources(namespace).Delete(name, &metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			logging.Log.Errorf("error deleting PipelineResource %s in namespace %s: %s", name, namespace, err)
+		}
+	}
+}
+
 /* Get a given pipeline resource by name in a given namespace */
 func (r Resource) getPipelineResource(request *restful.Request, response *restful.Response) {
 	name := request.PathParameter("name")
@@ -236,13 +408,26 @@ func (r Resource) getTaskRun(request *restful.Request, response *restful.Respons
 	response.WriteEntity(taskrun)
 }
 
-/* Get the logs for a given pod by name in a given namespace */
+/* Get the logs for a given pod by name in a given namespace, as text or with format=segments as newline delimited JSON */
 func (r Resource) getPodLog(request *restful.Request, response *restful.Response) {
 	namespace := request.PathParameter("namespace")
 	name := request.PathParameter("name")
 
 	logging.Log.Debugf("In getPodLog, name: %s, namespace: %s", name, namespace)
-	req := r.K8sClient.CoreV1().Pods(namespace).GetLogs(name, &v1.PodLogOptions{})
+	options, ok := getLogOptions(request, response)
+	if !ok {
+		return
+	}
+	segments, ok := getLogFormat(request, response)
+	if !ok {
+		return
+	}
+	// The whole log is returned as a single string, use taskrunlog or pipelinerunlog to follow logs
+	if options.Follow {
+		utils.RespondErrorMessage(response, "Error: follow is only supported for taskrunlog and pipelinerunlog", http.StatusBadRequest)
+		return
+	}
+	req := r.K8sClient.CoreV1().Pods(namespace).GetLogs(name, &options)
 	podLogs, err := req.Stream()
 	if err != nil {
 		utils.RespondError(response, err, http.StatusNotFound)
@@ -256,7 +441,17 @@ func (r Resource) getPodLog(request *restful.Request, response *restful.Response
 		utils.RespondError(response, err, http.StatusNotFound)
 		return
 	}
-	str := buf.String()
+	serviceAccount := ""
+	if pod, err := r.K8sClient.CoreV1().Pods(namespace).Get(name, metav1.GetOptions{}); err == nil {
+		serviceAccount = pod.Spec.ServiceAccountName
+	}
+	str := r.getLogRedactor(namespace, serviceAccount).redact(buf.String())
+	if segments {
+		writer := newLogStreamWriter(response, false)
+		writer.start(mimeNDJSON)
+		encodeLogLines(json.NewEncoder(writer), LogLine{NAMESPACE: namespace, POD: name}, strings.NewReader(str), true)
+		return
+	}
 	response.AddHeader("Content-Type", "text/plain")
 	response.WriteEntity(str)
 }
@@ -267,17 +462,34 @@ func (r Resource) getTaskRunLog(request *restful.Request, response *restful.Resp
 	namespace := request.PathParameter("namespace")
 
 	logging.Log.Debugf("In getTaskRunLog, name: %s, namespace: %s", taskRunName, namespace)
+	options, ok := getLogOptions(request, response)
+	if !ok {
+		return
+	}
+	if _, ok := getLogFormat(request, response); !ok {
+		return
+	}
 	taskRunsInterface := r.PipelineClient.TektonV1alpha1().TaskRuns(namespace)
 	taskRun, err := taskRunsInterface.Get(taskRunName, metav1.GetOptions{})
 	if err != nil || taskRun.Status.PodName == "" {
-		utils.RespondError(response, err, http.StatusNotFound)
+		// The TaskRun may have been deleted since its logs were archived
+		if r.writeArchivedTaskRunLog(request, response, namespace, taskRunName, "", "", options) {
+			return
+		}
+		if err != nil {
+			utils.RespondError(response, err, http.StatusNotFound)
+		} else {
+			utils.RespondErrorMessage(response, fmt.Sprintf("Error: TaskRun %s has no pod", taskRunName), http.StatusNotFound)
+		}
 		return
 	}
 
 	podname := taskRun.Status.PodName
 	pod, err := r.K8sClient.CoreV1().Pods(namespace).Get(podname, metav1.GetOptions{})
 	if err != nil {
-		utils.RespondError(response, err, http.StatusNotFound)
+		if !r.writeArchivedTaskRunLog(request, response, namespace, taskRunName, string(taskRun.UID), taskRun.Spec.ServiceAccount, options) {
+			utils.RespondError(response, err, http.StatusNotFound)
+		}
 		return
 	}
 
@@ -295,66 +507,43 @@ func (r Resource) getTaskRunLog(request *restful.Request, response *restful.Resp
 			taskSpec = &(task.Spec)
 		}
 	}
-	// Unexported field within tekton
-	containerPrefix := "build-step-"
 	stepNames := make(map[string]struct{})
 	if taskSpec != nil {
 		for _, step := range taskSpec.Steps {
-			stepNames[containerPrefix+step.Name] = struct{}{}
+			stepNames[stepContainerPrefix+step.Name] = struct{}{}
 		}
 	}
 
-	podContainers := pod.Spec.Containers
-	initContainers := pod.Spec.InitContainers
+	sources := getLogSources(pod, func(container string) bool {
+		_, ok := stepNames[container]
+		return ok
+	})
+
+	logLine := LogLine{NAMESPACE: namespace, PIPELINERUN: taskRun.Labels[pipelineRunLabel], TASKRUN: taskRunName, POD: podname}
+	writer := newLogStreamWriter(response, options.Follow)
+	open := r.getLogRedactor(namespace, taskRun.Spec.ServiceAccount).redactLogs(r.podLogOpener(namespace, podname, options))
+	writeTaskRunLogResponse(request, writer, logLine, sources, open, options)
+}
 
-	taskRunLog := TaskRunLog{PodName: podname}
-	buf := new(bytes.Buffer)
-	var podLogs io.ReadCloser
-	setContainers := func(containers []v1.Container, filter func(l LogContainer)) {
-		for _, container := range containers {
-			buf.Reset()
-			step := LogContainer{Name: container.Name}
-			req := r.K8sClient.CoreV1().Pods(namespace).GetLogs(podname, &v1.PodLogOptions{Container: container.Name})
-			if req.URL().Path == "" {
-				continue
-			}
-			podLogs, err = req.Stream()
-			if err != nil {
-				continue
-			}
-			_, err = io.Copy(buf, podLogs)
-			if err != nil {
-				podLogs.Close()
-				continue
-			}
-			logs := strings.Split(buf.String(), "\n")
-			for _, log := range logs {
-				// Split does not flatten the search string
-				if log != "" {
-					step.Logs = append(step.Logs, log)
-				}
-			}
-			filter(step)
-			podLogs.Close()
-		}
+/* Write a TaskRun's log from the log archive, returning false if it hasn't been archived.
+ * uid is the TaskRun's UID if it still exists, so the log of a deleted TaskRun of the same name isn't given
+ */
+func (r Resource) writeArchivedTaskRunLog(request *restful.Request, response *restful.Response, namespace, taskRunName, uid, serviceAccount string, options v1.PodLogOptions) bool {
+	archived, ok := r.getArchivedTaskRun(namespace, taskRunName)
+	if !ok || (uid != "" && archived.UID != uid) {
+		return false
 	}
-	setContainers(initContainers, func(l LogContainer) {
-		taskRunLog.InitContainers = append(taskRunLog.InitContainers, l)
-	})
-	setContainers(podContainers, func(l LogContainer) {
-		if _, ok := stepNames[l.Name]; ok {
-			taskRunLog.StepContainers = append(taskRunLog.StepContainers, l)
-		} else {
-			taskRunLog.PodContainers = append(taskRunLog.PodContainers, l)
-		}
-	})
-	response.WriteEntity(taskRunLog)
+	logLine := LogLine{NAMESPACE: namespace, PIPELINERUN: archived.PipelineRun, TASKRUN: taskRunName, POD: archived.PodName}
+	writer := newLogStreamWriter(response, false)
+	open := r.getLogRedactor(namespace, serviceAccount).redactLogs(r.archiveLogOpener(namespace, taskRunName, options))
+	writeTaskRunLogResponse(request, writer, logLine, archived.logSources(), open, options)
+	return true
 }
 
 /* Create a new PipelineResource: this should be of type git or image */
-func definePipelineResource(name, namespace string, params []v1alpha1.Param, resourceType v1alpha1.PipelineResourceType) *v1alpha1.PipelineResource {
+func definePipelineResource(generateName, namespace string, params []v1alpha1.Param, resourceType v1alpha1.PipelineResourceType) *v1alpha1.PipelineResource {
 	pipelineResource := v1alpha1.PipelineResource{
-		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		ObjectMeta: metav1.ObjectMeta{GenerateName: generateName, Namespace: namespace},
 		Spec: v1alpha1.PipelineResourceSpec{
 			Type:   resourceType,
 			Params: params,
@@ -365,7 +554,7 @@ func definePipelineResource(name, namespace string, params []v1alpha1.Param, res
 }
 
 /* Create a new PipelineResource: this should be of type git or image */
-func definePipelineRun(pipelineRunName, namespace, saName, gitServer, gitOrg, gitRepo string,
+func definePipelineRun(generateName, namespace, saName, gitServer, gitOrg, gitRepo string,
 	pipeline v1alpha1.Pipeline,
 	triggerType v1alpha1.PipelineTriggerType,
 	resourceBinding []v1alpha1.PipelineResourceBinding,
@@ -373,8 +562,8 @@ func definePipelineRun(pipelineRunName, namespace, saName, gitServer, gitOrg, gi
 
 	pipelineRunData := v1alpha1.PipelineRun{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      pipelineRunName,
-			Namespace: namespace,
+			GenerateName: generateName,
+			Namespace:    namespace,
 			Labels: map[string]string{
 				"app":          "devops-knative",
 				gitServerLabel: gitServer,
@@ -397,6 +586,29 @@ func definePipelineRun(pipelineRunName, namespace, saName, gitServer, gitOrg, gi
 	return pipelineRunPointer
 }
 
+// Returns the first seven characters of a commit id, as git does
+func getShortID(commitID string) string {
+	if len(commitID) > 7 {
+		return commitID[0:7]
+	}
+	return commitID
+}
+
+// Returns a name that can be used as part of a Kubernetes resource name
+func getResourceName(name string) string {
+	return strings.NewReplacer("_", "-", ".", "-", "/", "-").Replace(strings.ToLower(name))
+}
+
+// Returns true if the url has a server, org and repo that getGitValues can extract
+func isValidRepoURL(url string) bool {
+	url = strings.TrimSuffix(url, "/")
+	if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
+		return false
+	}
+	repoURL := url[strings.Index(url, "://")+3:]
+	return strings.Index(repoURL, "/") > 0 && strings.Index(repoURL, "/") < strings.LastIndex(repoURL, "/")
+}
+
 func getDateTimeAsString() string {
 	return strconv.FormatInt(time.Now().Unix(), 10)
 }
@@ -420,10 +632,24 @@ func getGitValues(url string) (gitServer, gitOrg, gitRepo string) {
 	return gitServer, gitOrg, gitRepo
 }
 
+// Returns a label selector matching runs for the repository, using the labels set by definePipelineRun
+func getRepositorySelector(repository string) string {
+	server, org, repo := getGitValues(repository)
+	return gitServerLabel + "=" + server + "," + gitOrgLabel + "=" + org + "," + gitRepoLabel + "=" + repo
+}
+
 /* Get the logs for a given pipelinerun by name in a given namespace */
 func (r Resource) getPipelineRunLog(request *restful.Request, response *restful.Response) {
 	namespace := request.PathParameter("namespace")
 	name := request.PathParameter("name")
+	options, ok := getLogOptions(request, response)
+	if !ok {
+		return
+	}
+	segments, ok := getLogFormat(request, response)
+	if !ok {
+		return
+	}
 
 	pipelineruns := r.PipelineClient.TektonV1alpha1().PipelineRuns(namespace)
 	pipelinerun, err := pipelineruns.Get(name, metav1.GetOptions{})
@@ -432,37 +658,87 @@ func (r Resource) getPipelineRunLog(request *restful.Request, response *restful.
 		return
 	}
 
-	buf := new(bytes.Buffer)
-	var podLogs io.ReadCloser
-	for key, taskrunstatus := range pipelinerun.Status.TaskRuns {
-		podname := taskrunstatus.Status.PodName
-		pod, err := r.K8sClient.CoreV1().Pods(namespace).Get(podname, metav1.GetOptions{})
-		if err != nil {
-			continue
+	ndjson := segments || acceptsNDJSON(request)
+	writer := newLogStreamWriter(response, options.Follow)
+	if ndjson {
+		writer.start(mimeNDJSON)
+	} else {
+		writer.start("text/plain")
+	}
+
+	r.forEachTaskRunLog(namespace, pipelinerun, options, func(key, podname string, sources []logSource, open logOpener) bool {
+		if ndjson {
+			logLine := LogLine{NAMESPACE: namespace, PIPELINERUN: name, TASKRUN: key, POD: podname}
+			writeLogLines(writer, logLine, sources, open, segments)
+			return true
 		}
-		containers := append(append([]v1.Container{}, pod.Spec.Containers...), pod.Spec.InitContainers...)
-		for _, container := range containers {
-			buf.WriteString("\n=== " + podname + ": " + key + ": " + container.Name + " ===\n")
-			req := r.K8sClient.CoreV1().Pods(namespace).GetLogs(podname, &v1.PodLogOptions{Container: container.Name})
-			if req.URL().Path == "" {
-				continue
+
+		// Init containers last
+		var containers []logSource
+		for _, source := range sources {
+			if source.containerType != initContainerType {
+				containers = append(containers, source)
 			}
-			podLogs, err = req.Stream()
-			if err != nil {
-				continue
+		}
+		for _, source := range sources {
+			if source.containerType == initContainerType {
+				containers = append(containers, source)
 			}
-			_, err = io.Copy(buf, podLogs)
-			if err != nil {
-				podLogs.Close()
+		}
+		for _, container := range containers {
+			writer.Write([]byte("\n=== " + podname + ": " + key + ": " + container.container + " ===\n"))
+			podLogs, ok := open(container.container)
+			if !ok {
 				continue
 			}
+			io.Copy(writer, podLogs)
 			podLogs.Close()
 		}
+		return true
+	})
+}
+
+/* Call handle with the containers of each of a PipelineRun's TaskRuns, in TaskRun name order so it is the same between requests,
+ * until it returns false. Containers are read from the TaskRun's pod, or from the log archive once the pod has gone.
+ * TaskRuns with neither are skipped. Credentials are redacted from the logs opened.
+ */
+func (r Resource) forEachTaskRunLog(namespace string, pipelinerun *v1alpha1.PipelineRun, options v1.PodLogOptions, handle func(taskRunName, podName string, sources []logSource, open logOpener) bool) {
+	var taskRunNames []string
+	for key := range pipelinerun.Status.TaskRuns {
+		taskRunNames = append(taskRunNames, key)
 	}
+	sort.Strings(taskRunNames)
 
-	str := buf.String()
-	response.AddHeader("Content-Type", "text/plain")
-	response.WriteEntity(str)
+	redactor := r.getLogRedactor(namespace, pipelinerun.Spec.ServiceAccount)
+	for _, key := range taskRunNames {
+		taskrunstatus := pipelinerun.Status.TaskRuns[key]
+		if taskrunstatus.Status == nil {
+			continue
+		}
+		podname := taskrunstatus.Status.PodName
+		if sources, open, ok := r.getTaskRunLogSources(namespace, key, podname, "", redactor, options); ok && !handle(key, podname, sources, open) {
+			return
+		}
+	}
+}
+
+/* The containers of a TaskRun and how to open their logs: from its pod, or from the log archive once the pod has gone.
+ * uid and podName are checked against the archive if they are known, so another TaskRun's archived logs aren't used
+ */
+func (r Resource) getTaskRunLogSources(namespace, taskRunName, podName, uid string, redactor *logRedactor, options v1.PodLogOptions) ([]logSource, logOpener, bool) {
+	if podName != "" {
+		if pod, err := r.K8sClient.CoreV1().Pods(namespace).Get(podName, metav1.GetOptions{}); err == nil {
+			sources := getLogSources(pod, func(container string) bool {
+				return strings.HasPrefix(container, stepContainerPrefix)
+			})
+			return sources, redactor.redactLogs(r.podLogOpener(namespace, podName, options)), true
+		}
+	}
+	archived, ok := r.getArchivedTaskRun(namespace, taskRunName)
+	if !ok || (uid != "" && archived.UID != uid) || (podName != "" && archived.PodName != podName) {
+		return nil, nil, false
+	}
+	return archived.logSources(), redactor.redactLogs(r.archiveLogOpener(namespace, taskRunName, options)), true
 }
 
 /* Get all pipeline resources in a given namespace */
@@ -479,22 +755,14 @@ func (r Resource) getAllPipelineResources(request *restful.Request, response *re
 	response.WriteEntity(pipelineresourcelist)
 }
 
-/* Update a given PipelineRun by name in a given namespace */
+/* Update a given PipelineRun by name in a given namespace
+ * Only cancelling is supported here, see patchPipelineRun for the full set of actions
+ */
 func (r Resource) updatePipelineRun(request *restful.Request, response *restful.Response) {
 	name := request.PathParameter("name")
 	namespace := request.PathParameter("namespace")
 	logging.Log.Debugf("In updatePipelineRun, name: %s, namespace: %s", name, namespace)
 
-	pipelineRun, err := r.PipelineClient.TektonV1alpha1().PipelineRuns(namespace).Get(name, metav1.GetOptions{})
-	if err != nil || pipelineRun == nil {
-		utils.RespondError(response, err, http.StatusNotFound)
-		return
-	} else {
-		logging.Log.Debug("Found the PipelineRun ok")
-	}
-
-	// We've found the PipelineRun at this stage
-
 	updateBody := PipelineRunUpdateBody{}
 	updateBody.STATUS = ""
 
@@ -504,49 +772,165 @@ func (r Resource) updatePipelineRun(request *restful.Request, response *restful.
 		return
 	}
 
-	currentStatus := pipelineRun.Spec.Status
-	desiredStatus := v1alpha1.PipelineRunSpecStatus(updateBody.STATUS)
+	// Checked here as the other actions applyPipelineRunActions would list can't be requested through this route
+	if updateBody.STATUS == "" {
+		errorMsg := fmt.Sprintf("error updating PipelineRun status (bad request received), status must be set to %s.", pipelineRunCancelled)
+		utils.RespondErrorMessage(response, errorMsg, http.StatusBadRequest)
+		return
+	}
+
+	actionBody := RunActionBody{STATUS: updateBody.STATUS}
+	if _, ok := r.applyPipelineRunActions(name, namespace, actionBody, response); !ok {
+		return
+	}
+	logging.Log.Debug("Update performed successfully, returning http code 204")
+	// Not to be confused with WriteEntity which always gives a 200 even if the parameter is something other than StatusOk
+	response.WriteHeader(http.StatusNoContent)
+}
 
-	logging.Log.Debugf("Current status of PipelineRun %s in namespace %s is: %s, wanting status: %s", name, namespace, currentStatus, desiredStatus)
+/* Update a given TaskRun by name in a given namespace
+ * Only cancelling is supported here, see patchTaskRun for the full set of actions
+ */
+func (r Resource) updateTaskRun(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In updateTaskRun, name: %s, namespace: %s", name, namespace)
 
-	// If there's anything else we want to allow, update this code
-	if desiredStatus != "PipelineRunCancelled" {
-		errorMsg := "error updating PipelineRun status (bad request received), status must be set to PipelineRunCancelled."
-		logging.Log.Errorf(errorMsg)
-		err := errors.New(errorMsg)
+	updateBody := TaskRunUpdateBody{}
+	if err := request.ReadEntity(&updateBody); err != nil {
+		logging.Log.Errorf("error decoding the TaskRun status request body: %s", err)
 		utils.RespondError(response, err, http.StatusBadRequest)
 		return
 	}
 
-	if currentStatus != desiredStatus {
-		pipelineRun.Spec.Status = desiredStatus
-		_, err := r.PipelineClient.TektonV1alpha1().PipelineRuns(namespace).Update(pipelineRun)
-		if err != nil {
-			logging.Log.Errorf("error updating PipelineRun status: %s", err)
-			utils.RespondError(response, err, http.StatusInternalServerError)
-			return
-		} else {
-			logging.Log.Debugf("PipelineRun status updated OK to %s", pipelineRun.Spec.Status)
-		}
-	} else {
-		errorMsg := fmt.Sprintf("error: Status was already set to %s", desiredStatus)
-		logging.Log.Error(errorMsg)
-		err := errors.New(errorMsg)
-		utils.RespondError(response, err, http.StatusPreconditionFailed)
+	// Checked here as the other actions applyTaskRunActions would list can't be requested through this route
+	if updateBody.STATUS == "" {
+		errorMsg := fmt.Sprintf("error updating TaskRun status (bad request received), status must be set to %s.", taskRunCancelled)
+		utils.RespondErrorMessage(response, errorMsg, http.StatusBadRequest)
+		return
+	}
+
+	actionBody := RunActionBody{STATUS: updateBody.STATUS}
+	if _, ok := r.applyTaskRunActions(name, namespace, actionBody, response); !ok {
 		return
 	}
 	logging.Log.Debug("Update performed successfully, returning http code 204")
-	// Not to be confused with WriteEntity which always gives a 200 even if the parameter is something other than StatusOk
 	response.WriteHeader(http.StatusNoContent)
 }
 
-// StartPipelineRunController - registers the code that reacts to changes in kube PipelineRuns
-func (r Resource) StartPipelineRunController(stopCh <-chan struct{}) {
+/* Rerun a given PipelineRun by name in a given namespace, creating a new PipelineRun with the same or overridden inputs */
+func (r Resource) rerunPipelineRun(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In rerunPipelineRun, name: %s, namespace: %s", name, namespace)
+
+	pipelineRuns := r.PipelineClient.TektonV1alpha1().PipelineRuns(namespace)
+	pipelineRun, err := pipelineRuns.Get(name, metav1.GetOptions{})
+	if err != nil || pipelineRun == nil {
+		utils.RespondError(response, err, http.StatusNotFound)
+		return
+	}
+
+	// An empty body reruns the PipelineRun as it was
+	rerunBody := PipelineRunRerunBody{}
+	if err := request.ReadEntity(&rerunBody); err != nil && err != io.EOF {
+		logging.Log.Errorf("error decoding the PipelineRun rerun request body: %s", err)
+		utils.RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+
+	newPipelineRun, err := definePipelineRunRerun(pipelineRun, rerunBody)
+	if err != nil {
+		utils.RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+
+	createdPipelineRun, err := pipelineRuns.Create(newPipelineRun)
+	if err != nil {
+		respondWriteError(response, "rerun of PipelineRun "+name, err)
+		return
+	}
+	// A retry Pipeline would otherwise be deleted with the PipelineRun rerun, leaving the rerun without its Pipeline
+	if err := r.ownRetryPipeline(createdPipelineRun); err != nil {
+		if err := pipelineRuns.Delete(createdPipelineRun.Name, &metav1.DeleteOptions{}); err != nil {
+			logging.Log.Errorf("error deleting PipelineRun %s: %s", createdPipelineRun.Name, err)
+		}
+		respondWriteError(response, "owner of retry Pipeline "+createdPipelineRun.Spec.PipelineRef.Name, err)
+		return
+	}
+	logging.Log.Debugf("Created PipelineRun %s as a rerun of %s", createdPipelineRun.Name, name)
+	response.WriteHeaderAndEntity(http.StatusCreated, createdPipelineRun)
+}
+
+/* Copy a PipelineRun into a new, unstarted PipelineRun with a generated name, applying any overrides */
+func definePipelineRunRerun(original *v1alpha1.PipelineRun, rerunBody PipelineRunRerunBody) (*v1alpha1.PipelineRun, error) {
+	spec := original.Spec.DeepCopy()
+	spec.Status = ""
+	spec.Trigger = v1alpha1.PipelineTrigger{Type: v1alpha1.PipelineTriggerTypeManual}
+
+	for _, param := range rerunBody.PARAMS {
+		if param.Name == "" {
+			return nil, errors.New("error rerunning PipelineRun (bad request received), every param must have a name.")
+		}
+		overridden := false
+		for i := range spec.Params {
+			if spec.Params[i].Name == param.Name {
+				spec.Params[i].Value = param.Value
+				overridden = true
+			}
+		}
+		if !overridden {
+			spec.Params = append(spec.Params, param)
+		}
+	}
+
+	for _, resource := range rerunBody.RESOURCES {
+		overridden := false
+		for i := range spec.Resources {
+			if spec.Resources[i].Name == resource.Name {
+				spec.Resources[i].ResourceRef = resource.ResourceRef
+				overridden = true
+			}
+		}
+		if !overridden || resource.ResourceRef.Name == "" {
+			errorMsg := fmt.Sprintf("error rerunning PipelineRun (bad request received), resource %s must be bound by %s and reference a PipelineResource.", resource.Name, original.Name)
+			return nil, errors.New(errorMsg)
+		}
+	}
+
+	labels := make(map[string]string)
+	for key, value := range original.Labels {
+		labels[key] = value
+	}
+	labels[rerunOfLabel] = original.Name
+
+	// Avoid names growing with every rerun of a rerun
+	baseName := original.Name
+	if i := strings.LastIndex(baseName, "-rerun-"); i > 0 {
+		baseName = baseName[:i]
+	}
+
+	pipelineRun := v1alpha1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: baseName + "-rerun-",
+			Namespace:    original.Namespace,
+			Labels:       labels,
+		},
+		Spec: *spec,
+	}
+	return &pipelineRun, nil
+}
+
+/* StartPipelineRunController - registers the code that reacts to changes in kube PipelineRuns.
+ * Start it before registering the websocket, so websocket clients are sent their initial PipelineRuns from its cache
+ */
+func (r *Resource) StartPipelineRunController(stopCh <-chan struct{}) {
 	logging.Log.Debug("Into StartPipelineRunController")
 
 	pipelineRunInformerFactory := informers.NewSharedInformerFactory(r.PipelineClient, time.Second*30)
-	pipelineRunInformer := pipelineRunInformerFactory.Tekton().V1alpha1().PipelineRuns()
-	pipelineRunInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+	pipelineRunInformer := pipelineRunInformerFactory.Tekton().V1alpha1().PipelineRuns().Informer()
+	r.pipelineRunInformer = pipelineRunInformer
+	pipelineRunInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    r.pipelineRunCreated,
 		UpdateFunc: r.pipelineRunUpdated,
 		DeleteFunc: r.pipelineRunDeleted,
@@ -558,9 +942,11 @@ func (r Resource) StartPipelineRunController(stopCh <-chan struct{}) {
 func (r Resource) pipelineRunCreated(obj interface{}) {
 	logging.Log.Debug("In pipelineRunCreated")
 
+	pipelineRun := obj.(*v1alpha1.PipelineRun)
 	data := broadcaster.SocketData{
 		MessageType: broadcaster.PipelineRunCreated,
-		Payload:     obj.(*v1alpha1.PipelineRun),
+		Payload:     pipelineRun,
+		Subject:     pipelineRunSubject(pipelineRun),
 	}
 
 	pipelineRunsChannel <- data
@@ -568,11 +954,13 @@ func (r Resource) pipelineRunCreated(obj interface{}) {
 
 func (r Resource) pipelineRunUpdated(oldObj, newObj interface{}) {
 
-	if newObj.(*v1alpha1.PipelineRun).GetResourceVersion() != oldObj.(*v1alpha1.PipelineRun).GetResourceVersion() {
+	pipelineRun := newObj.(*v1alpha1.PipelineRun)
+	if pipelineRun.GetResourceVersion() != oldObj.(*v1alpha1.PipelineRun).GetResourceVersion() {
 		logging.Log.Debug("Pipelinerun update recorded")
 		data := broadcaster.SocketData{
 			MessageType: broadcaster.PipelineRunUpdated,
-			Payload:     newObj.(*v1alpha1.PipelineRun),
+			Payload:     pipelineRun,
+			Subject:     pipelineRunSubject(pipelineRun),
 		}
 		pipelineRunsChannel <- data
 	}
@@ -581,9 +969,11 @@ func (r Resource) pipelineRunUpdated(oldObj, newObj interface{}) {
 func (r Resource) pipelineRunDeleted(obj interface{}) {
 	logging.Log.Debug("In pipelineRunDeleted")
 
+	pipelineRun := obj.(*v1alpha1.PipelineRun)
 	data := broadcaster.SocketData{
 		MessageType: broadcaster.PipelineRunDeleted,
-		Payload:     obj.(*v1alpha1.PipelineRun),
+		Payload:     pipelineRun,
+		Subject:     pipelineRunSubject(pipelineRun),
 	}
 
 	pipelineRunsChannel <- data
@@ -14,13 +14,17 @@ package endpoints
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
 	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 // Task test
@@ -856,6 +860,9 @@ func TestPipelineRunUpdateBadRequest(t *testing.T) {
 	if !strings.Contains(httpWriter.Body.String(), "bad request") {
 		t.Errorf("FAIL: should have been recognised as a bad request with bad request being in the error, got: %s", httpWriter.Body.String())
 	}
+	if !strings.Contains(httpWriter.Body.String(), "PipelineRunCancelled") || strings.Contains(httpWriter.Body.String(), "addLabels") {
+		t.Errorf("FAIL: the error should only mention the status this route accepts, got: %s", httpWriter.Body.String())
+	}
 }
 
 /* Not found PipelineRun (by name) test: 404 and message returned */
@@ -1145,3 +1152,308 @@ func TestPipelineRunUpdateStatusAlreadySet412(t *testing.T) {
 		t.Errorf("FAIL: should have received a http 412 code when setting the status to something already set, got %d", resp.StatusCode())
 	}
 }
+
+/* Manual build test: the PipelineResources and a labelled PipelineRun should be created */
+
+func TestCreatePipelineRun(t *testing.T) {
+	t.Log("Testing a manual build request creates a PipelineRun and returns a 201")
+
+	r := dummyResource()
+
+	pipeline1 := v1alpha1.Pipeline{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "Pipeline1",
+		},
+		Spec: v1alpha1.PipelineSpec{
+			Resources: []v1alpha1.PipelineDeclaredResource{
+				{Name: "git-source", Type: v1alpha1.PipelineResourceTypeGit},
+				{Name: "docker-image", Type: v1alpha1.PipelineResourceTypeImage},
+			},
+		},
+	}
+
+	_, err := r.PipelineClient.TektonV1alpha1().Pipelines("ns1").Create(&pipeline1)
+	if err != nil {
+		t.Errorf("Error creating the Pipeline for use with TestCreatePipelineRun, error: %s", err)
+	}
+
+	httpWriter := httptest.NewRecorder()
+
+	buildBody := strings.NewReader(`{"repourl": "https://github.com/foo/bar", "commitid": "7d84981c66718ee2dda1af280f915cc2feb6ffow",
+		"pipelinename": "Pipeline1", "serviceaccount": "default", "registrylocation": "docker.io/foo",
+		"params": [{"name": "param1", "value": "value1"}]}`)
+	buildRequest := dummyHttpRequest("POST", "http://wwww.dummy.com:8383/v1/namespaces/ns1/pipelinerun", buildBody)
+	buildRequestRestful := dummyRestfulRequest(buildRequest, "ns1", "")
+	resp := dummyRestfulResponse(httpWriter)
+	r.createPipelineRun(buildRequestRestful, resp)
+
+	if resp.StatusCode() != 201 {
+		t.Fatalf("FAIL: should have been recognised as a 201, got %d: %s", resp.StatusCode(), httpWriter.Body.String())
+	}
+
+	result := v1alpha1.PipelineRun{}
+	json.NewDecoder(httpWriter.Body).Decode(&result)
+
+	if result.Spec.PipelineRef.Name != "Pipeline1" {
+		t.Errorf("PipelineRun does not reference Pipeline1: %s", result.Spec.PipelineRef.Name)
+	}
+	if !strings.HasPrefix(result.Name, "Pipeline1-run-") || result.GenerateName != "Pipeline1-run-" {
+		t.Errorf("PipelineRun name should have been generated from Pipeline1-run-, got %s", result.Name)
+	}
+	if result.Labels[gitServerLabel] != "github.com" || result.Labels[gitOrgLabel] != "foo" || result.Labels[gitRepoLabel] != "bar" {
+		t.Errorf("PipelineRun does not have the expected git labels: %v", result.Labels)
+	}
+	if result.Spec.ServiceAccount != "default" {
+		t.Errorf("PipelineRun service account: expected: %s, returned: %s", "default", result.Spec.ServiceAccount)
+	}
+	if len(result.Spec.Params) != 1 || result.Spec.Params[0].Name != "param1" {
+		t.Errorf("PipelineRun params not passed through: %v", result.Spec.Params)
+	}
+	if len(result.Spec.Resources) != 2 {
+		t.Fatalf("Number of resource bindings: expected: %d, returned: %d", 2, len(result.Spec.Resources))
+	}
+
+	for _, binding := range result.Spec.Resources {
+		resource, err := r.PipelineClient.TektonV1alpha1().PipelineResources("ns1").Get(binding.ResourceRef.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Errorf("PipelineResource %s bound to %s was not created: %s", binding.ResourceRef.Name, binding.Name, err)
+			continue
+		}
+		if binding.Name == "git-source" && resource.Spec.Type != v1alpha1.PipelineResourceTypeGit {
+			t.Errorf("git-source bound to a PipelineResource of type %s", resource.Spec.Type)
+		}
+		if binding.Name == "docker-image" && resource.Spec.Params[0].Value != "docker.io/foo/bar:7d84981" {
+			t.Errorf("docker-image has an unexpected url: %s", resource.Spec.Params[0].Value)
+		}
+	}
+}
+
+/* Manual build with a missing pipeline name or bad repo url: 400 returned */
+
+func TestCreatePipelineRunBadRequest(t *testing.T) {
+	t.Log("Testing a manual build request without a pipeline or with a bad repo url throws a 400")
+
+	r := dummyResource()
+
+	for _, body := range []string{
+		`{"repourl": "https://github.com/foo/bar"}`,
+		`{"repourl": "github.com", "pipelinename": "Pipeline1"}`,
+	} {
+		httpWriter := httptest.NewRecorder()
+		badRequest := dummyHttpRequest("POST", "http://wwww.dummy.com:8383/v1/namespaces/ns1/pipelinerun", strings.NewReader(body))
+		badRequestRestful := dummyRestfulRequest(badRequest, "ns1", "")
+		resp := dummyRestfulResponse(httpWriter)
+		r.createPipelineRun(badRequestRestful, resp)
+
+		if resp.StatusCode() != 400 {
+			t.Errorf("FAIL: %s should have been recognised as a bad request, got %d", body, resp.StatusCode())
+		}
+	}
+}
+
+/* Manual build for an unknown pipeline, or that fails to create the PipelineRun: no PipelineResources are left behind */
+
+func TestCreatePipelineRunFailure(t *testing.T) {
+	t.Log("Testing a failed manual build request deletes the PipelineResources it created")
+
+	r := dummyResource()
+	pipelineClient := dummyClientset()
+	r.PipelineClient = pipelineClient
+	pipeline1 := v1alpha1.Pipeline{ObjectMeta: metav1.ObjectMeta{Name: "Pipeline1"}}
+	r.PipelineClient.TektonV1alpha1().Pipelines("ns1").Create(&pipeline1)
+
+	createBuild := func(pipelineName string) int {
+		httpWriter := httptest.NewRecorder()
+		buildBody := strings.NewReader(`{"repourl": "https://github.com/foo/bar", "pipelinename": "` + pipelineName + `", "registrylocation": "docker.io/foo"}`)
+		buildRequest := dummyHttpRequest("POST", "http://wwww.dummy.com:8383/v1/namespaces/ns1/pipelinerun", buildBody)
+		resp := dummyRestfulResponse(httpWriter)
+		r.createPipelineRun(dummyRestfulRequest(buildRequest, "ns1", ""), resp)
+		return resp.StatusCode()
+	}
+
+	if code := createBuild("Pipeline2"); code != 404 {
+		t.Errorf("FAIL: an unknown pipeline should have given a 404, got %d", code)
+	}
+
+	pipelineClient.PrependReactor("create", "pipelineruns", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("PipelineRun create failed")
+	})
+	if code := createBuild("Pipeline1"); code != 500 {
+		t.Errorf("FAIL: a failed PipelineRun create should have given a 500, got %d", code)
+	}
+
+	resources, _ := r.PipelineClient.TektonV1alpha1().PipelineResources("ns1").List(metav1.ListOptions{})
+	if len(resources.Items) != 0 {
+		t.Errorf("FAIL: the PipelineResources should have been deleted, got %v", resources.Items)
+	}
+}
+
+/* Rerun test: a new PipelineRun with the original's inputs and any overrides should be created */
+
+func TestRerunPipelineRun(t *testing.T) {
+	t.Log("Testing a rerun request creates a new PipelineRun and returns a 201")
+
+	r := dummyResource()
+
+	pipelineRun1 := v1alpha1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "PipelineRun1",
+			Labels: map[string]string{gitRepoLabel: "bar"},
+		},
+		Spec: v1alpha1.PipelineRunSpec{
+			PipelineRef:    v1alpha1.PipelineRef{Name: "Pipeline1"},
+			ServiceAccount: "default",
+			Status:         "PipelineRunCancelled",
+			Params: []v1alpha1.Param{
+				{Name: "param1", Value: "value1"},
+				{Name: "param2", Value: "value2"},
+			},
+			Resources: []v1alpha1.PipelineResourceBinding{
+				{Name: "git-source", ResourceRef: v1alpha1.PipelineResourceRef{Name: "GitResource1"}},
+			},
+		},
+	}
+
+	_, err := r.PipelineClient.TektonV1alpha1().PipelineRuns("ns1").Create(&pipelineRun1)
+	if err != nil {
+		t.Errorf("Error creating the PipelineRun for use with TestRerunPipelineRun, error: %s", err)
+	}
+
+	httpWriter := httptest.NewRecorder()
+
+	rerunBody := strings.NewReader(`{"params": [{"name": "param2", "value": "override"}],
+		"resources": [{"name": "git-source", "resourceRef": {"name": "GitResource2"}}]}`)
+	rerunRequest := dummyHttpRequest("POST", "http://wwww.dummy.com:8383/v1/namespaces/ns1/pipelinerun/PipelineRun1/rerun", rerunBody)
+	rerunRequestRestful := dummyRestfulRequest(rerunRequest, "ns1", "PipelineRun1")
+	resp := dummyRestfulResponse(httpWriter)
+	r.rerunPipelineRun(rerunRequestRestful, resp)
+
+	if resp.StatusCode() != 201 {
+		t.Fatalf("FAIL: should have been recognised as a 201, got %d: %s", resp.StatusCode(), httpWriter.Body.String())
+	}
+
+	result := v1alpha1.PipelineRun{}
+	json.NewDecoder(httpWriter.Body).Decode(&result)
+
+	if result.Name == "PipelineRun1" || !strings.HasPrefix(result.Name, "PipelineRun1-rerun-") {
+		t.Errorf("Rerun PipelineRun has an unexpected name: %s", result.Name)
+	}
+	if result.Labels[rerunOfLabel] != "PipelineRun1" || result.Labels[gitRepoLabel] != "bar" {
+		t.Errorf("Rerun PipelineRun does not have the expected labels: %v", result.Labels)
+	}
+	if result.Spec.Status != "" {
+		t.Errorf("Rerun PipelineRun should not be cancelled, status: %s", result.Spec.Status)
+	}
+	if result.Spec.PipelineRef.Name != "Pipeline1" || result.Spec.ServiceAccount != "default" {
+		t.Errorf("Rerun PipelineRun spec was not copied: %v", result.Spec)
+	}
+	if len(result.Spec.Params) != 2 || result.Spec.Params[0].Value != "value1" || result.Spec.Params[1].Value != "override" {
+		t.Errorf("Rerun PipelineRun params were not overridden: %v", result.Spec.Params)
+	}
+	if result.Spec.Resources[0].ResourceRef.Name != "GitResource2" {
+		t.Errorf("Rerun PipelineRun resources were not overridden: %v", result.Spec.Resources)
+	}
+
+	// Rerunning the rerun generates another name from the original's
+	httpWriter = httptest.NewRecorder()
+	rerunRequest = dummyHttpRequest("POST", "http://wwww.dummy.com:8383/v1/namespaces/ns1/pipelinerun/"+result.Name+"/rerun", strings.NewReader(""))
+	resp = dummyRestfulResponse(httpWriter)
+	r.rerunPipelineRun(dummyRestfulRequest(rerunRequest, "ns1", result.Name), resp)
+
+	rerunResult := v1alpha1.PipelineRun{}
+	json.NewDecoder(httpWriter.Body).Decode(&rerunResult)
+	if resp.StatusCode() != 201 || rerunResult.GenerateName != "PipelineRun1-rerun-" || rerunResult.Name == result.Name {
+		t.Errorf("FAIL: a rerun of the rerun should have been created with a new name, got %d: %s", resp.StatusCode(), rerunResult.Name)
+	}
+
+	// Binding a resource the original PipelineRun doesn't bind is a bad request
+	httpWriter = httptest.NewRecorder()
+	badRequestBody := strings.NewReader(`{"resources": [{"name": "not-a-resource", "resourceRef": {"name": "GitResource2"}}]}`)
+	badRequest := dummyHttpRequest("POST", "http://wwww.dummy.com:8383/v1/namespaces/ns1/pipelinerun/PipelineRun1/rerun", badRequestBody)
+	badRequestRestful := dummyRestfulRequest(badRequest, "ns1", "PipelineRun1")
+	resp = dummyRestfulResponse(httpWriter)
+	r.rerunPipelineRun(badRequestRestful, resp)
+
+	if resp.StatusCode() != 400 {
+		t.Errorf("FAIL: should have been recognised as a bad request, got %d", resp.StatusCode())
+	}
+
+	// Rerunning a PipelineRun that doesn't exist is a 404
+	httpWriter = httptest.NewRecorder()
+	notFoundRequest := dummyHttpRequest("POST", "http://wwww.dummy.com:8383/v1/namespaces/ns1/pipelinerun/IDoNotExist/rerun", nil)
+	notFoundRestful := dummyRestfulRequest(notFoundRequest, "ns1", "IDoNotExist")
+	resp = dummyRestfulResponse(httpWriter)
+	r.rerunPipelineRun(notFoundRestful, resp)
+
+	if resp.StatusCode() != 404 {
+		t.Errorf("FAIL: should have been recognised as a 404, got %d", resp.StatusCode())
+	}
+
+	// A PipelineRun that already exists with the generated name is a conflict
+	pipelineClient := dummyClientset()
+	pipelineClient.TektonV1alpha1().PipelineRuns("ns1").Create(&pipelineRun1)
+	pipelineClient.PrependReactor("create", "pipelineruns", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, k8serrors.NewAlreadyExists(v1alpha1.Resource("pipelineruns"), "PipelineRun1-rerun-abcde")
+	})
+	r.PipelineClient = pipelineClient
+	httpWriter = httptest.NewRecorder()
+	rerunRequest = dummyHttpRequest("POST", "http://wwww.dummy.com:8383/v1/namespaces/ns1/pipelinerun/PipelineRun1/rerun", strings.NewReader(""))
+	resp = dummyRestfulResponse(httpWriter)
+	r.rerunPipelineRun(dummyRestfulRequest(rerunRequest, "ns1", "PipelineRun1"), resp)
+
+	if resp.StatusCode() != 409 {
+		t.Errorf("FAIL: should have been recognised as a 409, got %d", resp.StatusCode())
+	}
+}
+
+/* TaskRun cancel test: 400 for a bad status, 404 when not found, 204 when cancelled and 412 when already cancelled */
+
+func TestTaskRunUpdate(t *testing.T) {
+	t.Log("Testing cancelling a TaskRun")
+
+	r := dummyResource()
+
+	taskRun1 := v1alpha1.TaskRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "TaskRun1",
+		},
+		Spec: v1alpha1.TaskRunSpec{},
+		Status: v1alpha1.TaskRunStatus{
+			PodName: "Pod1",
+		},
+	}
+
+	_, err := r.PipelineClient.TektonV1alpha1().TaskRuns("ns1").Create(&taskRun1)
+	if err != nil {
+		t.Errorf("Error creating the TaskRun for use with TestTaskRunUpdate error: %s", err)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		statusCode int
+	}{
+		{"TaskRun1", `{"notstatus" : "foo"}`, 400},
+		{"TaskRun1", `{"status" : "PipelineRunCancelled"}`, 400},
+		{"IDoNotExist", `{"status" : "TaskRunCancelled"}`, 404},
+		{"TaskRun1", `{"status" : "TaskRunCancelled"}`, 204},
+		// It's already set to be cancelled, so doing it again should give us a 412 response (pre-condition failed)
+		{"TaskRun1", `{"status" : "TaskRunCancelled"}`, 412},
+	}
+	for _, test := range tests {
+		httpWriter := httptest.NewRecorder()
+		httpReq := dummyHttpRequest("PUT", "http://wwww.dummy.com:8383/v1/namespaces/ns1/taskrun/"+test.name, strings.NewReader(test.body))
+		req := dummyRestfulRequest(httpReq, "ns1", test.name)
+		resp := dummyRestfulResponse(httpWriter)
+		r.updateTaskRun(req, resp)
+
+		if resp.StatusCode() != test.statusCode {
+			t.Errorf("FAIL: %s for %s should have given a %d, got %d: %s", test.body, test.name, test.statusCode, resp.StatusCode(), httpWriter.Body.String())
+		}
+	}
+
+	taskRun, err := r.PipelineClient.TektonV1alpha1().TaskRuns("ns1").Get("TaskRun1", metav1.GetOptions{})
+	if err != nil || taskRun.Spec.Status != "TaskRunCancelled" {
+		t.Errorf("TaskRun1 was not cancelled: %v", err)
+	}
+}
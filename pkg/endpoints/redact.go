@@ -0,0 +1,156 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"io"
+	"sort"
+	"strings"
+
+	logging "github.com/tektoncd/dashboard/pkg/logging"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Shown in place of credential values
+const redactedValue = "********"
+
+// Values shorter than this aren't redacted, masking every occurrence of a short value would mask ordinary words
+const minRedactedLength = 4
+
+// Keys of the secret values that are redacted from logs
+var redactedSecretKeys = []string{"password", "token"}
+
+// Masks credential values in logs
+type logRedactor struct {
+	// Nil if there's nothing to redact
+	replacer *strings.Replacer
+}
+
+/* Get a redactor for the logs of a run in a given namespace, masking the values of the secrets managed through the
+ * credentials API and of the secrets of the service account the run uses.
+ * Secrets that can't be read are logged and left out rather than failing the request.
+ */
+func (r Resource) getLogRedactor(namespace, serviceAccount string) *logRedactor {
+	var values []string
+	addSecret := func(secret *corev1.Secret) {
+		for _, key := range redactedSecretKeys {
+			if value, ok := secret.Data[key]; ok {
+				values = append(values, string(value))
+			}
+		}
+	}
+
+	secrets, err := r.K8sClient.CoreV1().Secrets(namespace).List(metav1.ListOptions{LabelSelector: LABEL_SELECTOR})
+	if err != nil {
+		logging.Log.Errorf("Error getting the credentials to redact from logs in namespace %s: %s", namespace, err)
+	} else {
+		for i := range secrets.Items {
+			addSecret(&secrets.Items[i])
+		}
+	}
+
+	// Runs without a service account use the namespace's default one
+	if serviceAccount == "" {
+		serviceAccount = "default"
+	}
+	account, err := r.K8sClient.CoreV1().ServiceAccounts(namespace).Get(serviceAccount, metav1.GetOptions{})
+	if err != nil {
+		logging.Log.Debugf("Could not get service account %s to redact its secrets from logs: %s", serviceAccount, err)
+	} else {
+		for _, reference := range account.Secrets {
+			secret, err := r.K8sClient.CoreV1().Secrets(namespace).Get(reference.Name, metav1.GetOptions{})
+			if err != nil {
+				logging.Log.Debugf("Could not get secret %s of service account %s to redact from logs: %s", reference.Name, serviceAccount, err)
+				continue
+			}
+			addSecret(secret)
+		}
+	}
+	return newLogRedactor(values)
+}
+
+func newLogRedactor(values []string) *logRedactor {
+	unique := make(map[string]struct{})
+	for _, value := range values {
+		if len(value) >= minRedactedLength {
+			unique[value] = struct{}{}
+		}
+	}
+	if len(unique) == 0 {
+		return &logRedactor{}
+	}
+	var sorted []string
+	for value := range unique {
+		sorted = append(sorted, value)
+	}
+	// Longest first, so a value that contains another is masked whole
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) > len(sorted[j])
+		}
+		return sorted[i] < sorted[j]
+	})
+	var oldNew []string
+	for _, value := range sorted {
+		oldNew = append(oldNew, value, redactedValue)
+	}
+	return &logRedactor{replacer: strings.NewReplacer(oldNew...)}
+}
+
+func (lr *logRedactor) redact(line string) string {
+	if lr == nil || lr.replacer == nil {
+		return line
+	}
+	return lr.replacer.Replace(line)
+}
+
+/* Wrap a logOpener so every line read is redacted. Lines are redacted whole so a value is never split between reads.
+ * Closing the log stops the redaction, including of a followed log
+ */
+func (lr *logRedactor) redactLogs(open logOpener) logOpener {
+	if lr == nil || lr.replacer == nil {
+		return open
+	}
+	return func(container string) (io.ReadCloser, bool) {
+		podLogs, ok := open(container)
+		if !ok {
+			return nil, false
+		}
+		reader, writer := io.Pipe()
+		counter := &countingReader{reader: podLogs}
+		go func() {
+			defer podLogs.Close()
+			writer.CloseWithError(scanLogLines(counter, func(line string) {
+				io.WriteString(writer, lr.redact(line)+"\n")
+			}))
+		}()
+		return &redactedLog{PipeReader: reader, podLogs: podLogs, counter: counter}, true
+	}
+}
+
+type redactedLog struct {
+	*io.PipeReader
+	podLogs io.Closer
+	counter *countingReader
+}
+
+// The bytes read before redaction. Only known once the redacted log has been read to the end
+func (l *redactedLog) bytesRead() int64 {
+	return l.counter.bytesRead()
+}
+
+func (l *redactedLog) Close() error {
+	l.PipeReader.Close()
+	return l.podLogs.Close()
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"io/ioutil"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+/* Redaction test: credentials and the secrets of the run's service account are masked, other secrets aren't */
+
+func TestGetLogRedactor(t *testing.T) {
+	t.Log("Testing the secrets redacted from logs")
+
+	r := dummyResource()
+	secrets := []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "credential1", Namespace: "ns1", Labels: map[string]string{"restknative": "true"}},
+			Data:       map[string][]byte{"username": []byte("someone"), "password": []byte("hunter22")},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "builder-token", Namespace: "ns1"},
+			Data:       map[string][]byte{"token": []byte("abcd-token")},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "unrelated", Namespace: "ns1"},
+			Data:       map[string][]byte{"password": []byte("not-used-here")},
+		},
+	}
+	for i := range secrets {
+		r.K8sClient.CoreV1().Secrets("ns1").Create(&secrets[i])
+	}
+	account := corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "builder", Namespace: "ns1"},
+		Secrets:    []corev1.ObjectReference{{Name: "builder-token"}},
+	}
+	r.K8sClient.CoreV1().ServiceAccounts("ns1").Create(&account)
+
+	redactor := r.getLogRedactor("ns1", "builder")
+	line := "someone logged in with hunter22 and abcd-token, not-used-here"
+	expected := "someone logged in with ******** and ********, not-used-here"
+	if redacted := redactor.redact(line); redacted != expected {
+		t.Errorf("FAIL: expected %q, got %q", expected, redacted)
+	}
+
+	redactor = r.getLogRedactor("ns1", "")
+	expected = "someone logged in with ******** and abcd-token, not-used-here"
+	if redacted := redactor.redact(line); redacted != expected {
+		t.Errorf("FAIL: without the service account expected %q, got %q", expected, redacted)
+	}
+}
+
+/* Redaction test: short values are left alone, values containing others are masked whole */
+
+func TestNewLogRedactor(t *testing.T) {
+	t.Log("Testing which values are redacted")
+
+	redactor := newLogRedactor([]string{"abc", "token", "token-extended", ""})
+	tests := map[string]string{
+		"abc def":              "abc def",
+		"the token is here":    "the ******** is here",
+		"token-extended token": "******** ********",
+	}
+	for line, expected := range tests {
+		if redacted := redactor.redact(line); redacted != expected {
+			t.Errorf("FAIL: expected %q, got %q", expected, redacted)
+		}
+	}
+
+	var none *logRedactor
+	if none.redact("token") != "token" || newLogRedactor(nil).redact("token") != "token" {
+		t.Errorf("FAIL: nothing should have been redacted without values")
+	}
+}
+
+/* Redaction test: logs opened through a redactor are redacted a line at a time */
+
+func TestRedactLogs(t *testing.T) {
+	t.Log("Testing opened logs are redacted")
+
+	redactor := newLogRedactor([]string{"hunter22"})
+	open := redactor.redactLogs(dummyLogOpener(map[string]string{"build-step-one": "login hunter22\nno secret"}))
+	podLogs, ok := open("build-step-one")
+	if !ok {
+		t.Fatalf("FAIL: the log should have been opened")
+	}
+	data, _ := ioutil.ReadAll(podLogs)
+	podLogs.Close()
+	if string(data) != "login ********\nno secret\n" {
+		t.Errorf("FAIL: the log should have been redacted, got %q", data)
+	}
+	if _, ok := open("build-step-two"); ok {
+		t.Errorf("FAIL: a log that can't be opened should not be opened through the redactor")
+	}
+}
@@ -0,0 +1,259 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	restful "github.com/emicklei/go-restful"
+	duckv1alpha1 "github.com/knative/pkg/apis/duck/v1alpha1"
+	logging "github.com/tektoncd/dashboard/pkg/logging"
+	"github.com/tektoncd/dashboard/pkg/utils"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PipelineRunRetryResponse - describes the PipelineRun created to retry the failed tasks of another
+type PipelineRunRetryResponse struct {
+	PIPELINERUN *v1alpha1.PipelineRun `json:"pipelinerun"`
+	// The Pipeline created containing only the tasks to be rerun
+	PIPELINE    string   `json:"pipeline"`
+	REUSEDTASKS []string `json:"reusedtasks"`
+	RERUNTASKS  []string `json:"reruntasks"`
+}
+
+const retryOfLabel = "retryOf"
+
+/* Retry a given PipelineRun by name in a given namespace, rerunning only the tasks that did not succeed
+ * and those that come after them. Tasks which succeeded and nothing rerun depends on are reused.
+ * As Tekton has no way of skipping tasks, a Pipeline containing only the tasks to rerun is created
+ * and the new PipelineRun references it. The Pipeline is owned by the new PipelineRun, and by any reruns of it,
+ * so is deleted once they all are.
+ */
+func (r Resource) retryPipelineRun(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In retryPipelineRun, name: %s, namespace: %s", name, namespace)
+
+	pipelineRuns := r.PipelineClient.TektonV1alpha1().PipelineRuns(namespace)
+	pipelineRun, err := pipelineRuns.Get(name, metav1.GetOptions{})
+	if err != nil || pipelineRun == nil {
+		utils.RespondError(response, err, http.StatusNotFound)
+		return
+	}
+
+	condition := pipelineRun.Status.GetCondition(duckv1alpha1.ConditionSucceeded)
+	if condition == nil || condition.Status == corev1.ConditionUnknown {
+		errorMsg := fmt.Sprintf("error: PipelineRun %s has not completed, only completed PipelineRuns can be retried", name)
+		utils.RespondErrorMessage(response, errorMsg, http.StatusPreconditionFailed)
+		return
+	}
+
+	pipeline, err := r.getPipelineImpl(pipelineRun.Spec.PipelineRef.Name, namespace)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			utils.RespondError(response, err, http.StatusNotFound)
+		} else {
+			utils.RespondError(response, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	retryPipeline, reusedTasks, rerunTasks, err := defineRetryPipeline(pipeline, pipelineRun)
+	if err != nil {
+		if k8serrors.IsBadRequest(err) {
+			utils.RespondError(response, err, http.StatusBadRequest)
+		} else {
+			utils.RespondError(response, err, http.StatusPreconditionFailed)
+		}
+		return
+	}
+
+	pipelines := r.PipelineClient.TektonV1alpha1().Pipelines(namespace)
+	retryPipeline, err = pipelines.Create(retryPipeline)
+	if err != nil {
+		respondWriteError(response, "retry Pipeline", err)
+		return
+	}
+
+	newPipelineRun, err := definePipelineRunRerun(pipelineRun, PipelineRunRerunBody{})
+	if err != nil {
+		utils.RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	newPipelineRun.Spec.PipelineRef.Name = retryPipeline.Name
+	newPipelineRun.Labels[retryOfLabel] = name
+
+	createdPipelineRun, err := pipelineRuns.Create(newPipelineRun)
+	if err != nil {
+		if err := pipelines.Delete(retryPipeline.Name, &metav1.DeleteOptions{}); err != nil {
+			logging.Log.Errorf("error deleting retry Pipeline %s: %s", retryPipeline.Name, err)
+		}
+		respondWriteError(response, "retry of PipelineRun "+name, err)
+		return
+	}
+
+	// The PipelineRun needs its Pipeline to exist when it is created, so the Pipeline can only be given its owner now.
+	// Without an owner the Pipeline would never be deleted, so nothing is kept if it can't be given one
+	if err := r.ownRetryPipeline(createdPipelineRun); err != nil {
+		if err := pipelineRuns.Delete(createdPipelineRun.Name, &metav1.DeleteOptions{}); err != nil {
+			logging.Log.Errorf("error deleting PipelineRun %s: %s", createdPipelineRun.Name, err)
+		}
+		if err := pipelines.Delete(retryPipeline.Name, &metav1.DeleteOptions{}); err != nil {
+			logging.Log.Errorf("error deleting retry Pipeline %s: %s", retryPipeline.Name, err)
+		}
+		respondWriteError(response, "owner of retry Pipeline "+retryPipeline.Name, err)
+		return
+	}
+	logging.Log.Debugf("Created PipelineRun %s retrying %v of %s", createdPipelineRun.Name, rerunTasks, name)
+
+	retryResponse := PipelineRunRetryResponse{
+		PIPELINERUN: createdPipelineRun,
+		PIPELINE:    retryPipeline.Name,
+		REUSEDTASKS: reusedTasks,
+		RERUNTASKS:  rerunTasks,
+	}
+	response.WriteHeaderAndEntity(http.StatusCreated, retryResponse)
+}
+
+/* Retry Pipelines are owned by the PipelineRuns that run them, so they are deleted once none of them are left.
+ * Adds the PipelineRun as an owner of the Pipeline it runs if that is a retry Pipeline, which a rerun of a retry PipelineRun runs too.
+ * Nothing is done for other Pipelines, or if the Pipeline doesn't exist
+ */
+func (r Resource) ownRetryPipeline(pipelineRun *v1alpha1.PipelineRun) error {
+	pipelineName := pipelineRun.Spec.PipelineRef.Name
+	if pipelineName == "" {
+		return nil
+	}
+	pipelines := r.PipelineClient.TektonV1alpha1().Pipelines(pipelineRun.Namespace)
+	pipeline, err := pipelines.Get(pipelineName, metav1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if _, ok := pipeline.Labels[retryOfLabel]; !ok {
+		return nil
+	}
+	pipeline.OwnerReferences = append(pipeline.OwnerReferences, metav1.OwnerReference{
+		APIVersion: v1alpha1.SchemeGroupVersion.String(),
+		Kind:       "PipelineRun",
+		Name:       pipelineRun.Name,
+		UID:        pipelineRun.UID,
+	})
+	_, err = pipelines.Update(pipeline)
+	return err
+}
+
+/* Create a copy of the Pipeline with the succeeded tasks of the PipelineRun removed, where nothing rerun depends on them.
+ * runAfter references to removed tasks are dropped. The outputs of a reused task aren't kept once its PipelineRun
+ * has completed, so a rerun task taking resources from one can't be retried and a bad request error is returned.
+ */
+func defineRetryPipeline(pipeline v1alpha1.Pipeline, pipelineRun *v1alpha1.PipelineRun) (*v1alpha1.Pipeline, []string, []string, error) {
+	succeeded := make(map[string]bool)
+	for _, taskRunStatus := range pipelineRun.Status.TaskRuns {
+		if taskRunStatus.Status == nil {
+			continue
+		}
+		condition := taskRunStatus.Status.GetCondition(duckv1alpha1.ConditionSucceeded)
+		if condition != nil && condition.Status == corev1.ConditionTrue {
+			succeeded[taskRunStatus.PipelineTaskName] = true
+		}
+	}
+
+	// Anything that didn't succeed is rerun, as is anything that runs after or takes resources from a rerun task
+	rerun := make(map[string]bool)
+	for _, task := range pipeline.Spec.Tasks {
+		if !succeeded[task.Name] {
+			rerun[task.Name] = true
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, task := range pipeline.Spec.Tasks {
+			if rerun[task.Name] {
+				continue
+			}
+			for _, dependency := range getTaskDependencies(task) {
+				if rerun[dependency] {
+					rerun[task.Name] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	var reusedTasks, rerunTasks []string
+	var tasks []v1alpha1.PipelineTask
+	for _, task := range pipeline.Spec.Tasks {
+		if !rerun[task.Name] {
+			reusedTasks = append(reusedTasks, task.Name)
+			continue
+		}
+		rerunTasks = append(rerunTasks, task.Name)
+		task = *task.DeepCopy()
+		var runAfter []string
+		for _, dependency := range task.RunAfter {
+			if rerun[dependency] {
+				runAfter = append(runAfter, dependency)
+			}
+		}
+		task.RunAfter = runAfter
+		if task.Resources != nil {
+			for _, input := range task.Resources.Inputs {
+				for _, dependency := range input.From {
+					if !rerun[dependency] {
+						errorMsg := fmt.Sprintf("error: task %s of PipelineRun %s takes resource %s from task %s, which succeeded and would not be rerun, so can't be retried",
+							task.Name, pipelineRun.Name, input.Name, dependency)
+						return nil, nil, nil, k8serrors.NewBadRequest(errorMsg)
+					}
+				}
+			}
+		}
+		tasks = append(tasks, task)
+	}
+
+	if len(rerunTasks) == 0 {
+		errorMsg := fmt.Sprintf("error: every task of PipelineRun %s succeeded, there is nothing to retry", pipelineRun.Name)
+		return nil, nil, nil, errors.New(errorMsg)
+	}
+
+	spec := pipeline.Spec.DeepCopy()
+	spec.Tasks = tasks
+	retryPipeline := v1alpha1.Pipeline{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: pipeline.Name + "-retry-",
+			Namespace:    pipelineRun.Namespace,
+			Labels:       map[string]string{retryOfLabel: pipelineRun.Name},
+		},
+		Spec: *spec,
+	}
+	return &retryPipeline, reusedTasks, rerunTasks, nil
+}
+
+// Returns the names of the tasks a PipelineTask must run after, through runAfter or from
+func getTaskDependencies(task v1alpha1.PipelineTask) []string {
+	dependencies := append([]string{}, task.RunAfter...)
+	if task.Resources != nil {
+		for _, input := range task.Resources.Inputs {
+			dependencies = append(dependencies, input.From...)
+		}
+	}
+	return dependencies
+}
@@ -0,0 +1,287 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	duckv1alpha1 "github.com/knative/pkg/apis/duck/v1alpha1"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// Returns a TaskRunStatus with the Succeeded condition set to the given status
+func taskRunStatusWithCondition(status corev1.ConditionStatus) *v1alpha1.TaskRunStatus {
+	taskRunStatus := &v1alpha1.TaskRunStatus{}
+	taskRunStatus.SetCondition(&duckv1alpha1.Condition{
+		Type:   duckv1alpha1.ConditionSucceeded,
+		Status: status,
+	})
+	return taskRunStatus
+}
+
+/* Retry test: only the failed task and the tasks after it should be rerun */
+
+func TestRetryPipelineRun(t *testing.T) {
+	t.Log("Testing a retry request reruns only the failed tasks and returns a 201")
+
+	r := dummyResource()
+
+	// build -> test -> deploy, with lint running alongside
+	pipeline1 := v1alpha1.Pipeline{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "Pipeline1",
+		},
+		Spec: v1alpha1.PipelineSpec{
+			Tasks: []v1alpha1.PipelineTask{
+				{Name: "build", TaskRef: v1alpha1.TaskRef{Name: "Task1"}},
+				{Name: "lint", TaskRef: v1alpha1.TaskRef{Name: "Task2"}},
+				{Name: "test", TaskRef: v1alpha1.TaskRef{Name: "Task3"}, RunAfter: []string{"build"}},
+				{
+					Name:    "deploy",
+					TaskRef: v1alpha1.TaskRef{Name: "Task4"},
+					Resources: &v1alpha1.PipelineTaskResources{
+						Inputs: []v1alpha1.PipelineTaskInputResource{
+							{Name: "image", Resource: "docker-image", From: []string{"test"}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	pipelineRun1 := v1alpha1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "PipelineRun1",
+		},
+		Spec: v1alpha1.PipelineRunSpec{
+			PipelineRef: v1alpha1.PipelineRef{Name: "Pipeline1"},
+		},
+		Status: v1alpha1.PipelineRunStatus{
+			TaskRuns: map[string]*v1alpha1.PipelineRunTaskRunStatus{
+				"PipelineRun1-build": {PipelineTaskName: "build", Status: taskRunStatusWithCondition(corev1.ConditionTrue)},
+				"PipelineRun1-lint":  {PipelineTaskName: "lint", Status: taskRunStatusWithCondition(corev1.ConditionTrue)},
+				"PipelineRun1-test":  {PipelineTaskName: "test", Status: taskRunStatusWithCondition(corev1.ConditionFalse)},
+			},
+		},
+	}
+	pipelineRun1.Status.SetCondition(&duckv1alpha1.Condition{
+		Type:   duckv1alpha1.ConditionSucceeded,
+		Status: corev1.ConditionFalse,
+	})
+
+	_, err := r.PipelineClient.TektonV1alpha1().Pipelines("ns1").Create(&pipeline1)
+	if err != nil {
+		t.Errorf("Error creating the Pipeline for use with TestRetryPipelineRun, error: %s", err)
+	}
+	_, err = r.PipelineClient.TektonV1alpha1().PipelineRuns("ns1").Create(&pipelineRun1)
+	if err != nil {
+		t.Errorf("Error creating the PipelineRun for use with TestRetryPipelineRun, error: %s", err)
+	}
+
+	httpWriter := httptest.NewRecorder()
+	retryRequest := dummyHttpRequest("POST", "http://wwww.dummy.com:8383/v1/namespaces/ns1/pipelinerun/PipelineRun1/retry", nil)
+	retryRequestRestful := dummyRestfulRequest(retryRequest, "ns1", "PipelineRun1")
+	resp := dummyRestfulResponse(httpWriter)
+	r.retryPipelineRun(retryRequestRestful, resp)
+
+	if resp.StatusCode() != 201 {
+		t.Fatalf("FAIL: should have been recognised as a 201, got %d: %s", resp.StatusCode(), httpWriter.Body.String())
+	}
+
+	result := PipelineRunRetryResponse{}
+	json.NewDecoder(httpWriter.Body).Decode(&result)
+
+	if !reflect.DeepEqual(result.REUSEDTASKS, []string{"build", "lint"}) {
+		t.Errorf("Reused tasks: expected: %v, returned: %v", []string{"build", "lint"}, result.REUSEDTASKS)
+	}
+	if !reflect.DeepEqual(result.RERUNTASKS, []string{"test", "deploy"}) {
+		t.Errorf("Rerun tasks: expected: %v, returned: %v", []string{"test", "deploy"}, result.RERUNTASKS)
+	}
+	if result.PIPELINERUN == nil || result.PIPELINERUN.Spec.PipelineRef.Name != result.PIPELINE {
+		t.Fatalf("Retry PipelineRun does not reference the retry Pipeline %s", result.PIPELINE)
+	}
+	if result.PIPELINERUN.Labels[retryOfLabel] != "PipelineRun1" {
+		t.Errorf("Retry PipelineRun does not have the expected labels: %v", result.PIPELINERUN.Labels)
+	}
+
+	retryPipeline, err := r.PipelineClient.TektonV1alpha1().Pipelines("ns1").Get(result.PIPELINE, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Retry Pipeline %s was not created: %s", result.PIPELINE, err)
+	}
+	if retryPipeline.GenerateName != "Pipeline1-retry-" {
+		t.Errorf("Retry Pipeline name should have been generated from Pipeline1-retry-, got %s", retryPipeline.Name)
+	}
+	if len(retryPipeline.OwnerReferences) != 1 || retryPipeline.OwnerReferences[0].Kind != "PipelineRun" ||
+		retryPipeline.OwnerReferences[0].Name != result.PIPELINERUN.Name {
+		t.Errorf("Retry Pipeline should be owned by the retry PipelineRun: %v", retryPipeline.OwnerReferences)
+	}
+	if len(retryPipeline.Spec.Tasks) != 2 {
+		t.Fatalf("Number of retry Pipeline tasks: expected: %d, returned: %d", 2, len(retryPipeline.Spec.Tasks))
+	}
+	if len(retryPipeline.Spec.Tasks[0].RunAfter) != 0 {
+		t.Errorf("test should no longer run after the reused build task: %v", retryPipeline.Spec.Tasks[0].RunAfter)
+	}
+	if from := retryPipeline.Spec.Tasks[1].Resources.Inputs[0].From; !reflect.DeepEqual(from, []string{"test"}) {
+		t.Errorf("deploy should only take resources from test: %v", from)
+	}
+
+	// A rerun of the retry runs the same Pipeline, so must also own it
+	httpWriter = httptest.NewRecorder()
+	rerunRequest := dummyHttpRequest("POST", "http://wwww.dummy.com:8383/v1/namespaces/ns1/pipelinerun/"+result.PIPELINERUN.Name+"/rerun", strings.NewReader(""))
+	resp = dummyRestfulResponse(httpWriter)
+	r.rerunPipelineRun(dummyRestfulRequest(rerunRequest, "ns1", result.PIPELINERUN.Name), resp)
+	if resp.StatusCode() != 201 {
+		t.Fatalf("FAIL: the rerun of the retry should have been recognised as a 201, got %d: %s", resp.StatusCode(), httpWriter.Body.String())
+	}
+	rerun := v1alpha1.PipelineRun{}
+	json.NewDecoder(httpWriter.Body).Decode(&rerun)
+	retryPipeline, _ = r.PipelineClient.TektonV1alpha1().Pipelines("ns1").Get(result.PIPELINE, metav1.GetOptions{})
+	if len(retryPipeline.OwnerReferences) != 2 || retryPipeline.OwnerReferences[1].Name != rerun.Name {
+		t.Errorf("Retry Pipeline should be owned by the retry PipelineRun and its rerun: %v", retryPipeline.OwnerReferences)
+	}
+}
+
+/* Retry where a rerun task takes resources from a reused task: 400 returned, and nothing left behind when the PipelineRun can't be created
+ * or the retry Pipeline can't be given its owner
+ */
+
+func TestRetryPipelineRunFailure(t *testing.T) {
+	t.Log("Testing a retry request that can't be satisfied creates nothing")
+
+	r := dummyResource()
+	pipelineClient := dummyClientset()
+	r.PipelineClient = pipelineClient
+
+	pipeline1 := v1alpha1.Pipeline{
+		ObjectMeta: metav1.ObjectMeta{Name: "Pipeline1"},
+		Spec: v1alpha1.PipelineSpec{
+			Tasks: []v1alpha1.PipelineTask{
+				{Name: "build", TaskRef: v1alpha1.TaskRef{Name: "Task1"}},
+				{
+					Name:    "deploy",
+					TaskRef: v1alpha1.TaskRef{Name: "Task2"},
+					Resources: &v1alpha1.PipelineTaskResources{
+						Inputs: []v1alpha1.PipelineTaskInputResource{
+							{Name: "image", Resource: "docker-image", From: []string{"build"}},
+						},
+					},
+				},
+			},
+		},
+	}
+	pipelineRun1 := v1alpha1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "PipelineRun1"},
+		Spec:       v1alpha1.PipelineRunSpec{PipelineRef: v1alpha1.PipelineRef{Name: "Pipeline1"}},
+		Status: v1alpha1.PipelineRunStatus{
+			TaskRuns: map[string]*v1alpha1.PipelineRunTaskRunStatus{
+				"PipelineRun1-build":  {PipelineTaskName: "build", Status: taskRunStatusWithCondition(corev1.ConditionTrue)},
+				"PipelineRun1-deploy": {PipelineTaskName: "deploy", Status: taskRunStatusWithCondition(corev1.ConditionFalse)},
+			},
+		},
+	}
+	pipelineRun1.Status.SetCondition(&duckv1alpha1.Condition{
+		Type:   duckv1alpha1.ConditionSucceeded,
+		Status: corev1.ConditionFalse,
+	})
+	pipelineClient.TektonV1alpha1().Pipelines("ns1").Create(&pipeline1)
+	pipelineClient.TektonV1alpha1().PipelineRuns("ns1").Create(&pipelineRun1)
+
+	retry := func() int {
+		httpWriter := httptest.NewRecorder()
+		retryRequest := dummyHttpRequest("POST", "http://wwww.dummy.com:8383/v1/namespaces/ns1/pipelinerun/PipelineRun1/retry", nil)
+		resp := dummyRestfulResponse(httpWriter)
+		r.retryPipelineRun(dummyRestfulRequest(retryRequest, "ns1", "PipelineRun1"), resp)
+		return resp.StatusCode()
+	}
+
+	if code := retry(); code != 400 {
+		t.Errorf("FAIL: deploy takes its image from the reused build task, should have been a 400, got %d", code)
+	}
+
+	// Fail build too, so the retry is valid but its PipelineRun can't be created
+	pipelineRun1.Status.TaskRuns["PipelineRun1-build"].Status = taskRunStatusWithCondition(corev1.ConditionFalse)
+	pipelineClient.TektonV1alpha1().PipelineRuns("ns1").Update(&pipelineRun1)
+	pipelineClient.PrependReactor("create", "pipelineruns", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("PipelineRun create failed")
+	})
+	if code := retry(); code != 500 {
+		t.Errorf("FAIL: a failed PipelineRun create should have given a 500, got %d", code)
+	}
+
+	pipelines, _ := pipelineClient.TektonV1alpha1().Pipelines("ns1").List(metav1.ListOptions{})
+	if len(pipelines.Items) != 1 {
+		t.Errorf("FAIL: only Pipeline1 should exist, got %v", pipelines.Items)
+	}
+
+	// The PipelineRun is created but the retry Pipeline can't be given its owner
+	pipelineClient = dummyClientset()
+	r.PipelineClient = pipelineClient
+	pipelineClient.TektonV1alpha1().Pipelines("ns1").Create(&pipeline1)
+	pipelineClient.TektonV1alpha1().PipelineRuns("ns1").Create(&pipelineRun1)
+	pipelineClient.PrependReactor("update", "pipelines", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("Pipeline update failed")
+	})
+	if code := retry(); code != 500 {
+		t.Errorf("FAIL: a failed retry Pipeline update should have given a 500, got %d", code)
+	}
+	pipelines, _ = pipelineClient.TektonV1alpha1().Pipelines("ns1").List(metav1.ListOptions{})
+	pipelineRuns, _ := pipelineClient.TektonV1alpha1().PipelineRuns("ns1").List(metav1.ListOptions{})
+	if len(pipelines.Items) != 1 || len(pipelineRuns.Items) != 1 {
+		t.Errorf("FAIL: only Pipeline1 and PipelineRun1 should exist, got %v and %v", pipelines.Items, pipelineRuns.Items)
+	}
+}
+
+/* Retry of a PipelineRun that has not completed: 412 returned */
+
+func TestRetryPipelineRunNotCompleted(t *testing.T) {
+	t.Log("Testing a retry request for a running PipelineRun gives a http 412 response code")
+
+	r := dummyResource()
+
+	pipelineRun1 := v1alpha1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "PipelineRun1",
+		},
+		Spec: v1alpha1.PipelineRunSpec{
+			PipelineRef: v1alpha1.PipelineRef{Name: "Pipeline1"},
+		},
+	}
+	pipelineRun1.Status.SetCondition(&duckv1alpha1.Condition{
+		Type:   duckv1alpha1.ConditionSucceeded,
+		Status: corev1.ConditionUnknown,
+	})
+
+	_, err := r.PipelineClient.TektonV1alpha1().PipelineRuns("ns1").Create(&pipelineRun1)
+	if err != nil {
+		t.Errorf("Error creating the PipelineRun for use with TestRetryPipelineRunNotCompleted, error: %s", err)
+	}
+
+	httpWriter := httptest.NewRecorder()
+	retryRequest := dummyHttpRequest("POST", "http://wwww.dummy.com:8383/v1/namespaces/ns1/pipelinerun/PipelineRun1/retry", nil)
+	retryRequestRestful := dummyRestfulRequest(retryRequest, "ns1", "PipelineRun1")
+	resp := dummyRestfulResponse(httpWriter)
+	r.retryPipelineRun(retryRequestRestful, resp)
+
+	if resp.StatusCode() != 412 {
+		t.Errorf("FAIL: should have received a http 412 code when retrying a running PipelineRun, got %d", resp.StatusCode())
+	}
+}
@@ -18,7 +18,11 @@ import (
 
 	restful "github.com/emicklei/go-restful"
 	fakeclientset "github.com/tektoncd/pipeline/pkg/client/clientset/versioned/fake"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
 	fakek8sclientset "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func dummyK8sClientset() *fakek8sclientset.Clientset {
@@ -28,9 +32,19 @@ func dummyK8sClientset() *fakek8sclientset.Clientset {
 
 func dummyClientset() *fakeclientset.Clientset {
 	result := fakeclientset.NewSimpleClientset()
+	result.PrependReactor("create", "*", generateName)
 	return result
 }
 
+// The fake clientsets don't generate names as the API server does, so fill them in before the object is stored
+func generateName(action k8stesting.Action) (bool, runtime.Object, error) {
+	object, err := meta.Accessor(action.(k8stesting.CreateAction).GetObject())
+	if err == nil && object.GetName() == "" && object.GetGenerateName() != "" {
+		object.SetName(object.GetGenerateName() + utilrand.String(5))
+	}
+	return false, nil, nil
+}
+
 func dummyHttpRequest(method string, url string, body io.Reader) *http.Request {
 	httpReq, _ := http.NewRequest(method, url, body)
 	httpReq.Header.Set("Content-Type", "application/json")
@@ -0,0 +1,378 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	restful "github.com/emicklei/go-restful"
+	logging "github.com/tektoncd/dashboard/pkg/logging"
+	"github.com/tektoncd/dashboard/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Lines in a step log around a JUnit XML report, each on a line of its own
+const (
+	junitStartMarker = "::junit::"
+	junitEndMarker   = "::endjunit::"
+)
+
+// Largest JUnit report read, from a log or a results file
+const maxJUnitReportSize = 10 * 1024 * 1024
+
+// The statuses of a TestCase
+const (
+	testPassed  = "passed"
+	testFailed  = "failed"
+	testError   = "error"
+	testSkipped = "skipped"
+)
+
+// TestSummary - counts of test cases, and how long they took in seconds
+type TestSummary struct {
+	TESTS    int     `json:"tests"`
+	FAILURES int     `json:"failures"`
+	ERRORS   int     `json:"errors"`
+	SKIPPED  int     `json:"skipped"`
+	TIME     float64 `json:"time"`
+}
+
+// TestSuite - a JUnit test suite, nested suites are given as suites of their own
+type TestSuite struct {
+	NAME string `json:"name"`
+	// Where the suite was found: the step container's log or the results file
+	SOURCE string `json:"source"`
+	TestSummary
+	CASES []TestCase `json:"cases"`
+}
+
+// TestCase - a JUnit test case
+type TestCase struct {
+	NAME      string  `json:"name"`
+	CLASSNAME string  `json:"classname,omitempty"`
+	TIME      float64 `json:"time"`
+	// One of passed, failed, error or skipped
+	STATUS string `json:"status"`
+	// For failed, error or skipped test cases, as reported
+	MESSAGE string `json:"message,omitempty"`
+	DETAILS string `json:"details,omitempty"`
+}
+
+// TaskRunTestReport - the test suites found for a TaskRun
+type TaskRunTestReport struct {
+	TASKRUN string `json:"taskrun"`
+	TestSummary
+	SUITES []TestSuite `json:"suites"`
+	// Reports that were found but couldn't be read
+	PROBLEMS []string `json:"problems,omitempty"`
+}
+
+// PipelineRunTestReport - the test suites found for each TaskRun of a PipelineRun
+type PipelineRunTestReport struct {
+	PIPELINERUN string `json:"pipelinerun"`
+	TestSummary
+	TASKRUNS []TaskRunTestReport `json:"taskruns"`
+}
+
+type junitTestSuites struct {
+	Suites []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name   string           `xml:"name,attr"`
+	Time   string           `xml:"time,attr"`
+	Cases  []junitTestCase  `xml:"testcase"`
+	Suites []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Error     *junitMessage `xml:"error"`
+	Skipped   *junitMessage `xml:"skipped"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+/* Get the test results of a given TaskRun by name in a given namespace.
+ * JUnit XML reports are read from between ::junit:: and ::endjunit:: lines in its step logs,
+ * and from the .xml files in namespace/taskrun under the test results directory, if there is one
+ */
+func (r Resource) getTaskRunTestResults(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In getTaskRunTestResults, name: %s, namespace: %s", name, namespace)
+
+	if err := validateResultsPath(namespace, name); err != nil {
+		utils.RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+	podName, uid, serviceAccount := "", "", ""
+	taskRun, err := r.PipelineClient.TektonV1alpha1().TaskRuns(namespace).Get(name, metav1.GetOptions{})
+	if err == nil {
+		podName, uid, serviceAccount = taskRun.Status.PodName, string(taskRun.UID), taskRun.Spec.ServiceAccount
+	}
+	redactor := r.getLogRedactor(namespace, serviceAccount)
+	sources, open, found := r.getTaskRunLogSources(namespace, name, podName, uid, redactor, v1.PodLogOptions{})
+	if err != nil && !found {
+		// The TaskRun may have been deleted since its logs were archived
+		utils.RespondError(response, err, http.StatusNotFound)
+		return
+	}
+
+	report := r.getResultsFileTestReport(namespace, name)
+	if found {
+		addLogTestSuites(&report, sources, open)
+	}
+	response.WriteEntity(report)
+}
+
+/* Get the test results of each TaskRun of a given PipelineRun by name in a given namespace, with totals for the PipelineRun.
+ * See getTaskRunTestResults for where test results are read from
+ */
+func (r Resource) getPipelineRunTestResults(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	namespace := request.PathParameter("namespace")
+	logging.Log.Debugf("In getPipelineRunTestResults, name: %s, namespace: %s", name, namespace)
+
+	if err := validateResultsPath(namespace, name); err != nil {
+		utils.RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+	pipelinerun, err := r.PipelineClient.TektonV1alpha1().PipelineRuns(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		utils.RespondError(response, err, http.StatusNotFound)
+		return
+	}
+
+	reports := make(map[string]*TaskRunTestReport)
+	var taskRunNames []string
+	for key := range pipelinerun.Status.TaskRuns {
+		report := r.getResultsFileTestReport(namespace, key)
+		reports[key] = &report
+		taskRunNames = append(taskRunNames, key)
+	}
+	sort.Strings(taskRunNames)
+	r.forEachTaskRunLog(namespace, pipelinerun, v1.PodLogOptions{}, func(key, podname string, sources []logSource, open logOpener) bool {
+		addLogTestSuites(reports[key], sources, open)
+		return true
+	})
+
+	result := PipelineRunTestReport{PIPELINERUN: name, TASKRUNS: []TaskRunTestReport{}}
+	for _, key := range taskRunNames {
+		report := reports[key]
+		result.TASKRUNS = append(result.TASKRUNS, *report)
+		result.TestSummary.add(report.TestSummary)
+	}
+	response.WriteEntity(result)
+}
+
+// Reads the results files of a TaskRun, a report with no suites if there are none
+func (r Resource) getResultsFileTestReport(namespace, taskRunName string) TaskRunTestReport {
+	report := TaskRunTestReport{TASKRUN: taskRunName, SUITES: []TestSuite{}}
+	if r.TestResultsDir == "" {
+		return report
+	}
+	if err := validateResultsPath(namespace, taskRunName); err != nil {
+		report.addProblem("files", err)
+		return report
+	}
+	files, _ := filepath.Glob(filepath.Join(r.TestResultsDir, namespace, taskRunName, "*.xml"))
+	sort.Strings(files)
+	for _, file := range files {
+		source := "file " + filepath.Base(file)
+		content, err := readResultsFile(file)
+		if err != nil {
+			report.addProblem(source, err)
+			continue
+		}
+		report.addSuites(source, content)
+	}
+	return report
+}
+
+// Names are part of the path to a TaskRun's results files, so must be Kubernetes names that can't lead outside the directory
+func validateResultsPath(namespace, name string) error {
+	if problems := validation.IsDNS1123Label(namespace); len(problems) > 0 {
+		return fmt.Errorf("error: invalid namespace %q: %s", namespace, strings.Join(problems, ", "))
+	}
+	if problems := validation.IsDNS1123Subdomain(name); len(problems) > 0 {
+		return fmt.Errorf("error: invalid name %q: %s", name, strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+func readResultsFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(file, maxJUnitReportSize+1))
+	if err == nil && len(content) > maxJUnitReportSize {
+		err = fmt.Errorf("larger than %d bytes", maxJUnitReportSize)
+	}
+	return content, err
+}
+
+// Reads the reports between the JUnit markers in the logs of the step containers
+func addLogTestSuites(report *TaskRunTestReport, sources []logSource, open logOpener) {
+	for _, source := range sources {
+		if source.containerType != stepContainerType {
+			continue
+		}
+		podLogs, ok := open(source.container)
+		if !ok {
+			continue
+		}
+		logSource := "log " + source.container
+		var content *bytes.Buffer
+		err := scanLogLines(podLogs, func(line string) {
+			switch strings.TrimSpace(line) {
+			case junitStartMarker:
+				content = new(bytes.Buffer)
+			case junitEndMarker:
+				if content == nil {
+					return
+				}
+				if content.Len() > maxJUnitReportSize {
+					report.addProblem(logSource, fmt.Errorf("larger than %d bytes", maxJUnitReportSize))
+				} else {
+					report.addSuites(logSource, content.Bytes())
+				}
+				content = nil
+			default:
+				// Stop collecting past the limit, the report is rejected when it ends
+				if content != nil && content.Len() <= maxJUnitReportSize {
+					content.WriteString(line)
+					content.WriteByte('\n')
+				}
+			}
+		})
+		podLogs.Close()
+		if err != nil {
+			logging.Log.Errorf("Error reading the log of %s for test results: %s", source.container, err)
+		}
+		if content != nil {
+			report.addProblem(logSource, fmt.Errorf("no %s after %s", junitEndMarker, junitStartMarker))
+		}
+	}
+}
+
+func (report *TaskRunTestReport) addProblem(source string, err error) {
+	report.PROBLEMS = append(report.PROBLEMS, fmt.Sprintf("%s: %s", source, err))
+}
+
+// Adds the suites of a JUnit report, which may be a testsuites or a single testsuite
+func (report *TaskRunTestReport) addSuites(source string, content []byte) {
+	var root struct {
+		XMLName xml.Name
+	}
+	if err := xml.Unmarshal(content, &root); err != nil {
+		report.addProblem(source, err)
+		return
+	}
+	suites := junitTestSuites{}
+	var err error
+	switch root.XMLName.Local {
+	case "testsuites":
+		err = xml.Unmarshal(content, &suites)
+	case "testsuite":
+		suites.Suites = make([]junitTestSuite, 1)
+		err = xml.Unmarshal(content, &suites.Suites[0])
+	default:
+		err = fmt.Errorf("expected testsuites or testsuite, found %s", root.XMLName.Local)
+	}
+	if err != nil {
+		report.addProblem(source, err)
+		return
+	}
+	for _, suite := range suites.Suites {
+		report.addSuite(source, suite)
+	}
+}
+
+func (report *TaskRunTestReport) addSuite(source string, junitSuite junitTestSuite) {
+	suite := TestSuite{NAME: junitSuite.Name, SOURCE: source, CASES: []TestCase{}}
+	casesTime := 0.0
+	for _, junitCase := range junitSuite.Cases {
+		testCase := TestCase{
+			NAME:      junitCase.Name,
+			CLASSNAME: junitCase.ClassName,
+			TIME:      parseTestTime(junitCase.Time),
+			STATUS:    testPassed,
+		}
+		suite.TESTS++
+		switch {
+		case junitCase.Failure != nil:
+			testCase.STATUS = testFailed
+			testCase.MESSAGE, testCase.DETAILS = junitCase.Failure.Message, strings.TrimSpace(junitCase.Failure.Text)
+			suite.FAILURES++
+		case junitCase.Error != nil:
+			testCase.STATUS = testError
+			testCase.MESSAGE, testCase.DETAILS = junitCase.Error.Message, strings.TrimSpace(junitCase.Error.Text)
+			suite.ERRORS++
+		case junitCase.Skipped != nil:
+			testCase.STATUS = testSkipped
+			testCase.MESSAGE = junitCase.Skipped.Message
+			suite.SKIPPED++
+		}
+		casesTime += testCase.TIME
+		suite.CASES = append(suite.CASES, testCase)
+	}
+	suite.TIME = casesTime
+	if junitSuite.Time != "" {
+		suite.TIME = parseTestTime(junitSuite.Time)
+	}
+	if len(suite.CASES) > 0 {
+		report.SUITES = append(report.SUITES, suite)
+		report.TestSummary.add(suite.TestSummary)
+	}
+	for _, nested := range junitSuite.Suites {
+		report.addSuite(source, nested)
+	}
+}
+
+func (summary *TestSummary) add(other TestSummary) {
+	summary.TESTS += other.TESTS
+	summary.FAILURES += other.FAILURES
+	summary.ERRORS += other.ERRORS
+	summary.SKIPPED += other.SKIPPED
+	summary.TIME += other.TIME
+}
+
+// Times are in seconds, some tools write them with thousands separators
+func parseTestTime(value string) float64 {
+	seconds, err := strconv.ParseFloat(strings.Replace(value, ",", "", -1), 64)
+	if err != nil {
+		return 0
+	}
+	return seconds
+}
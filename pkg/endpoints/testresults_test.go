@@ -0,0 +1,164 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const dummyJUnitReport = `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="pkg/a" time="1.5">
+    <testcase name="TestPass" classname="pkg/a" time="0.5"></testcase>
+    <testcase name="TestFail" classname="pkg/a" time="1.0">
+      <failure message="Failed" type="">
+        a_test.go:10: expected 1, got 2
+      </failure>
+    </testcase>
+    <testsuite name="pkg/a/nested">
+      <testcase name="TestSkip" time="0"><skipped message="not on linux"></skipped></testcase>
+      <testcase name="TestError" time="0.25"><error message="panic"></error></testcase>
+    </testsuite>
+  </testsuite>
+</testsuites>`
+
+/* Test results test: JUnit reports are parsed into suites, with nested suites flattened */
+
+func TestAddSuites(t *testing.T) {
+	t.Log("Testing JUnit reports are parsed")
+
+	report := TaskRunTestReport{TASKRUN: "taskrun1", SUITES: []TestSuite{}}
+	report.addSuites("log build-step-one", []byte(dummyJUnitReport))
+	report.addSuites("file single.xml", []byte(`<testsuite name="single"><testcase name="TestOne" time="1,000.5"/></testsuite>`))
+	report.addSuites("file bad.xml", []byte(`<testsuite>`))
+	report.addSuites("file other.xml", []byte(`<coverage/>`))
+
+	expected := []TestSuite{
+		{NAME: "pkg/a", SOURCE: "log build-step-one", TestSummary: TestSummary{TESTS: 2, FAILURES: 1, TIME: 1.5}, CASES: []TestCase{
+			{NAME: "TestPass", CLASSNAME: "pkg/a", TIME: 0.5, STATUS: testPassed},
+			{NAME: "TestFail", CLASSNAME: "pkg/a", TIME: 1.0, STATUS: testFailed, MESSAGE: "Failed", DETAILS: "a_test.go:10: expected 1, got 2"},
+		}},
+		{NAME: "pkg/a/nested", SOURCE: "log build-step-one", TestSummary: TestSummary{TESTS: 2, ERRORS: 1, SKIPPED: 1, TIME: 0.25}, CASES: []TestCase{
+			{NAME: "TestSkip", STATUS: testSkipped, MESSAGE: "not on linux"},
+			{NAME: "TestError", TIME: 0.25, STATUS: testError, MESSAGE: "panic"},
+		}},
+		{NAME: "single", SOURCE: "file single.xml", TestSummary: TestSummary{TESTS: 1, TIME: 1000.5}, CASES: []TestCase{
+			{NAME: "TestOne", TIME: 1000.5, STATUS: testPassed},
+		}},
+	}
+	if !reflect.DeepEqual(report.SUITES, expected) {
+		t.Errorf("FAIL: expected %v, got %v", expected, report.SUITES)
+	}
+	if report.TestSummary != (TestSummary{TESTS: 5, FAILURES: 1, ERRORS: 1, SKIPPED: 1, TIME: 1002.25}) {
+		t.Errorf("FAIL: unexpected totals %v", report.TestSummary)
+	}
+	if len(report.PROBLEMS) != 2 {
+		t.Errorf("FAIL: the unreadable reports should have been given as problems, got %v", report.PROBLEMS)
+	}
+}
+
+/* Test results test: reports are found between markers in step logs, unterminated reports are problems */
+
+func TestAddLogTestSuites(t *testing.T) {
+	t.Log("Testing JUnit reports are read from step logs")
+
+	logs := map[string]string{
+		"init1":          "::junit::\n" + dummyJUnitReport + "\n::endjunit::\n",
+		"build-step-one": "running tests\n  ::junit::\n" + dummyJUnitReport + "\n::endjunit::\ndone\n::junit::\n<testsuites>\n",
+	}
+	report := TaskRunTestReport{TASKRUN: "taskrun1", SUITES: []TestSuite{}}
+	addLogTestSuites(&report, dummyLogSources, dummyLogOpener(logs))
+	if len(report.SUITES) != 2 || report.TESTS != 4 {
+		t.Errorf("FAIL: only the step's report should have been read, got %v", report)
+	}
+	if len(report.PROBLEMS) != 1 {
+		t.Errorf("FAIL: the unterminated report should have been a problem, got %v", report.PROBLEMS)
+	}
+}
+
+/* Test results test: the test results of a PipelineRun combine its TaskRuns' logs and results files */
+
+func TestGetPipelineRunTestResults(t *testing.T) {
+	t.Log("Testing the test results of a PipelineRun")
+
+	dir, err := ioutil.TempDir("", "testresults")
+	if err != nil {
+		t.Fatalf("FAIL: could not create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "ns1", "taskrun2"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "ns1", "taskrun2", "report.xml"), []byte(`<testsuite name="two"><testcase name="TestTwo"/></testsuite>`), 0644)
+
+	r := dummyResource()
+	r.TestResultsDir = dir
+	logArchive := dummyArchivedTaskRun(r)
+	logArchive[archivedContainerKey("ns1", "taskrun1", "build-step-one")] = "::junit::\n" + dummyJUnitReport + "\n::endjunit::\n"
+	pipelineRun := v1alpha1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "pipelinerun1", Namespace: "ns1"},
+		Status: v1alpha1.PipelineRunStatus{
+			TaskRuns: map[string]*v1alpha1.PipelineRunTaskRunStatus{
+				"taskrun2": {PipelineTaskName: "two", Status: &v1alpha1.TaskRunStatus{PodName: "pod2"}},
+				"taskrun1": {PipelineTaskName: "one", Status: &v1alpha1.TaskRunStatus{PodName: "pod1"}},
+			},
+		},
+	}
+	r.PipelineClient.TektonV1alpha1().PipelineRuns("ns1").Create(&pipelineRun)
+
+	httpWriter := httptest.NewRecorder()
+	httpReq := dummyHttpRequest("GET", "http://wwww.dummy.com:8383/v1/namespaces/ns1/pipelinerun/pipelinerun1/testresults", nil)
+	resp := dummyRestfulResponse(httpWriter)
+	r.getPipelineRunTestResults(dummyRestfulRequest(httpReq, "ns1", "pipelinerun1"), resp)
+	if resp.StatusCode() != 200 {
+		t.Fatalf("FAIL: the test results should have been found, got %d", resp.StatusCode())
+	}
+	result := PipelineRunTestReport{}
+	if err := json.Unmarshal(httpWriter.Body.Bytes(), &result); err != nil {
+		t.Fatalf("FAIL: could not decode the test results: %s", err)
+	}
+	if len(result.TASKRUNS) != 2 || result.TASKRUNS[0].TASKRUN != "taskrun1" || result.TASKRUNS[1].TASKRUN != "taskrun2" {
+		t.Fatalf("FAIL: expected the results of taskrun1 then taskrun2, got %v", result.TASKRUNS)
+	}
+	if result.TASKRUNS[0].TESTS != 4 || result.TASKRUNS[1].TESTS != 1 || result.TESTS != 5 || result.FAILURES != 1 {
+		t.Errorf("FAIL: unexpected test results %v", result)
+	}
+
+	httpWriter = httptest.NewRecorder()
+	resp = dummyRestfulResponse(httpWriter)
+	r.getTaskRunTestResults(dummyRestfulRequest(httpReq, "ns1", "taskrun3"), resp)
+	if resp.StatusCode() != 404 {
+		t.Errorf("FAIL: an unknown TaskRun should have given a 404, got %d", resp.StatusCode())
+	}
+
+	// Names that could lead outside the test results directory
+	for _, path := range [][]string{{"ns1", ".."}, {"..", "ns1"}, {"ns1", "taskrun1/.."}, {"NS1", "taskrun1"}} {
+		httpWriter = httptest.NewRecorder()
+		resp = dummyRestfulResponse(httpWriter)
+		r.getTaskRunTestResults(dummyRestfulRequest(httpReq, path[0], path[1]), resp)
+		if resp.StatusCode() != 400 {
+			t.Errorf("FAIL: namespace %s and name %s should have given a 400, got %d", path[0], path[1], resp.StatusCode())
+		}
+	}
+	report := r.getResultsFileTestReport("ns1", "../ns1/taskrun2")
+	if len(report.SUITES) != 0 || len(report.PROBLEMS) != 1 {
+		t.Errorf("FAIL: results files should not have been read for an invalid TaskRun name, got %v", report)
+	}
+}
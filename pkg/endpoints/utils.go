@@ -14,47 +14,88 @@ package endpoints
 
 import (
 	restful "github.com/emicklei/go-restful"
+	"github.com/tektoncd/dashboard/pkg/archive"
 	logging "github.com/tektoncd/dashboard/pkg/logging"
 	"github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
 	k8sclientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
 )
 
 // Store all types here that are reused throughout files
 type Resource struct {
 	PipelineClient versioned.Interface
 	K8sClient      k8sclientset.Interface
+	// Where TaskRun logs are archived when they complete, nil if they aren't
+	LogArchive archive.Store
+	// Where JUnit XML files copied from TaskRuns are found, empty if they aren't
+	TestResultsDir string
+	// Set by StartPipelineRunController, nil until it is started
+	pipelineRunInformer cache.SharedIndexInformer
 }
 
+// Resources may be read and written as JSON or YAML
+var resourceMIMETypes = []string{restful.MIME_JSON, mimeYAML, mimeXYAML}
+
 // RegisterPipeline
 func (r Resource) RegisterEndpoints(container *restful.Container) {
 	wsv1 := new(restful.WebService)
 	wsv1.
 		Path("/v1/namespaces").
-		Consumes(restful.MIME_JSON).
-		Produces(restful.MIME_JSON)
+		Consumes(resourceMIMETypes...).
+		Produces(resourceMIMETypes...)
+	registerYAMLEntityAccessors()
 
 	logging.Log.Info("Adding v1, and API for pipelines")
 	wsv1.Route(wsv1.GET("/{namespace}/pipeline").To(r.getAllPipelines))
+	wsv1.Route(wsv1.POST("/{namespace}/pipeline").To(r.createPipeline))
 	wsv1.Route(wsv1.GET("/{namespace}/pipeline/{name}").To(r.getPipeline))
+	wsv1.Route(wsv1.PUT("/{namespace}/pipeline/{name}").To(r.replacePipeline))
+	wsv1.Route(wsv1.PATCH("/{namespace}/pipeline/{name}").To(r.patchPipeline))
+	wsv1.Route(wsv1.DELETE("/{namespace}/pipeline/{name}").To(r.deletePipeline))
 
 	wsv1.Route(wsv1.GET("/{namespace}/pipelinerun").To(r.getAllPipelineRuns))
+	wsv1.Route(wsv1.POST("/{namespace}/pipelinerun").To(r.createPipelineRun))
+	wsv1.Route(wsv1.DELETE("/{namespace}/pipelinerun").To(r.deletePipelineRuns))
 	wsv1.Route(wsv1.GET("/{namespace}/pipelinerun/{name}").To(r.getPipelineRun))
 	wsv1.Route(wsv1.PUT("/{namespace}/pipelinerun/{name}").To(r.updatePipelineRun))
+	wsv1.Route(wsv1.PATCH("/{namespace}/pipelinerun/{name}").To(r.patchPipelineRun))
+	wsv1.Route(wsv1.DELETE("/{namespace}/pipelinerun/{name}").To(r.deletePipelineRun))
+	wsv1.Route(wsv1.POST("/{namespace}/pipelinerun/{name}/rerun").To(r.rerunPipelineRun))
+	wsv1.Route(wsv1.POST("/{namespace}/pipelinerun/{name}/retry").To(r.retryPipelineRun))
+	wsv1.Route(wsv1.GET("/{namespace}/pipelinerun/{name}/export").To(r.exportPipelineRun))
+	wsv1.Route(wsv1.GET("/{namespace}/pipelinerun/{name}/testresults").To(r.getPipelineRunTestResults).Produces(restful.MIME_JSON))
 
 	wsv1.Route(wsv1.GET("/{namespace}/pipelineresource").To(r.getAllPipelineResources))
+	wsv1.Route(wsv1.POST("/{namespace}/pipelineresource").To(r.createPipelineResource))
 	wsv1.Route(wsv1.GET("/{namespace}/pipelineresource/{name}").To(r.getPipelineResource))
+	wsv1.Route(wsv1.PUT("/{namespace}/pipelineresource/{name}").To(r.replacePipelineResource))
+	wsv1.Route(wsv1.PATCH("/{namespace}/pipelineresource/{name}").To(r.patchPipelineResource))
+	wsv1.Route(wsv1.DELETE("/{namespace}/pipelineresource/{name}").To(r.deletePipelineResource))
 
 	wsv1.Route(wsv1.GET("/{namespace}/task").To(r.getAllTasks))
+	wsv1.Route(wsv1.POST("/{namespace}/task").To(r.createTask))
 	wsv1.Route(wsv1.GET("/{namespace}/task/{name}").To(r.getTask))
+	wsv1.Route(wsv1.PUT("/{namespace}/task/{name}").To(r.replaceTask))
+	wsv1.Route(wsv1.PATCH("/{namespace}/task/{name}").To(r.patchTask))
+	wsv1.Route(wsv1.DELETE("/{namespace}/task/{name}").To(r.deleteTask))
+
+	wsv1.Route(wsv1.POST("/{namespace}/import").To(r.importDefinitions))
 
 	wsv1.Route(wsv1.GET("/{namespace}/taskrun").To(r.getAllTaskRuns))
+	wsv1.Route(wsv1.DELETE("/{namespace}/taskrun").To(r.deleteTaskRuns))
 	wsv1.Route(wsv1.GET("/{namespace}/taskrun/{name}").To(r.getTaskRun))
+	wsv1.Route(wsv1.PUT("/{namespace}/taskrun/{name}").To(r.updateTaskRun))
+	wsv1.Route(wsv1.PATCH("/{namespace}/taskrun/{name}").To(r.patchTaskRun))
+	wsv1.Route(wsv1.DELETE("/{namespace}/taskrun/{name}").To(r.deleteTaskRun))
+	wsv1.Route(wsv1.GET("/{namespace}/taskrun/{name}/testresults").To(r.getTaskRunTestResults).Produces(restful.MIME_JSON))
 
 	wsv1.Route(wsv1.GET("/{namespace}/log/{name}").To(r.getPodLog))
 
-	wsv1.Route(wsv1.GET("/{namespace}/taskrunlog/{name}").To(r.getTaskRunLog))
+	wsv1.Route(wsv1.GET("/{namespace}/taskrunlog/{name}").To(r.getTaskRunLog).Produces(restful.MIME_JSON, mimeNDJSON))
 
-	wsv1.Route(wsv1.GET("/{namespace}/pipelinerunlog/{name}").To(r.getPipelineRunLog))
+	wsv1.Route(wsv1.GET("/{namespace}/pipelinerunlog/{name}").To(r.getPipelineRunLog).Produces("text/plain", restful.MIME_JSON, mimeNDJSON))
+	wsv1.Route(wsv1.GET("/{namespace}/pipelinerunlog/{name}/search").To(r.searchPipelineRunLog).Produces(restful.MIME_JSON))
+	wsv1.Route(wsv1.GET("/{namespace}/pipelinerunlog/{name}/download").To(r.downloadPipelineRunLog).Produces("application/zip", "application/x-tar"))
 
 	wsv1.Route(wsv1.GET("/{namespace}/credentials/").To(r.getAllCredentials))
 	wsv1.Route(wsv1.GET("/{namespace}/credentials/{id}").To(r.getCredential))
@@ -13,10 +13,21 @@ limitations under the License.
 package endpoints
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
 	restful "github.com/emicklei/go-restful"
 	logging "github.com/tektoncd/dashboard/pkg/logging"
 	broadcaster "github.com/tektoncd/dashboard/pkg/broadcaster"
+	"github.com/tektoncd/dashboard/pkg/utils"
 	"github.com/tektoncd/dashboard/pkg/websocket"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 // Define all broadcasters/channels
@@ -24,24 +35,193 @@ import (
 var logChannel = make(chan broadcaster.SocketData)
 var pipelineRunsChannel = make(chan broadcaster.SocketData)
 
-var logBroadcaster = broadcaster.NewBroadcaster(logChannel)
-var pipelineRunsBroadcaster = broadcaster.NewBroadcaster(pipelineRunsChannel)
+// A client missing log lines is told to resync rather than shown a log with gaps
+var logBroadcaster = broadcaster.NewBroadcasterWithOptions(logChannel, broadcaster.Options{Overflow: broadcaster.Disconnect})
+
+// A client missing PipelineRun updates only needs the latest state of each PipelineRun.
+// Recent messages are kept for clients that reconnect, e.g. after sleeping
+var pipelineRunsBroadcaster = broadcaster.NewBroadcasterWithOptions(pipelineRunsChannel, broadcaster.Options{
+	Overflow:   broadcaster.Coalesce,
+	ReplaySize: broadcaster.DefaultQueueSize,
+})
+
+// Kinds of the objects messages are about, log messages are about the TaskRun the log is from
+const (
+	pipelineRunKind = "PipelineRun"
+	taskRunKind     = "TaskRun"
+)
+
+var messageTypes = map[string]bool{
+	string(broadcaster.Log):                 true,
+	string(broadcaster.PipelineRunCreated):  true,
+	string(broadcaster.PipelineRunDeleted):  true,
+	string(broadcaster.PipelineRunUpdated):  true,
+	string(broadcaster.PipelineRunSnapshot): true,
+}
 
 // Establish websocket and subscribe to pipeline log events
 func (r Resource) establishPipelineLogsWebsocket(request *restful.Request, response *restful.Response) {
+	subscription, ok := getSubscription(request, response)
+	if !ok {
+		return
+	}
 	connection, err := websocket.UpgradeToWebsocket(request, response)
 	if err != nil {
+		// The upgrader has already responded with the error
 		logging.Log.Errorf("Could not upgrade to websocket connection: %s", err)
+		return
 	}
-	websocket.WriteOnlyWebsocket(connection, logBroadcaster)
+	websocket.WriteOnlyWebsocket(connection, logBroadcaster, subscription, nil)
 }
 
 // Establish websocket and subscribe to pipelinerun events
 func (r Resource) establishPipelineRunsWebsocket(request *restful.Request, response *restful.Response) {
+	subscription, ok := getSubscription(request, response)
+	if !ok {
+		return
+	}
 	connection, err := websocket.UpgradeToWebsocket(request, response)
 	if err != nil {
+		// The upgrader has already responded with the error
 		logging.Log.Errorf("Could not upgrade to websocket connection: %s", err)
+		return
+	}
+
+	var initial func(uint64) []broadcaster.SocketData
+	// A resumed client already has the PipelineRuns
+	if subscription.Since == 0 {
+		initial = r.pipelineRunSnapshot(subscription.Filter)
+	}
+	websocket.WriteOnlyWebsocket(connection, pipelineRunsBroadcaster, subscription, initial)
+}
+
+/* The PipelineRuns matching the filter as a PipelineRunSnapshot then a Bookmark, so clients don't need to list them separately.
+ * Nothing is sent if the filter leaves out PipelineRunSnapshot messages, or if the PipelineRuns can't be listed
+ */
+func (r Resource) pipelineRunSnapshot(filter broadcaster.Filter) func(uint64) []broadcaster.SocketData {
+	return func(sequence uint64) []broadcaster.SocketData {
+		if len(filter.MessageTypes) > 0 && !containsString(filter.MessageTypes, string(broadcaster.PipelineRunSnapshot)) {
+			return nil
+		}
+		pipelineRuns, err := r.listPipelineRuns(filter.Namespace)
+		if err != nil {
+			logging.Log.Errorf("Error listing PipelineRuns for a websocket snapshot: %s", err)
+			return nil
+		}
+		snapshot := []v1alpha1.PipelineRun{}
+		for _, pipelineRun := range pipelineRuns {
+			data := broadcaster.SocketData{MessageType: broadcaster.PipelineRunSnapshot, Subject: pipelineRunSubject(pipelineRun)}
+			if filter.Matches(data) {
+				snapshot = append(snapshot, *pipelineRun)
+			}
+		}
+		sort.Slice(snapshot, func(i, j int) bool {
+			if snapshot[i].Namespace != snapshot[j].Namespace {
+				return snapshot[i].Namespace < snapshot[j].Namespace
+			}
+			return snapshot[i].Name < snapshot[j].Name
+		})
+		return []broadcaster.SocketData{
+			{MessageType: broadcaster.PipelineRunSnapshot, Payload: snapshot},
+			{MessageType: broadcaster.Bookmark, Sequence: sequence},
+		}
+	}
+}
+
+// From the PipelineRun controller's cache once it has synced, otherwise from the API. All namespaces if namespace is empty
+func (r Resource) listPipelineRuns(namespace string) ([]*v1alpha1.PipelineRun, error) {
+	var pipelineRuns []*v1alpha1.PipelineRun
+	if r.pipelineRunInformer != nil && r.pipelineRunInformer.HasSynced() {
+		for _, obj := range r.pipelineRunInformer.GetStore().List() {
+			if pipelineRun, ok := obj.(*v1alpha1.PipelineRun); ok && (namespace == "" || pipelineRun.Namespace == namespace) {
+				pipelineRuns = append(pipelineRuns, pipelineRun)
+			}
+		}
+		return pipelineRuns, nil
+	}
+	pipelineRunList, err := r.PipelineClient.TektonV1alpha1().PipelineRuns(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range pipelineRunList.Items {
+		pipelineRuns = append(pipelineRuns, &pipelineRunList.Items[i])
+	}
+	return pipelineRuns, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
 	}
+	return false
+}
+
+// ShutdownWebsockets - sends websocket clients the messages queued for them then disconnects them, or disconnects them straight away once the context is done
+func ShutdownWebsockets(ctx context.Context) error {
+	logErr := logBroadcaster.Shutdown(ctx)
+	if err := pipelineRunsBroadcaster.Shutdown(ctx); err != nil {
+		return err
+	}
+	return logErr
+}
 
-	websocket.WriteOnlyWebsocket(connection, pipelineRunsBroadcaster)
+/* Read the messages a websocket client wants from the query parameters, sending a 400 if they are invalid.
+ * Query parameters:
+ * namespace: only messages about objects in this namespace
+ * name: only messages about the object with this name, for logs the TaskRun
+ * kind: only messages about objects of this kind, PipelineRun or TaskRun
+ * messageType: comma separated message types, e.g. PipelineRunCreated,PipelineRunDeleted
+ * labelSelector: only messages about objects with matching labels, e.g. tekton.dev/pipelineRun=pipelinerun1 for the logs of a PipelineRun
+ * since: the Sequence of the last message received before reconnecting, to be sent the messages missed.
+ *        If they are no longer kept a Reset message is sent instead, and the client should list again
+ */
+func getSubscription(request *restful.Request, response *restful.Response) (broadcaster.Subscription, bool) {
+	filter := broadcaster.Filter{
+		Namespace: request.QueryParameter("namespace"),
+		Name:      request.QueryParameter("name"),
+		Kind:      request.QueryParameter("kind"),
+	}
+	subscription := broadcaster.Subscription{}
+	if filter.Kind != "" && filter.Kind != pipelineRunKind && filter.Kind != taskRunKind {
+		utils.RespondErrorMessage(response, fmt.Sprintf("Error: kind must be %s or %s", pipelineRunKind, taskRunKind), http.StatusBadRequest)
+		return subscription, false
+	}
+	if value := request.QueryParameter("messageType"); value != "" {
+		for _, messageType := range strings.Split(value, ",") {
+			if !messageTypes[messageType] {
+				utils.RespondErrorMessage(response, "Error: unknown messageType "+messageType, http.StatusBadRequest)
+				return subscription, false
+			}
+			filter.MessageTypes = append(filter.MessageTypes, messageType)
+		}
+	}
+	if value := request.QueryParameter("labelSelector"); value != "" {
+		selector, err := labels.Parse(value)
+		if err != nil {
+			utils.RespondError(response, err, http.StatusBadRequest)
+			return subscription, false
+		}
+		filter.LabelSelector = selector
+	}
+	if value := request.QueryParameter("since"); value != "" {
+		since, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			utils.RespondErrorMessage(response, "Error: since must be a message's Sequence", http.StatusBadRequest)
+			return subscription, false
+		}
+		subscription.Since = since
+	}
+	subscription.Filter = filter
+	return subscription, true
+}
+
+func pipelineRunSubject(pipelineRun *v1alpha1.PipelineRun) broadcaster.Subject {
+	return broadcaster.Subject{
+		Kind:      pipelineRunKind,
+		Namespace: pipelineRun.Namespace,
+		Name:      pipelineRun.Name,
+		Labels:    pipelineRun.Labels,
+	}
 }
@@ -16,8 +16,10 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"strings"
 	"sync"
 	"testing"
@@ -27,216 +29,462 @@ import (
 	gorillaSocket "github.com/gorilla/websocket"
 	"github.com/tektoncd/dashboard/pkg/broadcaster"
 	"github.com/tektoncd/dashboard/pkg/websocket"
-	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	fakeclientset "github.com/tektoncd/pipeline/pkg/client/clientset/versioned/fake"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/sample-controller/pkg/signals"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
-// Make logger global
-var T *testing.T
+/* Websocket test: subscriptions are read from the query parameters, invalid ones give a 400 */
 
-// Counters for pipelineruns websocket test
-var CreationsRecorded = 0
-var UpdatesRecorded = 0
-var DeletionsRecorded = 0
+func TestGetSubscriptionFilter(t *testing.T) {
+	t.Log("Testing websocket subscription filters")
 
-func TestLogWebsocket(t *testing.T) {
-	T = t
-	T.Log("Enter TestLogWebsocket...")
+	httpReq := dummyHttpRequest("GET", "http://wwww.dummy.com:8383/v1/websocket/logs?namespace=ns1&kind=TaskRun&messageType=Log&labelSelector=tekton.dev/pipelineRun%3Dpipelinerun1&since=42", nil)
+	subscription, ok := getSubscription(dummyRestfulRequest(httpReq, "", ""), dummyRestfulResponse(httptest.NewRecorder()))
+	if !ok {
+		t.Fatalf("FAIL: the filter should have been valid")
+	}
+	if subscription.Since != 42 {
+		t.Errorf("FAIL: expected to resume after 42, got %d", subscription.Since)
+	}
+	filter := subscription.Filter
+	matching := broadcaster.SocketData{
+		MessageType: broadcaster.Log,
+		Subject:     broadcaster.Subject{Kind: taskRunKind, Namespace: "ns1", Name: "taskrun1", Labels: map[string]string{pipelineRunLabel: "pipelinerun1"}},
+	}
+	if !filter.Matches(matching) {
+		t.Errorf("FAIL: the filter %v should have matched %v", filter, matching)
+	}
+	other := matching
+	other.Subject.Labels = map[string]string{pipelineRunLabel: "pipelinerun2"}
+	if filter.Matches(other) {
+		t.Errorf("FAIL: the filter %v should not have matched the log of another PipelineRun", filter)
+	}
+
+	for _, query := range []string{"kind=Pod", "messageType=Log,Unknown", "labelSelector=a%20b%20c", "since=-1"} {
+		httpReq := dummyHttpRequest("GET", "http://wwww.dummy.com:8383/v1/websocket/pipelineruns?"+query, nil)
+		resp := dummyRestfulResponse(httptest.NewRecorder())
+		if _, ok := getSubscription(dummyRestfulRequest(httpReq, "", ""), resp); ok || resp.StatusCode() != 400 {
+			t.Errorf("FAIL: %s should have given a 400, got %d", query, resp.StatusCode())
+		}
+	}
+}
+
+/* Websocket test: new PipelineRun subscribers are sent the PipelineRuns matching their filter then a bookmark */
+
+func TestPipelineRunSnapshot(t *testing.T) {
+	t.Log("Testing the initial PipelineRun snapshot")
+
+	r := dummyResource()
+	for _, pipelineRun := range []v1alpha1.PipelineRun{
+		{ObjectMeta: metav1.ObjectMeta{Name: "pipelinerun2", Namespace: "ns1", Labels: map[string]string{"app": "a"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "pipelinerun1", Namespace: "ns1", Labels: map[string]string{"app": "a"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "pipelinerun3", Namespace: "ns1", Labels: map[string]string{"app": "b"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "pipelinerun4", Namespace: "ns2", Labels: map[string]string{"app": "a"}}},
+	} {
+		r.PipelineClient.TektonV1alpha1().PipelineRuns(pipelineRun.Namespace).Create(&pipelineRun)
+	}
 
-	_, s2 := setupResourceAndServer()
-	defer s2.Close()
+	filter := broadcaster.Filter{Namespace: "ns1", LabelSelector: labels.SelectorFromSet(labels.Set{"app": "a"})}
+	initial := r.pipelineRunSnapshot(filter)(42)
+	if len(initial) != 2 || initial[0].MessageType != broadcaster.PipelineRunSnapshot || initial[1].MessageType != broadcaster.Bookmark {
+		t.Fatalf("FAIL: expected a snapshot then a bookmark, got %v", initial)
+	}
+	var names []string
+	for _, pipelineRun := range initial[0].Payload.([]v1alpha1.PipelineRun) {
+		names = append(names, pipelineRun.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"pipelinerun1", "pipelinerun2"}) {
+		t.Errorf("FAIL: expected the matching PipelineRuns in order, got %v", names)
+	}
+	if initial[1].Sequence != 42 {
+		t.Errorf("FAIL: the bookmark should have had the subscriber's sequence number, got %d", initial[1].Sequence)
+	}
 
-	devopsServer := strings.TrimPrefix(s2.URL, "https://")
-	websocketURL := url.URL{Scheme: "wss", Host: devopsServer, Path: "/v1/websocket/logs"}
-	websocketEndpoint := websocketURL.String()
-	clientSize := 10
-	connectionDur := time.Second * 5
-	var wg sync.WaitGroup
-	for i := 1; i <= clientSize; i++ {
-		wg.Add(1)
-		go clientWebsocket(websocketEndpoint, connectionDur, &wg, i)
+	filter.MessageTypes = []string{string(broadcaster.PipelineRunDeleted)}
+	if initial := r.pipelineRunSnapshot(filter)(42); initial != nil {
+		t.Errorf("FAIL: a client not subscribed to snapshots should not have been sent one, got %v", initial)
 	}
-	T.Log("Waiting for clients to terminate...")
-	wg.Wait()
+}
 
-	// Allow Devops websocket heart beat (every second) to detect failures/unsubscribe clients
-	time.Sleep(time.Second * 2)
+/* Websocket test: log clients are sent the lines matching their filter, and are unsubscribed once they disconnect */
 
-	// Ensure there aren't any listeners on broadcaster
-	poolSize := logBroadcaster.PoolSize()
-	checkTestResult("Subscribers", 0, poolSize)
+func TestLogWebsocket(t *testing.T) {
+	t.Log("Testing the log websocket")
+
+	server := setupServer(dummyResource())
+	defer server.Close()
+
+	path := "/v1/websocket/logs"
+	var clients []*websocketClient
+	for i := 0; i < 10; i++ {
+		clients = append(clients, clientWebsocket(t, server, path, ""))
+	}
+	byTaskRun := clientWebsocket(t, server, path, "namespace=ns1&kind=TaskRun&name=taskrun1")
+	byPipelineRun := clientWebsocket(t, server, path, "labelSelector="+url.QueryEscape(pipelineRunLabel+"=pipelinerun1"))
+	otherNamespace := clientWebsocket(t, server, path, "namespace=ns2")
+	all := append([]*websocketClient{byTaskRun, byPipelineRun, otherNamespace}, clients...)
+	defer closeWebsocketClients(all...)
+	waitFor(t, "the clients to subscribe", func() bool { return logBroadcaster.PoolSize() == len(all) })
+
+	logLine := LogLine{NAMESPACE: "ns1", PIPELINERUN: "pipelinerun1", TASKRUN: "taskrun1", POD: "pod1", CONTAINER: "build-step-one", CONTAINERTYPE: stepContainerType}
+	subject := broadcaster.Subject{Kind: taskRunKind, Namespace: "ns1", Name: "taskrun1", Labels: map[string]string{pipelineRunLabel: "pipelinerun1"}}
+	publishLogLines(strings.NewReader("first line\n"), logLine, subject, newLogRedactor(nil))
+	otherLine := LogLine{NAMESPACE: "ns2", TASKRUN: "taskrun2", POD: "pod2", CONTAINER: "build-step-one", CONTAINERTYPE: stepContainerType}
+	publishLogLines(strings.NewReader("other line\n"), otherLine, broadcaster.Subject{Kind: taskRunKind, Namespace: "ns2", Name: "taskrun2"}, newLogRedactor(nil))
+
+	expected := map[*websocketClient]string{byTaskRun: "first line", byPipelineRun: "first line", otherNamespace: "other line"}
+	for client, line := range expected {
+		client.waitForMessages(string(broadcaster.Log), 1)
+		var received LogLine
+		json.Unmarshal(client.messages("")[0].Payload, &received)
+		if received.LINE != line || len(client.messages("")) != 1 {
+			t.Errorf("FAIL: the client subscribed with %s should only have been sent %q, got %v", client.query, line, client.messages(""))
+		}
+	}
+	for _, client := range clients {
+		client.waitForMessages(string(broadcaster.Log), 2)
+	}
 
-	T.Log("Exit TestLogWebsocket")
+	closeWebsocketClients(all...)
+	// The server notices the client has gone when reading from the connection fails
+	waitFor(t, "the clients to be unsubscribed", func() bool { return logBroadcaster.PoolSize() == 0 })
 }
 
-func TestPipelineRunWebsocket(t *testing.T) {
-	T = t
-	T.Log("Enter TestPipelineRunWebsocket...")
+/* Websocket test: plain requests to the websocket routes are refused, without subscribing or taking down the server */
 
-	r, s2 := setupResourceAndServer()
-	defer s2.Close()
+func TestWebsocketPlainRequest(t *testing.T) {
+	t.Log("Testing plain requests to the websocket routes")
 
-	stopCh := signals.SetupSignalHandler()
-	r.StartPipelineRunController(stopCh)
+	server := setupServer(dummyResource())
+	defer server.Close()
 
-	devopsServer := strings.TrimPrefix(s2.URL, "https://")
-	websocketURL := url.URL{Scheme: "wss", Host: devopsServer, Path: "/v1/websocket/pipelineruns"}
-	websocketEndpoint := websocketURL.String()
-	// Create 10 clients with a lifespan of 30 seconds (should be sufficient for test)
-	clients := 10
-	connectionDur := time.Second * 30
-	var wg sync.WaitGroup
-	for i := 1; i <= clients; i++ {
-		wg.Add(1)
-		go clientWebsocket(websocketEndpoint, connectionDur, &wg, i)
+	for _, path := range []string{"/v1/websocket/logs", "/v1/websocket/pipelineruns"} {
+		resp, err := server.Client().Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("FAIL: the server should have survived a plain GET of %s: %s", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("FAIL: a plain GET of %s should have given a 400, got %d", path, resp.StatusCode)
+		}
+		// Still serving websockets
+		client := clientWebsocket(t, server, path, "")
+		client.close()
 	}
+	waitFor(t, "the clients to be unsubscribed", func() bool { return logBroadcaster.PoolSize() == 0 && pipelineRunsBroadcaster.PoolSize() == 0 })
+}
 
-	// Give chance for websockets to be created and check we have 10 subscribers
-	time.Sleep(time.Second * 2)
-	poolSize := pipelineRunsBroadcaster.PoolSize()
-	checkTestResult("Subscribers", 10, poolSize)
+/* Websocket test: PipelineRun clients are sent a snapshot and bookmark then the changes matching their filter,
+ * resumed clients are sent the changes they missed instead of a snapshot
+ */
 
-	r.createTestPipelineRun("WebsocketPipelinerun", "123456")
-	time.Sleep(time.Second * 5)
-	r.updateTestPipelineRun("WebsocketPipelinerun", "654321")
-	time.Sleep(time.Second * 5)
-	r.deleteTestPipelineRun("WebsocketPipelinerun")
-	time.Sleep(time.Second * 5)
+func TestPipelineRunWebsocket(t *testing.T) {
+	t.Log("Testing the PipelineRun websocket")
+
+	r := dummyResource()
+	r.createTestPipelineRun(t, "ns1", "existing", "1")
+	defer r.startTestPipelineRunController(t)()
+	server := setupServer(r)
+	defer server.Close()
+
+	path := "/v1/websocket/pipelineruns"
+	dialer := gorillaSocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	if _, resp, err := dialer.Dial(websocketEndpoint(server, path, "since=-1"), nil); err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("FAIL: an invalid subscription should have been refused with a 400, got %v", resp)
+	}
+
+	var clients []*websocketClient
+	for i := 0; i < 10; i++ {
+		clients = append(clients, clientWebsocket(t, server, path, ""))
+	}
+	byName := clientWebsocket(t, server, path, "namespace=ns1&name=WebsocketPipelinerun")
+	otherNamespace := clientWebsocket(t, server, path, "namespace=ns2")
+	deletionsOnly := clientWebsocket(t, server, path, "messageType=PipelineRunDeleted")
+	resumed := clientWebsocket(t, server, path, "")
+	all := append([]*websocketClient{byName, otherNamespace, deletionsOnly, resumed}, clients...)
+	defer closeWebsocketClients(all...)
+	waitFor(t, "the clients to subscribe", func() bool { return pipelineRunsBroadcaster.PoolSize() == len(all) })
+
+	// Snapshots only hold the PipelineRuns matching the client's filter
+	bookmarks := make(map[*websocketClient]uint64)
+	for _, client := range clients {
+		bookmarks[client] = checkSnapshot(t, client, []string{"existing"})
+	}
+	checkSnapshot(t, byName, []string{})
+	checkSnapshot(t, otherNamespace, []string{})
+	since := checkSnapshot(t, resumed, []string{"existing"})
+	resumed.close()
+	waitFor(t, "the resumed client to be unsubscribed", func() bool { return pipelineRunsBroadcaster.PoolSize() == len(all)-1 })
+
+	r.createTestPipelineRun(t, "ns1", "WebsocketPipelinerun", "123456")
+	r.updateTestPipelineRun(t, "ns1", "WebsocketPipelinerun", "654321")
+	r.deleteTestPipelineRun(t, "ns1", "WebsocketPipelinerun")
+	r.createTestPipelineRun(t, "ns2", "WebsocketPipelinerun2", "1")
+
+	for _, client := range append([]*websocketClient{byName}, clients...) {
+		client.waitForPipelineRun(string(broadcaster.PipelineRunDeleted), "WebsocketPipelinerun")
+		checkPipelineRunChanges(t, client, "WebsocketPipelinerun")
+	}
+	for _, client := range clients {
+		if created := client.pipelineRunMessages(string(broadcaster.PipelineRunCreated), "WebsocketPipelinerun"); len(created) > 0 && created[0].Sequence <= bookmarks[client] {
+			t.Errorf("FAIL: changes after the snapshot should have come after its bookmark %d, got %d", bookmarks[client], created[0].Sequence)
+		}
+	}
+	if received := byName.messages(""); len(received) != 5 {
+		t.Errorf("FAIL: a client subscribed to one PipelineRun should only have been sent its changes, got %v", received)
+	}
+	otherNamespace.waitForPipelineRun(string(broadcaster.PipelineRunCreated), "WebsocketPipelinerun2")
+	if received := otherNamespace.messages(""); len(received) != 3 {
+		t.Errorf("FAIL: a client subscribed to another namespace should not have been sent the changes in ns1, got %v", received)
+	}
+	deletionsOnly.waitForPipelineRun(string(broadcaster.PipelineRunDeleted), "WebsocketPipelinerun")
+	if received := deletionsOnly.messages(""); len(received) != 1 {
+		t.Errorf("FAIL: a client subscribed to deletions should only have been sent the deletion, got %v", received)
+	}
 
-	// Check that each subscriber received the notification of create, update, delete
-	// Basic check that we have 10 of each recorded
-	checkTestResult("CreationsRecorded", 10, CreationsRecorded)
-	checkTestResult("UpdatesRecorded", 10, UpdatesRecorded)
-	checkTestResult("DeletionsRecorded", 10, DeletionsRecorded)
+	// Resuming after the bookmark replays the changes missed without a snapshot
+	resumedAgain := clientWebsocket(t, server, path, fmt.Sprintf("since=%d", since))
+	defer resumedAgain.close()
+	resumedAgain.waitForPipelineRun(string(broadcaster.PipelineRunDeleted), "WebsocketPipelinerun")
+	checkPipelineRunChanges(t, resumedAgain, "WebsocketPipelinerun")
+	for _, received := range resumedAgain.messages("") {
+		if received.MessageType == string(broadcaster.PipelineRunSnapshot) || received.MessageType == string(broadcaster.Bookmark) || received.Sequence <= since {
+			t.Errorf("FAIL: a resumed client should only have been sent the changes after %d, got %v", since, received)
+		}
+	}
 
-	T.Log("Waiting for clients to terminate...")
-	wg.Wait()
+	// The changes after an unknown sequence number aren't kept, so the client must list again
+	expired := clientWebsocket(t, server, path, "since=1")
+	defer expired.close()
+	expired.waitForMessages(string(broadcaster.Reset), 1)
+	if first := expired.messages("")[0]; first.MessageType != string(broadcaster.Reset) {
+		t.Errorf("FAIL: a client resuming after messages no longer kept should have been sent a Reset first, got %v", first)
+	}
 
-	// Allow Devops websocket heart beat (every second) to detect failures/unsubscribe clients
-	time.Sleep(time.Second * 2)
+	closeWebsocketClients(append(all, resumedAgain, expired)...)
+	waitFor(t, "the clients to be unsubscribed", func() bool { return pipelineRunsBroadcaster.PoolSize() == 0 })
+}
 
-	// Ensure there aren't any listeners on broadcaster
-	poolSize = pipelineRunsBroadcaster.PoolSize()
-	checkTestResult("Subscribers", 0, poolSize)
+// A message as received by a websocket client
+type receivedMessage struct {
+	MessageType string
+	Payload     json.RawMessage
+	Sequence    uint64
+}
 
-	T.Log("Exit TestPipelineRunWebsocket")
+// A websocket client recording the messages it is sent until it is closed
+type websocketClient struct {
+	t          *testing.T
+	query      string
+	connection *gorillaSocket.Conn
+	done       chan struct{}
+	mutex      sync.Mutex
+	closing    bool
+	received   []receivedMessage
 }
 
-func clientWebsocket(websocketEndpoint string, readDeadline time.Duration, wg *sync.WaitGroup, identifier int) {
-	defer wg.Done()
-	d := gorillaSocket.Dialer{TLSClientConfig: &tls.Config{RootCAs: nil, InsecureSkipVerify: true}}
+func websocketEndpoint(server *httptest.Server, path, query string) string {
+	websocketURL := url.URL{Scheme: "wss", Host: strings.TrimPrefix(server.URL, "https://"), Path: path, RawQuery: query}
+	return websocketURL.String()
+}
 
-	connection, _, err := d.Dial(websocketEndpoint, nil)
+// Connects to the server's websocket at path, subscribing with the query parameters given
+func clientWebsocket(t *testing.T, server *httptest.Server, path, query string) *websocketClient {
+	dialer := gorillaSocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	endpoint := websocketEndpoint(server, path, query)
+	connection, _, err := dialer.Dial(endpoint, nil)
 	if err != nil {
-		T.Fatalf("Dial error connecting to %s:, %s\n", websocketEndpoint, err)
-	}
-
-	doneChan := make(chan struct{})
-	deadlineTime := time.Now().Add(readDeadline)
-	connection.SetReadDeadline(deadlineTime)
-
-	go func() {
-		defer websocket.ReportClosing(connection)
-		defer close(doneChan)
-		for {
-			messageType, message, err := connection.ReadMessage()
-			if err != nil {
-				// Case from closing connection
-				if !strings.Contains(err.Error(), "i/o timeout") {
-					T.Error("Read error:", err)
-				}
-				return
-			}
-			if messageType == gorillaSocket.TextMessage {
-				var resp broadcaster.SocketData
-				if err := json.Unmarshal(message, &resp); err != nil {
-					T.Error("Client Unmarshal error:", err)
-					return
-				}
-				switch resp.MessageType {
-				case "PipelineRunCreated":
-					CreationsRecorded++
-				case "PipelineRunUpdated":
-					UpdatesRecorded++
-				case "PipelineRunDeleted":
-					DeletionsRecorded++
-				}
-				//Print out websocket data received
-				fmt.Printf("%v\n", resp)
+		t.Fatalf("Dial error connecting to %s: %s", endpoint, err)
+	}
+	client := &websocketClient{t: t, query: query, connection: connection, done: make(chan struct{})}
+	go client.read()
+	return client
+}
+
+// Reading also answers the server's pings, so the client isn't disconnected
+func (c *websocketClient) read() {
+	defer close(c.done)
+	for {
+		messageType, message, err := c.connection.ReadMessage()
+		if err != nil {
+			c.mutex.Lock()
+			closing := c.closing
+			c.mutex.Unlock()
+			if !closing {
+				c.t.Errorf("FAIL: websocket subscribed with %q terminated early: %s", c.query, err)
 			}
+			return
+		}
+		if messageType != gorillaSocket.TextMessage {
+			continue
 		}
-	}()
-	<-doneChan
-	if time.Now().Sub(deadlineTime) < 0 {
-		fmt.Printf("Websocket[%d] terminated early", identifier)
-		T.Errorf("Websocket[%d] terminated early", identifier)
+		var received receivedMessage
+		if err := json.Unmarshal(message, &received); err != nil {
+			c.t.Errorf("FAIL: client unmarshal error: %s", err)
+			return
+		}
+		c.mutex.Lock()
+		c.received = append(c.received, received)
+		c.mutex.Unlock()
 	}
 }
 
-// Util to create pipelinerun
-func (r Resource) createTestPipelineRun(name, resourceVersion string) {
+// Closes the connection if it isn't already, the server unsubscribes the client once it notices
+func (c *websocketClient) close() {
+	c.mutex.Lock()
+	closing := c.closing
+	c.closing = true
+	c.mutex.Unlock()
+	if !closing {
+		websocket.ReportClosing(c.connection)
+	}
+	<-c.done
+}
 
-	PipelineRun1 := v1alpha1.PipelineRun{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:            name,
-			ResourceVersion: resourceVersion,
-		},
-		Spec: v1alpha1.PipelineRunSpec{},
+func closeWebsocketClients(clients ...*websocketClient) {
+	for _, client := range clients {
+		client.close()
 	}
+}
 
-	fmt.Println("Creating pipelinerun")
-	_, err := r.PipelineClient.TektonV1alpha1().PipelineRuns("ns1").Create(&PipelineRun1)
-	if err != nil {
-		fmt.Printf("Error creating pipelinerun: %s: %s\n", name, err.Error())
+// The messages received so far of a type, or all of them if messageType is empty
+func (c *websocketClient) messages(messageType string) []receivedMessage {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var messages []receivedMessage
+	for _, received := range c.received {
+		if messageType == "" || received.MessageType == messageType {
+			messages = append(messages, received)
+		}
 	}
+	return messages
 }
 
-// Util to update pipelinerun
-func (r Resource) updateTestPipelineRun(name, newResourceVersion string) {
+// The messages of a type received so far about the named PipelineRun
+func (c *websocketClient) pipelineRunMessages(messageType, name string) []receivedMessage {
+	var messages []receivedMessage
+	for _, received := range c.messages(messageType) {
+		var pipelineRun v1alpha1.PipelineRun
+		if json.Unmarshal(received.Payload, &pipelineRun) == nil && pipelineRun.Name == name {
+			messages = append(messages, received)
+		}
+	}
+	return messages
+}
 
-	httpReq := dummyHttpRequest("GET", "http://wwww.dummy.com:8383/v1/namespaces/ns1/pipelinerun/"+name, nil)
-	req := dummyRestfulRequest(httpReq, "ns1", "")
-	httpWriter := httptest.NewRecorder()
-	resp := dummyRestfulResponse(httpWriter)
+func (c *websocketClient) waitForMessages(messageType string, count int) {
+	waitFor(c.t, fmt.Sprintf("%d %s messages on the websocket subscribed with %q", count, messageType, c.query), func() bool {
+		return len(c.messages(messageType)) >= count
+	})
+}
 
-	//  Test the function
-	r.getPipelineRun(req, resp)
+func (c *websocketClient) waitForPipelineRun(messageType, name string) {
+	waitFor(c.t, fmt.Sprintf("%s %s on the websocket subscribed with %q", messageType, name, c.query), func() bool {
+		return len(c.pipelineRunMessages(messageType, name)) > 0
+	})
+}
 
-	// Decode the response
-	pipelinerun := v1alpha1.PipelineRun{}
-	json.NewDecoder(httpWriter.Body).Decode(&pipelinerun)
-	pipelinerun.SetResourceVersion(newResourceVersion)
+// Checks the client was sent a snapshot of the named PipelineRuns then a bookmark, returning the bookmark's sequence number
+func checkSnapshot(t *testing.T, client *websocketClient, expected []string) uint64 {
+	waitFor(t, "a snapshot and bookmark on the websocket subscribed with "+client.query, func() bool { return len(client.messages("")) >= 2 })
+	received := client.messages("")
+	if received[0].MessageType != string(broadcaster.PipelineRunSnapshot) || received[1].MessageType != string(broadcaster.Bookmark) {
+		t.Fatalf("FAIL: expected a snapshot then a bookmark, got %v", received[:2])
+	}
+	var snapshot []v1alpha1.PipelineRun
+	json.Unmarshal(received[0].Payload, &snapshot)
+	names := []string{}
+	for _, pipelineRun := range snapshot {
+		names = append(names, pipelineRun.Name)
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("FAIL: the client subscribed with %q should have been sent a snapshot of %v, got %v", client.query, expected, names)
+	}
+	return received[1].Sequence
+}
 
-	fmt.Printf("Updating pipelinerun %s\n", name)
-	_, err := r.PipelineClient.TektonV1alpha1().PipelineRuns("ns1").Update(&pipelinerun)
-	if err != nil {
-		fmt.Printf("Error updating pipelinerun: %s: %s\n", name, err.Error())
+// Checks the client was sent the creation, update and deletion of the PipelineRun once each and in order
+func checkPipelineRunChanges(t *testing.T, client *websocketClient, name string) {
+	var sequence uint64
+	for _, messageType := range []string{string(broadcaster.PipelineRunCreated), string(broadcaster.PipelineRunUpdated), string(broadcaster.PipelineRunDeleted)} {
+		received := client.pipelineRunMessages(messageType, name)
+		if len(received) != 1 || received[0].Sequence <= sequence {
+			t.Errorf("FAIL: the client subscribed with %q should have been sent one %s of %s in order, got %v", client.query, messageType, name, received)
+			continue
+		}
+		sequence = received[0].Sequence
 	}
 }
 
-// Util to delete pipelinerun
-func (r Resource) deleteTestPipelineRun(name string) {
-	fmt.Printf("Deleting pipelinerun: %s\n", name)
-	_ = r.PipelineClient.TektonV1alpha1().PipelineRuns("ns1").Delete(name, &metav1.DeleteOptions{})
+// Fails the test if the condition isn't met within 10 seconds
+func waitFor(t *testing.T, description string, condition func() bool) {
+	deadline := time.Now().Add(time.Second * 10)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("FAIL: timed out waiting for %s", description)
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
 }
 
-// Util to setup dummy resource and TLSServer
-func setupResourceAndServer() (*Resource, *httptest.Server) {
+// Starts the PipelineRun controller, returning the function that stops it
+func (r *Resource) startTestPipelineRunController(t *testing.T) func() {
+	stopCh := make(chan struct{})
+	r.StartPipelineRunController(stopCh)
+	// The fake clientset can't replay changes made before it is watched
+	waitFor(t, "the PipelineRun controller to watch", func() bool {
+		for _, action := range r.PipelineClient.(*fakeclientset.Clientset).Actions() {
+			if action.GetVerb() == "watch" && action.GetResource().Resource == "pipelineruns" {
+				return r.pipelineRunInformer.HasSynced()
+			}
+		}
+		return false
+	})
+	return func() {
+		close(stopCh)
+	}
+}
 
-	r := dummyResource()
-	devopsContainer := restful.NewContainer()
-	r.RegisterWebsocket(devopsContainer)
-	s2 := httptest.NewTLSServer(devopsContainer)
+// Util to create pipelinerun
+func (r Resource) createTestPipelineRun(t *testing.T, namespace, name, resourceVersion string) {
+	pipelineRun := v1alpha1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       namespace,
+			ResourceVersion: resourceVersion,
+		},
+		Spec: v1alpha1.PipelineRunSpec{},
+	}
+	if _, err := r.PipelineClient.TektonV1alpha1().PipelineRuns(namespace).Create(&pipelineRun); err != nil {
+		t.Fatalf("Error creating pipelinerun %s: %s", name, err)
+	}
+}
 
-	return r, s2
+// Util to update pipelinerun, the controller only reports changes to the resource version
+func (r Resource) updateTestPipelineRun(t *testing.T, namespace, name, newResourceVersion string) {
+	pipelineRuns := r.PipelineClient.TektonV1alpha1().PipelineRuns(namespace)
+	pipelineRun, err := pipelineRuns.Get(name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting pipelinerun %s: %s", name, err)
+	}
+	pipelineRun.SetResourceVersion(newResourceVersion)
+	if _, err := pipelineRuns.Update(pipelineRun); err != nil {
+		t.Fatalf("Error updating pipelinerun %s: %s", name, err)
+	}
 }
 
-// Util to check result
-func checkTestResult(variable string, expectedValue, actualValue int) {
-	if actualValue != expectedValue {
-		fmt.Printf("%s not equal to %d, recorded only %d", variable, expectedValue, actualValue)
-		T.Errorf("%s not equal to %d, recorded only %d", variable, expectedValue, actualValue)
-		T.FailNow()
+// Util to delete pipelinerun
+func (r Resource) deleteTestPipelineRun(t *testing.T, namespace, name string) {
+	if err := r.PipelineClient.TektonV1alpha1().PipelineRuns(namespace).Delete(name, &metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Error deleting pipelinerun %s: %s", name, err)
 	}
 }
+
+// Util to setup a TLSServer for the websockets of a resource, after its controllers have started
+func setupServer(r *Resource) *httptest.Server {
+	container := restful.NewContainer()
+	r.RegisterWebsocket(container)
+	return httptest.NewTLSServer(container)
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"io/ioutil"
+	"reflect"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/ghodss/yaml"
+	logging "github.com/tektoncd/dashboard/pkg/logging"
+	"github.com/tektoncd/pipeline/pkg/client/clientset/versioned/scheme"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const mimeYAML = "application/yaml"
+const mimeXYAML = "application/x-yaml"
+
+// Reads and writes entities as YAML, for requests sent or accepting application/yaml
+type yamlEntityAccessor struct {
+	contentType string
+}
+
+// Lets every route read and write YAML alongside JSON
+func registerYAMLEntityAccessors() {
+	restful.RegisterEntityAccessor(mimeYAML, yamlEntityAccessor{contentType: mimeYAML})
+	restful.RegisterEntityAccessor(mimeXYAML, yamlEntityAccessor{contentType: mimeXYAML})
+}
+
+// YAML is converted to JSON before decoding, so entities only need JSON tags
+func (y yamlEntityAccessor) Read(request *restful.Request, v interface{}) error {
+	body, err := ioutil.ReadAll(request.Request.Body)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(body, v)
+}
+
+// Kubernetes objects are written with apiVersion and kind set so they can be used with kubectl
+func (y yamlEntityAccessor) Write(response *restful.Response, status int, v interface{}) error {
+	if v == nil {
+		response.WriteHeader(status)
+		return nil
+	}
+	if obj, ok := asRuntimeObject(v); ok {
+		setTypeMeta(obj)
+		v = obj
+	}
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	response.Header().Set(restful.HEADER_ContentType, y.contentType)
+	response.WriteHeader(status)
+	_, err = response.Write(data)
+	return err
+}
+
+// Handlers write both objects and pointers to them, only pointers implement runtime.Object
+func asRuntimeObject(v interface{}) (runtime.Object, bool) {
+	if obj, ok := v.(runtime.Object); ok {
+		return obj, true
+	}
+	pointer := reflect.New(reflect.TypeOf(v))
+	pointer.Elem().Set(reflect.ValueOf(v))
+	obj, ok := pointer.Interface().(runtime.Object)
+	return obj, ok
+}
+
+// Typed clients return objects without apiVersion and kind, so look them up in the Tekton scheme (which includes core types)
+func setTypeMeta(obj runtime.Object) {
+	setKind := func(obj runtime.Object) error {
+		gvks, _, err := scheme.Scheme.ObjectKinds(obj)
+		if err != nil || len(gvks) == 0 {
+			logging.Log.Debugf("Could not find the kind of %T: %s", obj, err)
+			return nil
+		}
+		obj.GetObjectKind().SetGroupVersionKind(gvks[0])
+		return nil
+	}
+	setKind(obj)
+	if meta.IsListType(obj) {
+		meta.EachListItem(obj, setKind)
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package endpoints
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/ghodss/yaml"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Util to send a request through the registered routes and return the status code, content type and body
+func yamlRequestTest(r *Resource, method, path, contentType, accept, body string) (int, string, string) {
+	container := restful.NewContainer()
+	r.RegisterEndpoints(container)
+
+	httpWriter := httptest.NewRecorder()
+	httpReq := dummyHttpRequest(method, "http://wwww.dummy.com:8383/v1/namespaces/ns1/"+path, strings.NewReader(body))
+	httpReq.Header.Set("Content-Type", contentType)
+	httpReq.Header.Set("Accept", accept)
+	container.ServeHTTP(httpWriter, httpReq)
+	return httpWriter.Code, httpWriter.Header().Get("Content-Type"), httpWriter.Body.String()
+}
+
+/* YAML test: objects and lists are written with apiVersion and kind when YAML is accepted */
+
+func TestGetAsYAML(t *testing.T) {
+	t.Log("Testing Tasks are returned as YAML")
+
+	r := dummyResource()
+
+	task1 := v1alpha1.Task{
+		ObjectMeta: metav1.ObjectMeta{Name: "task1"},
+		Spec: v1alpha1.TaskSpec{
+			Steps: []corev1.Container{{Name: "step1", Image: "busybox"}},
+		},
+	}
+	if _, err := r.PipelineClient.TektonV1alpha1().Tasks("ns1").Create(&task1); err != nil {
+		t.Errorf("Error creating the Task for use with TestGetAsYAML, error: %s", err)
+	}
+
+	statusCode, contentType, body := yamlRequestTest(r, "GET", "task/task1", restful.MIME_JSON, mimeYAML, "")
+	if statusCode != 200 || contentType != mimeYAML {
+		t.Fatalf("FAIL: getting a Task as YAML should have given a 200 with %s, got %d with %s: %s", mimeYAML, statusCode, contentType, body)
+	}
+	task := v1alpha1.Task{}
+	if err := yaml.Unmarshal([]byte(body), &task); err != nil {
+		t.Fatalf("FAIL: the Task could not be read as YAML: %s", err)
+	}
+	if task.APIVersion != "tekton.dev/v1alpha1" || task.Kind != "Task" || task.Name != "task1" {
+		t.Errorf("FAIL: the Task should have had its apiVersion and kind set, got: %s", body)
+	}
+
+	statusCode, _, body = yamlRequestTest(r, "GET", "task", restful.MIME_JSON, mimeXYAML, "")
+	if statusCode != 200 {
+		t.Fatalf("FAIL: getting all Tasks as YAML should have given a 200, got %d: %s", statusCode, body)
+	}
+	taskList := v1alpha1.TaskList{}
+	if err := yaml.Unmarshal([]byte(body), &taskList); err != nil {
+		t.Fatalf("FAIL: the TaskList could not be read as YAML: %s", err)
+	}
+	if taskList.Kind != "TaskList" || len(taskList.Items) != 1 || taskList.Items[0].Kind != "Task" {
+		t.Errorf("FAIL: the TaskList and its items should have had their kind set, got: %s", body)
+	}
+
+	statusCode, contentType, _ = yamlRequestTest(r, "GET", "task/task1", restful.MIME_JSON, restful.MIME_JSON, "")
+	if statusCode != 200 || contentType != restful.MIME_JSON {
+		t.Errorf("FAIL: JSON should still be returned when accepted, got %d with %s", statusCode, contentType)
+	}
+}
+
+/* YAML test: request bodies may be sent as YAML */
+
+func TestPatchWithYAML(t *testing.T) {
+	t.Log("Testing PipelineRuns can be patched with a YAML body")
+
+	r := dummyResource()
+
+	pipelineRun1 := v1alpha1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Name: "PipelineRun1"}}
+	if _, err := r.PipelineClient.TektonV1alpha1().PipelineRuns("ns1").Create(&pipelineRun1); err != nil {
+		t.Errorf("Error creating the PipelineRun for use with TestPatchWithYAML, error: %s", err)
+	}
+
+	body := `addLabels:
+  team: foo
+`
+	statusCode, contentType, response := yamlRequestTest(r, "PATCH", "pipelinerun/PipelineRun1", mimeYAML, mimeYAML, body)
+	if statusCode != 200 || contentType != mimeYAML {
+		t.Fatalf("FAIL: patching with YAML should have given a 200 with %s, got %d with %s: %s", mimeYAML, statusCode, contentType, response)
+	}
+	pipelineRun := v1alpha1.PipelineRun{}
+	if err := yaml.Unmarshal([]byte(response), &pipelineRun); err != nil || pipelineRun.Kind != "PipelineRun" || pipelineRun.Labels["team"] != "foo" {
+		t.Errorf("FAIL: the patched PipelineRun should have been returned as YAML, got: %s", response)
+	}
+}
@@ -13,6 +13,7 @@ limitations under the License.
 package websocket
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"time"
@@ -36,21 +37,36 @@ func UpgradeToWebsocket(request *restful.Request, response *restful.Response) (*
 	return connection, err
 }
 
-// Discards text messages from the peer connection
-func WriteOnlyWebsocket(connection *websocket.Conn, b *broadcaster.Broadcaster) {
-	// The underlying connection is never closed so this cannot error
-	subscriber, _ := b.Subscribe()
-	go readControl(connection, b, subscriber)
+/* Discards text messages from the peer connection, the subscription gives which messages are written.
+ * If initial isn't nil the messages it gives are written first, it is given the subscriber's sequence number
+ * and called once subscribed so the messages after it aren't missed
+ */
+func WriteOnlyWebsocket(connection *websocket.Conn, b *broadcaster.Broadcaster, subscription broadcaster.Subscription, initial func(sequence uint64) []broadcaster.SocketData) {
+	// Unsubscribes when the connection is lost
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	subscriber, err := b.SubscribeContext(ctx, subscription)
+	if err != nil {
+		// The server is shutting down
+		ReportClosing(connection)
+		return
+	}
+	go readControl(connection, cancel)
 	go poll(connection)
+	if initial != nil {
+		for _, data := range initial(subscriber.Sequence()) {
+			websocketSend(connection, data)
+		}
+	}
 	write(connection, subscriber)
 
 }
 
-func readControl(connection *websocket.Conn, b *broadcaster.Broadcaster, s *broadcaster.Subscriber) {
+func readControl(connection *websocket.Conn, cancel context.CancelFunc) {
 	for {
 		if _, _, err := connection.ReadMessage(); err != nil {
 			logging.Log.Error("Websocket connection to client lost:", err)
-			b.Unsubscribe(s)
+			cancel()
 			return
 		}
 	}
@@ -93,6 +109,8 @@ func write(connection *websocket.Conn, subscriber *broadcaster.Subscriber) {
 		case socketData := <-subChan:
 			websocketSend(connection, socketData)
 		case <-unsubChan:
+			// Also closes the connection when the subscriber is disconnected for falling behind or on shutdown
+			ReportClosing(connection)
 			return
 		}
 	}
@@ -64,6 +64,21 @@ export function cancelPipelineRun(name) {
   return put(uri, { status: 'PipelineRunCancelled' });
 }
 
+export function deletePipelineRun(name) {
+  const uri = getAPI('pipelinerun', name);
+  return deleteRequest(uri);
+}
+
+export function rerunPipelineRun(name, payload = {}) {
+  const uri = `${getAPI('pipelinerun', name)}/rerun`;
+  return post(uri, payload);
+}
+
+export function retryPipelineRun(name) {
+  const uri = `${getAPI('pipelinerun', name)}/retry`;
+  return post(uri);
+}
+
 export function getTasks() {
   const uri = getAPI('task');
   return get(uri).then(checkData);
@@ -84,6 +99,16 @@ export function getTaskRun(name) {
   return get(uri);
 }
 
+export function cancelTaskRun(name) {
+  const uri = getAPI('taskrun', name);
+  return put(uri, { status: 'TaskRunCancelled' });
+}
+
+export function deleteTaskRun(name) {
+  const uri = getAPI('taskrun', name);
+  return deleteRequest(uri);
+}
+
 export function getPipelineResources() {
   const uri = getAPI('pipelineresource');
   return get(uri).then(checkData);
@@ -105,7 +130,7 @@ export function getTaskRunLog(name) {
 }
 
 export function createPipelineRun(payload) {
-  const uri = `${apiRoot}/`;
+  const uri = getAPI('pipelinerun');
   return post(uri, payload);
 }
 
@@ -15,10 +15,13 @@ import fetchMock from 'fetch-mock';
 
 import {
   cancelPipelineRun,
+  cancelTaskRun,
   checkData,
   createCredential,
   createPipelineRun,
   deleteCredential,
+  deletePipelineRun,
+  deleteTaskRun,
   getAPI,
   getCredential,
   getCredentials,
@@ -34,6 +37,8 @@ import {
   getTaskRunLog,
   getTaskRuns,
   getTasks,
+  rerunPipelineRun,
+  retryPipelineRun,
   updateCredential
 } from '.';
 
@@ -127,6 +132,39 @@ it('cancelPipelineRun', () => {
   });
 });
 
+it('deletePipelineRun', () => {
+  const pipelineRunName = 'foo';
+  fetchMock.delete(`end:${pipelineRunName}`, 204);
+  return deletePipelineRun(pipelineRunName).then(response => {
+    expect(response).toEqual({});
+    fetchMock.restore();
+  });
+});
+
+it('rerunPipelineRun', () => {
+  const pipelineRunName = 'foo';
+  const payload = { params: [{ name: 'bar', value: 'baz' }] };
+  const data = { fake: 'pipelineRun' };
+  fetchMock.post(`end:${pipelineRunName}/rerun`, data);
+  return rerunPipelineRun(pipelineRunName, payload).then(response => {
+    expect(response).toEqual(data);
+    expect(fetchMock.lastOptions()).toMatchObject({
+      body: JSON.stringify(payload)
+    });
+    fetchMock.restore();
+  });
+});
+
+it('retryPipelineRun', () => {
+  const pipelineRunName = 'foo';
+  const data = { reruntasks: ['bar'] };
+  fetchMock.post(`end:${pipelineRunName}/retry`, data);
+  return retryPipelineRun(pipelineRunName).then(response => {
+    expect(response).toEqual(data);
+    fetchMock.restore();
+  });
+});
+
 it('getTasks', () => {
   const data = {
     items: 'tasks'
@@ -169,6 +207,29 @@ it('getTaskRun', () => {
   });
 });
 
+it('cancelTaskRun', () => {
+  const taskRunName = 'foo';
+  const payload = {
+    status: 'TaskRunCancelled'
+  };
+  fetchMock.put(`end:${taskRunName}`, 204);
+  return cancelTaskRun(taskRunName).then(() => {
+    expect(fetchMock.lastOptions()).toMatchObject({
+      body: JSON.stringify(payload)
+    });
+    fetchMock.restore();
+  });
+});
+
+it('deleteTaskRun', () => {
+  const taskRunName = 'foo';
+  fetchMock.delete(`end:${taskRunName}`, 204);
+  return deleteTaskRun(taskRunName).then(response => {
+    expect(response).toEqual({});
+    fetchMock.restore();
+  });
+});
+
 it('getPipelineResources', () => {
   const data = {
     items: 'pipelineResources'